@@ -0,0 +1,181 @@
+// Command promoctl inspects promo code dataset versions on a running API server.
+//
+// Usage:
+//
+//	promoctl [-server URL] [-admin-key KEY] versions
+//	promoctl [-server URL] [-admin-key KEY] diff -from 1 -to 2 [-limit 100]
+//	promoctl [-server URL] [-admin-key KEY] lookup -version 1 -code HAPPYHRS
+//
+// The admin endpoints it calls need one of the server's ADMIN_API_KEYS, given with -admin-key or the
+// PROMOCTL_ADMIN_KEY environment variable.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"kart-challenge/internal/domain"
+	"kart-challenge/pkg/middleware"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	server := flag.String("server", envOrDefault("PROMOCTL_SERVER", "http://localhost:8080"), "base URL of the API server")
+	adminKey := flag.String("admin-key", os.Getenv("PROMOCTL_ADMIN_KEY"), "one of the server's ADMIN_API_KEYS")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	client := &apiClient{baseURL: *server, adminKey: *adminKey, http: &http.Client{Timeout: 30 * time.Second}}
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "versions":
+		err = runVersions(client)
+	case "diff":
+		err = runDiff(client, args)
+	case "lookup":
+		err = runLookup(client, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "promoctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: promoctl [-server URL] [-admin-key KEY] <command> [flags]
+
+The admin key defaults to $PROMOCTL_ADMIN_KEY and the server to $PROMOCTL_SERVER.
+
+Commands:
+  versions                        list retained promo dataset versions
+  diff -from N -to M [-limit L]   show codes added, removed and changed in validity
+  lookup -version N -code CODE    check whether a code was valid in a version
+`)
+}
+
+func runVersions(client *apiClient) error {
+	var versions []domain.PromoDatasetVersion
+	if err := client.get("/api/v1/admin/promo_code/versions", nil, &versions); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLOADED AT\tUNIQUE\tVALID\tSOURCES")
+	for _, v := range versions {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\n", v.ID, v.LoadedAt.Format(time.RFC3339), v.UniqueCodes, v.ValidCodes, len(v.Sources))
+	}
+	return w.Flush()
+}
+
+func runDiff(client *apiClient, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	from := fs.Int("from", 0, "older version ID")
+	to := fs.Int("to", 0, "newer version ID")
+	limit := fs.Int("limit", 100, "maximum codes listed per section")
+	fs.Parse(args)
+	if *from <= 0 || *to <= 0 {
+		return fmt.Errorf("diff requires -from and -to")
+	}
+
+	query := url.Values{}
+	query.Set("from", fmt.Sprint(*from))
+	query.Set("to", fmt.Sprint(*to))
+	query.Set("limit", fmt.Sprint(*limit))
+
+	var diff domain.PromoDatasetDiff
+	if err := client.get("/api/v1/admin/promo_code/versions/diff", query, &diff); err != nil {
+		return err
+	}
+
+	fmt.Printf("Diff v%d -> v%d\n", diff.From, diff.To)
+	fmt.Printf("\nAdded (%d):\n", diff.TotalAdded)
+	for _, code := range diff.Added {
+		fmt.Printf("  + %s\n", code)
+	}
+	fmt.Printf("\nRemoved (%d):\n", diff.TotalRemoved)
+	for _, code := range diff.Removed {
+		fmt.Printf("  - %s\n", code)
+	}
+	fmt.Printf("\nValidity changed (%d):\n", diff.TotalValidityChanged)
+	for _, change := range diff.ValidityChanged {
+		fmt.Printf("  ~ %s: valid %t -> %t\n", change.Code, change.WasValid, change.IsValid)
+	}
+	if diff.Truncated {
+		fmt.Printf("\n(output truncated to %d codes per section, use -limit to see more)\n", *limit)
+	}
+	return nil
+}
+
+func runLookup(client *apiClient, args []string) error {
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	version := fs.Int("version", 0, "version ID")
+	code := fs.String("code", "", "promo code")
+	fs.Parse(args)
+	if *version <= 0 || *code == "" {
+		return fmt.Errorf("lookup requires -version and -code")
+	}
+
+	var lookup domain.PromoCodeVersionLookup
+	path := fmt.Sprintf("/api/v1/admin/promo_code/versions/%d/codes/%s", *version, url.PathEscape(*code))
+	if err := client.get(path, nil, &lookup); err != nil {
+		return err
+	}
+	fmt.Printf("%s in v%d: found=%t sources=%d valid=%t\n", lookup.PromoCode, lookup.Version, lookup.Found, lookup.Sources, lookup.Valid)
+	return nil
+}
+
+type apiClient struct {
+	baseURL  string
+	adminKey string // Sent in the X-Admin-Key header
+	http     *http.Client
+}
+
+func (c *apiClient) get(path string, query url.Values, out any) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	req.Header.Set(middleware.AdminKeyHeader, c.adminKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr domain.ErrorResponse
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("server returned %d: %s", resp.StatusCode, apiErr.Message)
+		}
+		return fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
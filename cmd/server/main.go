@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"kart-challenge/internal/app"
 	order "kart-challenge/internal/orders"
+	"kart-challenge/internal/pricing"
 	product "kart-challenge/internal/products"
 	promo "kart-challenge/internal/promos"
 	"kart-challenge/pkg/config"
+	"kart-challenge/pkg/idempotency"
 	"kart-challenge/pkg/middleware"
+	"kart-challenge/pkg/ratelimit"
 	"log"
-	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // Embedded time zone data, so campaign schedules work on hosts without it
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -20,8 +27,41 @@ import (
 func main() {
 	cfg := config.LoadConfig()
 
+	// ctx is cancelled on SIGINT/SIGTERM, which also aborts an in-flight promo code load.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Orders, promo code redemptions and idempotency keys live in this process unless a database is
+	// configured, which keeps them across restarts and shares them between instances.
+	var db *sql.DB
+	if cfg.DatabaseURL != "" {
+		var err error
+		if db, err = openDatabase(cfg.DatabaseURL); err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		defer db.Close()
+	}
+
 	// PROMO CODE MODULE
-	promoCodeService := promo.NewService(cfg.MaxFileSizeMB, cfg.Environment, cfg.LocalCouponDirPath)
+	campaigns := promo.DefaultCampaigns
+	if cfg.CampaignsFilePath != "" {
+		loaded, err := promo.LoadCampaignsFromFile(cfg.CampaignsFilePath)
+		if err != nil {
+			log.Fatalf("Failed to load promo campaigns: %v", err)
+		}
+		campaigns = loaded
+		log.Printf("Loaded %d promo campaigns from '%s'", len(campaigns), cfg.CampaignsFilePath)
+	}
+
+	promoCodeService := promo.NewService(promo.Config{
+		MaxDecompressedFileSizeMB: cfg.MaxFileSizeMB,
+		Environment:               cfg.Environment,
+		LocalCouponDirPath:        cfg.LocalCouponDirPath,
+		Integrity:                 promoIntegrityConfig(cfg),
+		MaxDatasetVersions:        cfg.MaxDatasetVersions,
+		Campaigns:                 campaigns,
+		Redemptions:               redemptionStore(db, cfg.PromoReservationTimeout),
+	})
 
 	defer func() {
 		if err := promoCodeService.Close(); err != nil {
@@ -30,17 +70,49 @@ func main() {
 	}()
 
 	// --- Initial Data Loading ---
-	if err := promoCodeService.LoadPromoCodesFromURLs(cfg.CouponFileURLs); err != nil {
-		log.Fatalf("Fatal error during initial promo code loading: %v", err)
-		// In production, consider non-fatal error here if server can function partially.
-	}
+	// The load runs in the background so products and orders are served straight away;
+	// /readyz reports 503 until it completes and promo validation follows PROMO_NOT_LOADED_POLICY.
+	// A failed load is retried with backoff until it succeeds or the server shuts down.
+	loadDone := make(chan struct{})
+	go func() {
+		defer close(loadDone)
+		loadPromoCodesWithRetry(ctx, promoCodeService, cfg.CouponFileURLs)
+	}()
 
 	// PRODUCT MODULE
 	// productService := product.NewInMemoryProductService()
 	// Product Service (uses in-memory repository internally)
 	productService := product.NewService(product.NewInMemoryProductRepository())
+	// PRICING MODULE
+	pricingService := pricing.NewService(pricing.Config{
+		TaxRatePercent:            cfg.TaxRatePercent,
+		MaxStackedPromotions:      cfg.MaxStackedPromotions,
+		MaxStackedDiscountPercent: cfg.MaxStackedDiscountPercent,
+	})
+	orderRepository := order.NewInMemoryOrderRepository()
+	idempotencyStore := idempotency.NewMemoryStore()
+	if db != nil {
+		var err error
+		if orderRepository, err = order.NewPostgresOrderRepository(db); err != nil {
+			log.Fatalf("Failed to set up order repository: %v", err)
+		}
+		if idempotencyStore, err = idempotency.NewPostgresStore(db); err != nil {
+			log.Fatalf("Failed to set up idempotency key store: %v", err)
+		}
+		log.Println("Orders, promo code redemptions and idempotency keys are stored in PostgreSQL.")
+	}
 	// ORDER MODULE
-	orderService := order.NewService(order.NewInMemoryOrderRepository(), productService, promoCodeService)
+	orderService := order.NewService(orderRepository, productService, promoCodeService, pricingService, order.Config{
+		InvalidCouponPolicy: cfg.InvalidCouponPolicy,
+		Cart: order.CartPolicy{
+			MergeDuplicateLines: cfg.CartMergeDuplicateLines,
+			MaxQuantityPerLine:  cfg.CartMaxQuantityPerLine,
+			MaxDistinctItems:    cfg.CartMaxDistinctItems,
+		},
+		EventReplaySize: cfg.OrderEventsReplaySize,
+	})
+	// Cancelled and refunded orders whose stock or promo code uses could not be given back are retried.
+	go retryPendingReturns(ctx, orderService)
 
 	fiberApp := fiber.New(fiber.Config{
 		AppName: "Food Ordering API Server",
@@ -49,9 +121,32 @@ func main() {
 	fiberApp.Use(logger.New())
 
 	handlers := &app.Handlers{
-		PromoHandler:   promo.NewHandler(promoCodeService),
+		PromoHandler: promo.NewHandler(promoCodeService, productService, promo.NotLoadedPolicy{
+			Mode:       cfg.PromoNotLoadedPolicy,
+			RetryAfter: cfg.PromoNotLoadedRetryAfter,
+		}),
 		ProductHandler: product.NewHandler(productService),
 		OrderHandler:   order.NewHandler(orderService),
+		HealthHandler:  app.NewHealthHandler(promoCodeService),
+		// Counters live in this process; pass ratelimit.NewStorageStore(<shared fiber.Storage>) to share them across instances.
+		PromoGuard: middleware.NewBruteForceMiddleware(middleware.BruteForceConfig{
+			Store:                ratelimit.NewMemoryStore(),
+			Window:               time.Minute,
+			MaxRequestsPerIP:     cfg.PromoRateLimitPerIP,
+			MaxRequestsPerAPIKey: cfg.PromoRateLimitPerAPIKey,
+			FailureWindow:        cfg.PromoLockoutDuration,
+			DelayAfterFailures:   5,
+			BaseDelay:            time.Second,
+			MaxDelay:             30 * time.Second,
+			LockoutAfterFailures: cfg.PromoLockoutAfterFailures,
+			LockoutDuration:      cfg.PromoLockoutDuration,
+			TrustedAPIKeys:       cfg.PromoTrustedAPIKeys,
+		}),
+		AdminAuth: middleware.NewAdminAuthMiddleware(cfg.AdminAPIKeys),
+		OrderDedup: middleware.NewIdempotencyMiddleware(middleware.IdempotencyConfig{
+			Store: idempotencyStore,
+			TTL:   cfg.IdempotencyKeyTTL,
+		}),
 	}
 
 	app.RegisterAPIRoutes(fiberApp, handlers)
@@ -74,18 +169,124 @@ func main() {
 	}()
 
 	// --- Graceful Shutdown ---
-	// Create a channel to listen for OS signals
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-
-	<-c // Block until a signal is received
+	<-ctx.Done() // Block until a signal is received
 	log.Println("Shutting down server gracefully...")
 
-	// Attempt to gracefully shut down the Fiber app
-	if err := fiberApp.Shutdown(); err != nil {
+	// Attempt to gracefully shut down the Fiber app. Order event streams may never end on their own, so
+	// connections still open after the timeout are closed.
+	if err := fiberApp.ShutdownWithTimeout(10 * time.Second); err != nil {
 		// Log the error but don't use Fatalf, allowing the program to exit
 		log.Printf("Fiber app shutdown error: %v", err)
 	}
 
+	// Wait for an in-flight promo load to observe the cancellation and clean up its temporary files.
+	<-loadDone
+
 	log.Println("Server gracefully stopped.")
 }
+
+// Delays between attempts at the initial promo code load. Each failure doubles the delay up to the maximum.
+const (
+	initialLoadRetryDelay    = 5 * time.Second
+	maxInitialLoadRetryDelay = 5 * time.Minute
+)
+
+// loadPromoCodesWithRetry loads the promo code dataset, retrying failed loads until one succeeds or
+// ctx is cancelled.
+func loadPromoCodesWithRetry(ctx context.Context, service promo.Service, urls []string) {
+	delay := initialLoadRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := service.LoadPromoCodesFromURLs(ctx, urls)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			log.Println("Initial promo code loading cancelled by shutdown.")
+			return
+		}
+		log.Printf("ERROR: Initial promo code loading failed (attempt %d), retrying in %s: %v", attempt, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Initial promo code loading cancelled by shutdown.")
+			return
+		case <-timer.C:
+		}
+		delay = min(delay*2, maxInitialLoadRetryDelay)
+	}
+}
+
+// pendingReturnsRetryInterval is how often the returns of cancelled and refunded orders that failed
+// are retried.
+const pendingReturnsRetryInterval = time.Minute
+
+// retryPendingReturns retries the failed returns of cancelled and refunded orders until ctx is cancelled.
+func retryPendingReturns(ctx context.Context, service order.Service) {
+	ticker := time.NewTicker(pendingReturnsRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		settled, err := service.RetryPendingReturns()
+		if err != nil {
+			log.Printf("ERROR: Retrying the returns of cancelled and refunded orders: %v", err)
+		}
+		if settled > 0 {
+			log.Printf("INFO: Gave back the pending returns of %d orders", settled)
+		}
+	}
+}
+
+// openDatabase opens a PostgreSQL connection pool and checks that the database is reachable.
+func openDatabase(databaseURL string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(10)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
+
+// redemptionStore returns the PostgreSQL redemption store when a database is configured, and nil
+// (an in-memory store) otherwise.
+func redemptionStore(db *sql.DB, reservationTimeout time.Duration) promo.RedemptionStore {
+	if db == nil {
+		return nil
+	}
+	store, err := promo.NewPostgresRedemptionStore(db, reservationTimeout)
+	if err != nil {
+		log.Fatalf("Failed to set up promo code redemption store: %v", err)
+	}
+	return store
+}
+
+// promoIntegrityConfig merges the per-file checksum and signature settings into one entry per source.
+func promoIntegrityConfig(cfg *config.Appconfig) promo.IntegrityConfig {
+	sources := make(map[string]promo.SourceIntegrity)
+	for name, sum := range cfg.CouponFileSHA256 {
+		entry := sources[name]
+		entry.SHA256 = sum
+		sources[name] = entry
+	}
+	for name, sigURL := range cfg.CouponFileSignatures {
+		entry := sources[name]
+		entry.SignatureURL = sigURL
+		sources[name] = entry
+	}
+	return promo.IntegrityConfig{
+		Sources:       sources,
+		PublicKey:     cfg.CouponSigningPublicKey,
+		QuarantineDir: cfg.QuarantineDirPath,
+	}
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"kart-challenge/internal/promos"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HealthHandler serves the liveness and readiness probes.
+type HealthHandler struct {
+	PromoService promos.Service
+}
+
+// NewHealthHandler creates a new HealthHandler.
+func NewHealthHandler(promoService promos.Service) *HealthHandler {
+	return &HealthHandler{PromoService: promoService}
+}
+
+// Healthz handles GET /healthz. The process is alive as long as it can answer.
+func (h *HealthHandler) Healthz(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ok"})
+}
+
+// Readyz handles GET /readyz and reports 503 until the promo code dataset has loaded.
+func (h *HealthHandler) Readyz(c *fiber.Ctx) error {
+	load := h.PromoService.GetLoadStatus()
+	checks := fiber.Map{"promo_codes": load.State}
+
+	if !h.PromoService.IsReady() {
+		body := fiber.Map{"status": "not_ready", "checks": checks}
+		if load.Error != "" {
+			body["error"] = load.Error
+		}
+		return c.Status(fiber.StatusServiceUnavailable).JSON(body)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ready", "checks": checks})
+}
@@ -12,26 +12,51 @@ type Handlers struct {
 	PromoHandler   *promos.Handler
 	ProductHandler *products.Handler
 	OrderHandler   *orders.Handler
+	HealthHandler  *HealthHandler
+	PromoGuard     fiber.Handler // Brute-force protection for the promo validation endpoints
+	OrderDedup     fiber.Handler // Idempotency-Key handling for order creation
+	AdminAuth      fiber.Handler // Admin key check for the admin and debug endpoints
 }
 
 func RegisterAPIRoutes(app *fiber.App, h *Handlers) {
+	// Probes live outside the versioned API so orchestrators can rely on stable paths.
+	app.Get("/healthz", h.HealthHandler.Healthz)
+	app.Get("/readyz", h.HealthHandler.Readyz)
+
 	v1 := app.Group("/api/v1")
 
 	// PromoCode APIs
-	v1.Post("/promo_code/validate", h.PromoHandler.ValidatePromoCode)
+	v1.Post("/promo_code/validate", h.PromoGuard, h.PromoHandler.ValidatePromoCode)
+	v1.Post("/promo_code/validate\\:batch", h.PromoGuard, h.PromoHandler.BatchValidatePromoCodes)
+	v1.Get("/promo_code/:code/usage", h.PromoHandler.GetPromoCodeUsage)
 
 	// Product API
 	v1.Get("/products", h.ProductHandler.GetProducts)
 	v1.Get("/products/:id", h.ProductHandler.GetProductByID)
 
 	// Order API
-	v1.Post("/orders", h.OrderHandler.CreateOrder)
+	v1.Get("/orders", h.AdminAuth, h.OrderHandler.ListOrders) // Lists every customer's orders
+	v1.Post("/orders", h.PromoGuard, h.OrderDedup, h.OrderHandler.CreateOrder)
+	v1.Post("/orders/quote", h.PromoGuard, h.OrderHandler.QuoteOrder)
+	v1.Get("/orders/events", h.AdminAuth, h.OrderHandler.StreamOrderEvents) // Before /orders/:id, which would match it too
 	v1.Get("/orders/:id", h.OrderHandler.GetOrderByID)
-
-	// --- Admin/Debug Endpoints (Optional for internal/testing) ---
-	// These would typically be secured with proper authentication/authorization
-	// and likely live under a separate admin API group.
-	// v1.Post("/admin/promo_code/reload", h.PromoHandler.ReloadPromoCodes) // Assuming a reload method in handler for admin trigger
-	// v1.Get("/debug/promo_codes", h.PromoHandler.GetPromoCodeCountsHandler)
-	v1.Get("/debug/orders", h.OrderHandler.GetAllOrders)
+	v1.Get("/orders/:id/events", h.AdminAuth, h.OrderHandler.StreamEventsForOrder)
+	v1.Patch("/orders/:id/status", h.AdminAuth, h.OrderHandler.UpdateOrderStatus)
+	v1.Post("/orders/:id/cancel", h.AdminAuth, h.OrderHandler.CancelOrder)
+	v1.Post("/orders/:id/refunds", h.AdminAuth, h.OrderHandler.RefundOrder)
+
+	// --- Admin/Debug Endpoints ---
+	// Only requests with one of the ADMIN_API_KEYS in the X-Admin-Key header get through.
+	// admin.Post("/promo_code/reload", h.PromoHandler.ReloadPromoCodes) // Assuming a reload method in handler for admin trigger
+	// v1.Get("/debug/promo_codes", h.AdminAuth, h.PromoHandler.GetPromoCodeCountsHandler)
+	admin := v1.Group("/admin", h.AdminAuth)
+	admin.Get("/promo_code/status", h.PromoHandler.GetLoadStatus)
+	admin.Get("/promo_code/status/stream", h.PromoHandler.StreamLoadStatus)
+	admin.Get("/promo_code/rechecks", h.PromoHandler.GetRechecks)
+	admin.Get("/promo_code/:code/usage", h.PromoHandler.GetCustomerPromoCodeUsage)
+	admin.Get("/promo_code/versions", h.PromoHandler.ListDatasetVersions)
+	admin.Get("/promo_code/versions/diff", h.PromoHandler.DiffDatasetVersions)
+	admin.Get("/promo_code/versions/:version/codes/:code", h.PromoHandler.LookupCodeInVersion)
+	admin.Put("/products/:id/availability", h.ProductHandler.SetAvailability)
+	v1.Get("/debug/orders", h.AdminAuth, h.OrderHandler.GetAllOrders) // Deprecated: use GET /orders
 }
@@ -4,13 +4,34 @@ import "errors"
 
 // Define custom errors for common scenarios to allow for better error handling and mapping to HTTP responses.
 var (
-	ErrProductNotFound         = errors.New("product not found")
-	ErrProductUnavailable      = errors.New("product is currently unavailable")
-	ErrInvalidQuantity         = errors.New("quantity for product must be positive")
-	ErrOrderNotFound           = errors.New("order not found")
-	ErrInvalidPromoCode        = errors.New("invalid promo code") // More specific message handled by service
-	ErrPromoCodeLength         = errors.New("promo code length invalid")
-	ErrPromoCodeNotEnoughFiles = errors.New("promo code not found in enough files")
-	ErrInvalidRequestPayload   = errors.New("invalid request payload")
-	ErrInternalServerError     = errors.New("internal server error")
+	ErrProductNotFound          = errors.New("product not found")
+	ErrProductUnavailable       = errors.New("product is currently unavailable")
+	ErrInvalidQuantity          = errors.New("quantity for product must be positive")
+	ErrOrderNotFound            = errors.New("order not found")
+	ErrInvalidPromoCode         = errors.New("invalid promo code") // More specific message handled by service
+	ErrPromoCodeLength          = errors.New("promo code length invalid")
+	ErrPromoCodeNotEnoughFiles  = errors.New("promo code not found in enough files")
+	ErrSourceVerificationFailed = errors.New("coupon source failed integrity verification")
+	ErrDatasetVersionNotFound   = errors.New("promo dataset version not found")
+	ErrPromoCodesNotLoaded      = errors.New("promo codes are still loading, please retry shortly")
+	ErrInvalidCampaign          = errors.New("invalid campaign definition")
+	ErrPromoCodeExhausted       = errors.New("promo code has reached its usage limit")
+	ErrPromoCodeCustomerLimit   = errors.New("promo code usage limit reached for this customer")
+	ErrCustomerIDRequired       = errors.New("customer_id is required to redeem this promo code")
+	ErrReservationNotFound      = errors.New("promo code reservation not found")
+	ErrCouponRejected           = errors.New("coupon could not be applied")
+	ErrInvalidOrderStatus       = errors.New("unknown order status")
+	ErrInvalidStatusTransition  = errors.New("order status transition not allowed")
+	ErrOrderVersionConflict     = errors.New("order was modified concurrently")
+	ErrInvalidRefund            = errors.New("refund items must be on the order and within the quantity not yet refunded")
+	ErrNothingToRefund          = errors.New("nothing left to refund on this order")
+	ErrInvalidOrderQuery        = errors.New("invalid order query")
+	ErrInvalidOrderItems        = errors.New("invalid order items")
+	ErrInvalidModifiers         = errors.New("invalid modifier selection")
+	ErrInvalidStock             = errors.New("stock must not be negative")
+	ErrOrderTotalTooLarge       = errors.New("order total is too large")
+	ErrConflictingStock         = errors.New("stock cannot be set while untracking it")
+	ErrOrderReturnsPending      = errors.New("the order change is stored, but giving back its stock or promo code uses failed and will be retried")
+	ErrInvalidRequestPayload    = errors.New("invalid request payload")
+	ErrInternalServerError      = errors.New("internal server error")
 )
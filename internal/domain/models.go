@@ -1,54 +1,593 @@
 package domain
 
+import "time"
+
 type ValidatePromoteCodeRequest struct {
 	PromoteCode string `json:"promote_code" validate:"required"`
+	CustomerID  string `json:"customer_id,omitempty"` // Checks per-customer usage limits when set
+	// Checks the code's campaign conditions against this cart when set. Only product_id and quantity are read.
+	Items []OrderLineItem `json:"items,omitempty"`
 }
 
 type ValidatePromoCodeResponse struct {
-	PromoCode string `json:"promo_code"`
-	Valid     bool   `json:"valid"`
-	Message   string `json:"message"`
+	PromoCode       string                `json:"promo_code"`
+	Valid           bool                  `json:"valid"`
+	Reason          string                `json:"reason"` // One of the PromoReason values
+	Message         string                `json:"message"`
+	Applicable      *bool                 `json:"applicable,omitempty"`       // Whether the cart meets the campaign conditions, when items were sent
+	Discount        *PromoDiscountPreview `json:"discount,omitempty"`         // What the code is worth, when a campaign matches
+	RecheckRequired bool                  `json:"recheck_required,omitempty"` // Accepted before the dataset finished loading
+}
+
+// BatchValidatePromoCodesRequest is the JSON body of the batch validation endpoint.
+type BatchValidatePromoCodesRequest struct {
+	PromoCodes []string `json:"promo_codes"`
+	CustomerID string   `json:"customer_id,omitempty"` // Applies to every code in the batch
+}
+
+// BatchValidatePromoCodesResponse holds one result per requested code, in request order.
+type BatchValidatePromoCodesResponse struct {
+	Results []ValidatePromoCodeResponse `json:"results"`
+	Valid   int                         `json:"valid"`
+	Invalid int                         `json:"invalid"`
+}
+
+// Machine-readable promo code validation reasons. Messages may change; these values do not.
+const (
+	PromoReasonValid                = "VALID"
+	PromoReasonTooShort             = "TOO_SHORT"
+	PromoReasonTooLong              = "TOO_LONG"
+	PromoReasonNotEnoughSources     = "NOT_ENOUGH_SOURCES"
+	PromoReasonNotLoaded            = "NOT_LOADED"
+	PromoReasonNotStarted           = "NOT_STARTED"    // The campaign has not started yet
+	PromoReasonExpired              = "EXPIRED"        // The campaign has ended
+	PromoReasonOutsideWindow        = "OUTSIDE_WINDOW" // The campaign is not active at this time of day/week
+	PromoReasonExhausted            = "EXHAUSTED"
+	PromoReasonCustomerLimitReached = "CUSTOMER_LIMIT_REACHED"
+	PromoReasonCustomerIDRequired   = "CUSTOMER_ID_REQUIRED"
+	PromoReasonNotApplicable        = "NOT_APPLICABLE" // The code is valid but the cart does not meet its campaign conditions
+)
+
+// PromoValidationResult is the outcome of validating a promo code.
+type PromoValidationResult struct {
+	Valid    bool
+	Reason   string // One of the PromoReason values
+	Message  string
+	Campaign *Campaign // The campaign the code belongs to, if any
+	// Applicable is set when the code was checked against a cart. A valid code whose campaign conditions
+	// the cart does not meet stays Valid with reason PromoReasonNotApplicable.
+	Applicable *bool
+}
+
+// PromoDiscountPreview describes what a campaign is worth, without revealing which codes it matches.
+type PromoDiscountPreview struct {
+	CampaignID    string  `json:"campaign_id"`
+	CampaignName  string  `json:"campaign_name,omitempty"`
+	Type          string  `json:"type"`
+	PercentOff    float64 `json:"percent_off,omitempty"`
+	AmountOff     Money   `json:"amount_off,omitempty"`
+	FreeProductID string  `json:"free_product_id,omitempty"`
+	ProductID     string  `json:"product_id,omitempty"`
+	BuyQuantity   int     `json:"buy_quantity,omitempty"`
+	GetQuantity   int     `json:"get_quantity,omitempty"`
+}
+
+// CouponOutcome tells the customer whether a coupon on an order was applied and, if not, why.
+type CouponOutcome struct {
+	Code       string `json:"code"`
+	CampaignID string `json:"campaign_id,omitempty"`
+	Applied    bool   `json:"applied"`
+	Reason     string `json:"reason"` // One of the PromoReason or PromotionDropped values
+	Message    string `json:"message"`
+}
+
+// Reasons a promotion the order qualified for was not applied.
+const (
+	PromotionDroppedNotCombinable = "NOT_COMBINABLE" // An exclusive promotion and the others cannot both apply
+	PromotionDroppedStackLimit    = "STACK_LIMIT"    // More stackable promotions than the order may combine
+	PromotionDroppedNoDiscount    = "NO_DISCOUNT"    // Nothing in the order qualifies for it
+	PromotionDroppedDuplicate     = "DUPLICATE"      // Its campaign is already on the order through another code
+	PromotionDroppedNotApplicable = "NOT_APPLICABLE" // The cart does not meet its conditions
+)
+
+// AppliedPromotion is a promotion that contributed to an order's discount.
+type AppliedPromotion struct {
+	CampaignID   string `json:"campaign_id"`
+	CampaignName string `json:"campaign_name,omitempty"`
+	Code         string `json:"code,omitempty"` // Empty for automatic promotions
+	Discount     Money  `json:"discount"`
+	Capped       bool   `json:"capped,omitempty"` // Reduced by the cap on the combined discount
+}
+
+// DroppedPromotion is a promotion the order qualified for but that was not applied.
+type DroppedPromotion struct {
+	CampaignID string `json:"campaign_id"`
+	Code       string `json:"code,omitempty"`
+	Reason     string `json:"reason"` // One of the PromotionDropped values
+	Message    string `json:"message"`
 }
 
 type Product struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name`
-	Price    float64 `json:"price"`
-	Category string  `json:"category"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Price    Money  `json:"price"`
+	Category string `json:"category"`
+
+	ModifierGroups []ModifierGroup `json:"modifier_groups,omitempty"`
+
+	// Available is false while the product is sold out. Stock counts the units left when the
+	// product's stock is tracked and is nil otherwise.
+	Available bool `json:"available"`
+	Stock     *int `json:"stock,omitempty"`
 
 	// Description string  `json:"description,omitempty"`
 	// Category    string  `json:"category"`
 	// ImageUrl    string  `json:"image_url,omitempty"`
-	// Available   bool    `json:"available"`
 }
 
+// InStock reports whether quantity units of the product can be ordered.
+func (p Product) InStock(quantity int) bool {
+	return p.Available && (p.Stock == nil || *p.Stock >= quantity)
+}
+
+// SetAvailabilityRequest is the body of PUT /admin/products/:id/availability. Fields left out leave
+// the product as it is: without available it keeps its availability, and a stock count starts or
+// updates tracking while without one the stock is kept, unless UntrackStock stops tracking it.
+type SetAvailabilityRequest struct {
+	Available    *bool `json:"available,omitempty"`
+	Stock        *int  `json:"stock,omitempty"`
+	UntrackStock bool  `json:"untrack_stock,omitempty"`
+}
+
+// ModifierGroup is a set of options a customer picks from when ordering a product, e.g. a size or
+// toppings. Customers pick between MinSelections and MaxSelections different options; a required
+// group needs at least one. MaxSelections 0 allows any number.
+type ModifierGroup struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Required      bool             `json:"required"`
+	MinSelections int              `json:"min_selections"`
+	MaxSelections int              `json:"max_selections"`
+	Options       []ModifierOption `json:"options"`
+}
+
+// ModifierOption is one choice in a modifier group. PriceDelta is added to the product price for each
+// unit ordered. It may be negative (e.g. a small size); the unit price never goes below zero.
+type ModifierOption struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	PriceDelta Money  `json:"price_delta"`
+}
+
+// SelectedModifier is an option chosen on an order line. Clients send the group and option IDs;
+// the name and price delta are filled in from the product when the order is created.
+type SelectedModifier struct {
+	GroupID    string `json:"group_id"`
+	OptionID   string `json:"option_id"`
+	Name       string `json:"name,omitempty"`
+	PriceDelta Money  `json:"price_delta"`
+}
+
+// OrderLineItem is a product and quantity in an order. The price fields are filled in by the
+// pricing engine when the order is created; values sent by clients are ignored.
 type OrderLineItem struct {
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name,omitempty"` // Name at order time, kept when the catalog changes
+	Quantity    int    `json:"quantity"`
+	UnitPrice   Money  `json:"unit_price"` // Price at order time, including modifiers
+	Subtotal    Money  `json:"subtotal"`   // UnitPrice x Quantity
+	Discount    Money  `json:"discount"`   // Share of the order discount allocated to this line
+
+	Modifiers []SelectedModifier `json:"modifiers,omitempty"`
+
+	RefundedQuantity int `json:"refunded_quantity,omitempty"`
+}
+
+type Order struct {
+	ID         string          `json:"id"`
+	CustomerID string          `json:"customer_id,omitempty"`
+	Items      []OrderLineItem `json:"items"`
+	Products   []Product       `json:"products"`
+	PromoCode  string          `json:"promo_code,omitempty"` // First applied coupon code
+	Coupons    []CouponOutcome `json:"coupons,omitempty"`    // One per coupon code in the request
+
+	Promotions        []AppliedPromotion `json:"promotions,omitempty"`
+	DroppedPromotions []DroppedPromotion `json:"dropped_promotions,omitempty"`
+
+	Warnings   []string `json:"warnings,omitempty"`
+	Total      Money    `json:"total"`    // Sum of line subtotals
+	Discount   Money    `json:"discount"` // Sum of line discounts
+	Tax        Money    `json:"tax"`
+	FinalPrice Money    `json:"final_price"` // Total - Discount + Tax
+
+	Status        string              `json:"status"`  // One of the OrderStatus values
+	Version       int                 `json:"version"` // Incremented on every update, for optimistic concurrency
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+	StatusHistory []OrderStatusChange `json:"status_history"` // Oldest first, starting with the creation
+
+	Refunds       []Refund `json:"refunds,omitempty"`
+	RefundedTotal Money    `json:"refunded_total"` // Sum of the refunds, never more than FinalPrice
+	// OrderReturn steps still to do after the order was cancelled or refunded; see OrderReturnStock.
+	PendingReturns []string `json:"pending_returns,omitempty"`
+}
+
+// What a cancelled or refunded order gives back. The steps are recorded on the order in the same
+// transaction that cancels or refunds it and removed once done, so a failed step is retried later.
+const (
+	OrderReturnStock       = "stock"       // The units it took out of stock
+	OrderReturnRedemptions = "redemptions" // The promo code uses it redeemed
+)
+
+// OrderQuote is the price breakdown an order request would get if it were placed now.
+type OrderQuote struct {
+	Items             []OrderLineItem    `json:"items"`
+	Products          []Product          `json:"products"`
+	Coupons           []CouponOutcome    `json:"coupons,omitempty"`
+	Promotions        []AppliedPromotion `json:"promotions,omitempty"`
+	DroppedPromotions []DroppedPromotion `json:"dropped_promotions,omitempty"`
+	Warnings          []string           `json:"warnings,omitempty"`
+	Total             Money              `json:"total"`
+	Discount          Money              `json:"discount"`
+	Tax               Money              `json:"tax"`
+	FinalPrice        Money              `json:"final_price"`
+}
+
+// Order statuses. An order moves pending -> confirmed -> preparing -> ready -> completed; it can be
+// cancelled until it is ready and refunded once completed.
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusConfirmed = "confirmed"
+	OrderStatusPreparing = "preparing"
+	OrderStatusReady     = "ready"
+	OrderStatusCompleted = "completed"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+)
+
+// OrderStatusChange records one status transition of an order.
+type OrderStatusChange struct {
+	From      string    `json:"from,omitempty"` // Empty for the initial status
+	To        string    `json:"to"`
+	ChangedBy string    `json:"changed_by"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Order event types, sent as the event name on order event streams.
+const (
+	OrderEventCreated       = "order.created"
+	OrderEventStatusChanged = "order.status_changed"
+)
+
+// OrderEvent is published when an order is created or changes status. IDs count up from 1 in every
+// run of the server.
+type OrderEvent struct {
+	ID         uint64             `json:"id"`
+	Type       string             `json:"type"`
+	OrderID    string             `json:"order_id"`
+	Change     *OrderStatusChange `json:"change,omitempty"` // Set for status changes
+	Order      Order              `json:"order"`            // The order after the change
+	OccurredAt time.Time          `json:"occurred_at"`
+}
+
+// Refund is money returned for some or all units of an order.
+type Refund struct {
+	ID         string       `json:"id"`
+	Items      []RefundLine `json:"items"`
+	Amount     Money        `json:"amount"` // Discounted price of the refunded units
+	Tax        Money        `json:"tax"`    // Share of the order tax on Amount
+	Total      Money        `json:"total"`  // Amount + Tax
+	RefundedBy string       `json:"refunded_by"`
+	Reason     string       `json:"reason,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// RefundLine is the part of a refund for one order line.
+type RefundLine struct {
 	ProductID string `json:"product_id"`
 	Quantity  int    `json:"quantity"`
-	// Price     float64 `json:"price"`
+	Amount    Money  `json:"amount"`
 }
 
-type Order struct {
-	ID       string          `json:"id"`
-	Items    []OrderLineItem `json:"items"`
-	Products []Product       `json:"products"`
+// RefundItem asks to refund a quantity of a product on the order.
+type RefundItem struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// CancelOrderRequest is the body of POST /orders/:id/cancel.
+type CancelOrderRequest struct {
+	ChangedBy string `json:"-"` // See UpdateOrderStatusRequest.ChangedBy
+	Reason    string `json:"reason,omitempty"`
+	Version   int    `json:"version,omitempty"` // See UpdateOrderStatusRequest.Version
+}
+
+// RefundOrderRequest is the body of POST /orders/:id/refunds. Without items, everything not yet
+// refunded is refunded.
+type RefundOrderRequest struct {
+	Items      []RefundItem `json:"items,omitempty"`
+	RefundedBy string       `json:"-"` // See UpdateOrderStatusRequest.ChangedBy
+	Reason     string       `json:"reason,omitempty"`
+	Version    int          `json:"version,omitempty"` // See UpdateOrderStatusRequest.Version
+}
+
+// ListOrdersRequest is the query of GET /orders. Zero fields do not filter.
+type ListOrdersRequest struct {
+	Statuses    []string
+	CreatedFrom time.Time // Inclusive
+	CreatedTo   time.Time // Exclusive
+	CouponCode  string    // Orders that carried this coupon, whether or not it was applied
+	ProductID   string    // Orders with a line for this product
+	Sort        string    // One of the OrderSort values; newest first by default
+	Limit       int       // Page size; 0 uses the default
+	Cursor      string    // NextCursor of the previous page
+}
 
-	// Total    float64         `json:"total"`
-	// TotalPrice  float64         `json:"total_price"`
-	// OrderStatus string  `json:"order_status"` // e.g., "pending", "completed", "cancelled"
-	// CreatedAt   string  `json:"created_at"`   // ISO 8601 format
-	// UpdatedAt   string  `json:"updated_at"`   // ISO 8601 format
-	// PromoCode   string  `json:"promo_code,omitempty"`
-	// Discount    float64         `json:"discount,omitempty"`
-	// FinalPrice  float64         `json:"final_price"`
+// Sort orders for listing orders. A leading '-' sorts descending; ties are broken by order ID.
+const (
+	OrderSortNewest       = "-created_at"
+	OrderSortOldest       = "created_at"
+	OrderSortPriceLowest  = "final_price"
+	OrderSortPriceHighest = "-final_price"
+)
+
+// OrderPage is one page of orders. NextCursor is empty on the last page.
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// UpdateOrderStatusRequest is the body of PATCH /orders/:id/status.
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status"`
+	// Who made the change: the name of the admin key the request was made with, never the body.
+	ChangedBy string `json:"-"`
+	Reason    string `json:"reason,omitempty"`
+	// The order version the change is based on. When set, the update fails with a conflict if the
+	// order has changed since; when omitted, the transition is checked against the latest version.
+	Version int `json:"version,omitempty"`
 }
 
 type CreateOrderRequest struct {
-	CouponCode string          `json:"coupon_code"`
-	Items      []OrderLineItem `json:"items"`
+	CouponCode  string          `json:"coupon_code"`
+	CouponCodes []string        `json:"coupon_codes,omitempty"` // Further coupons; combined with CouponCode
+	CustomerID  string          `json:"customer_id,omitempty"`  // Required for codes limited per customer
+	Items       []OrderLineItem `json:"items"`
 }
 
 type ErrorResponse struct {
+	Message string       `json:"message"`
+	Code    int          `json:"code"`
+	Reason  string       `json:"reason,omitempty"` // Machine-readable cause, e.g. a PromoReason value
+	Errors  []FieldError `json:"errors,omitempty"` // One per invalid field of the request
+}
+
+// FieldError points at the part of a request that is invalid, e.g. "items[2].quantity".
+type FieldError struct {
+	Field   string `json:"field"`
 	Message string `json:"message"`
-	Code    int    `json:"code"`
+}
+
+// Promo code load phases, reported per source while LoadPromoCodesFromURLs runs.
+const (
+	PromoLoadPhasePending    = "pending"
+	PromoLoadPhaseDownload   = "download"
+	PromoLoadPhaseDecompress = "decompress"
+	PromoLoadPhaseScan       = "scan"
+	PromoLoadPhaseAggregate  = "aggregate"
+	PromoLoadPhaseDone       = "done"
+	PromoLoadPhaseFailed     = "failed"
+)
+
+// How a coupon source was verified before its codes were loaded.
+const (
+	PromoVerificationNone      = "none"
+	PromoVerificationSHA256    = "sha256"
+	PromoVerificationSignature = "ed25519"
+)
+
+// Overall promo code load states.
+const (
+	PromoLoadStateIdle      = "idle"
+	PromoLoadStateRunning   = "running"
+	PromoLoadStateCompleted = "completed"
+	PromoLoadStateFailed    = "failed"
+)
+
+// PromoSourceProgress describes how far a single coupon source has got through the load pipeline.
+// BytesProcessed and BytesTotal refer to the current phase: compressed bytes while downloading and
+// decompressing, decompressed bytes while scanning.
+type PromoSourceProgress struct {
+	Source            string     `json:"source"`
+	Origin            string     `json:"origin,omitempty"` // "local" or "remote"
+	Phase             string     `json:"phase"`
+	BytesProcessed    int64      `json:"bytes_processed"`
+	BytesTotal        int64      `json:"bytes_total"` // 0 when the size is not known up front
+	BytesDecompressed int64      `json:"bytes_decompressed"`
+	LinesScanned      int64      `json:"lines_scanned"`
+	CodesFound        int        `json:"codes_found"`
+	SHA256            string     `json:"sha256,omitempty"`       // Digest of the compressed source
+	Verification      string     `json:"verification,omitempty"` // One of the PromoVerification* values
+	QuarantinePath    string     `json:"quarantine_path,omitempty"`
+	ThroughputBps     float64    `json:"throughput_bytes_per_sec"`
+	ETASeconds        *float64   `json:"eta_seconds,omitempty"`
+	StartedAt         *time.Time `json:"started_at,omitempty"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+	Error             string     `json:"error,omitempty"`
+}
+
+// PromoLoadStatus is a snapshot of the most recent (or in-flight) promo code load.
+type PromoLoadStatus struct {
+	State       string                `json:"state"`
+	StartedAt   *time.Time            `json:"started_at,omitempty"`
+	FinishedAt  *time.Time            `json:"finished_at,omitempty"`
+	UniqueCodes int                   `json:"unique_codes"`
+	Error       string                `json:"error,omitempty"`
+	Sources     []PromoSourceProgress `json:"sources"`
+}
+
+// PromoRecheck is the outcome of re-validating a code that was accepted while the dataset was loading.
+type PromoRecheck struct {
+	Code      string    `json:"code"`
+	Valid     bool      `json:"valid"`
+	Reason    string    `json:"reason"` // One of the PromoReason values
+	Message   string    `json:"message"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// PromoRechecks lists the codes still waiting for a loaded dataset and the results of earlier
+// re-checks, oldest first.
+type PromoRechecks struct {
+	Pending []string       `json:"pending"`
+	Results []PromoRecheck `json:"results"`
+}
+
+// PromoSourceFingerprint identifies exactly which source file a dataset version was built from.
+type PromoSourceFingerprint struct {
+	Source       string `json:"source"`
+	SHA256       string `json:"sha256"`
+	Verification string `json:"verification"`
+	LinesScanned int64  `json:"lines_scanned"`
+	CodesFound   int    `json:"codes_found"`
+}
+
+// PromoDatasetVersion records one successful promo code load.
+type PromoDatasetVersion struct {
+	ID          int                      `json:"id"`
+	LoadedAt    time.Time                `json:"loaded_at"`
+	UniqueCodes int                      `json:"unique_codes"`
+	ValidCodes  int                      `json:"valid_codes"`
+	Sources     []PromoSourceFingerprint `json:"sources"`
+}
+
+// PromoValidityChange is a code present in both versions of a diff whose validity differs.
+type PromoValidityChange struct {
+	Code     string `json:"code"`
+	WasValid bool   `json:"was_valid"`
+	IsValid  bool   `json:"is_valid"`
+}
+
+// PromoDatasetDiff lists what changed between two dataset versions. The lists are sorted and may be
+// truncated to a limit; the totals always reflect the full diff.
+type PromoDatasetDiff struct {
+	From                 int                   `json:"from"`
+	To                   int                   `json:"to"`
+	TotalAdded           int                   `json:"total_added"`
+	TotalRemoved         int                   `json:"total_removed"`
+	TotalValidityChanged int                   `json:"total_validity_changed"`
+	Added                []string              `json:"added"`
+	Removed              []string              `json:"removed"`
+	ValidityChanged      []PromoValidityChange `json:"validity_changed"`
+	Truncated            bool                  `json:"truncated"`
+}
+
+// PromoCodeVersionLookup answers whether a code was valid in a given dataset version.
+type PromoCodeVersionLookup struct {
+	Version   int    `json:"version"`
+	PromoCode string `json:"promo_code"`
+	Found     bool   `json:"found"`
+	Sources   int    `json:"sources"` // Number of files the code appeared in
+	Valid     bool   `json:"valid"`
+}
+
+// Campaign discount types.
+const (
+	CampaignTypePercentage  = "percentage"   // PercentOff of the order subtotal
+	CampaignTypeFixedAmount = "fixed_amount" // AmountOff the order, capped at the subtotal
+	CampaignTypeFreeItem    = "free_item"    // One unit of FreeProductID is free when it is in the cart
+	CampaignTypeBuyXGetY    = "buy_x_get_y"  // For every BuyQuantity units of ProductID, GetQuantity more are free
+)
+
+// Campaign defines what a valid promo code is worth and which codes it applies to.
+// A code is matched by an explicit Codes entry first, then by the longest CodePrefix,
+// and finally by the Default campaign.
+type Campaign struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	PercentOff    float64 `json:"percent_off,omitempty"`
+	AmountOff     Money   `json:"amount_off,omitempty"`
+	FreeProductID string  `json:"free_product_id,omitempty"`
+	ProductID     string  `json:"product_id,omitempty"` // buy_x_get_y target; empty applies to every line
+	BuyQuantity   int     `json:"buy_quantity,omitempty"`
+	GetQuantity   int     `json:"get_quantity,omitempty"`
+
+	Codes      []string `json:"codes,omitempty"`
+	CodePrefix string   `json:"code_prefix,omitempty"`
+	Default    bool     `json:"default,omitempty"`
+
+	// Usage limits, counted per code. Zero means unlimited; SingleUse is shorthand for MaxUses 1.
+	MaxUses            int  `json:"max_uses,omitempty"`
+	MaxUsesPerCustomer int  `json:"max_uses_per_customer,omitempty"`
+	SingleUse          bool `json:"single_use,omitempty"`
+
+	// When the campaign can be redeemed. Nil means always.
+	Schedule *CampaignSchedule `json:"schedule,omitempty"`
+
+	// Automatic campaigns apply to every order while active, without a code.
+	Automatic bool `json:"automatic,omitempty"`
+	// Stacking is CampaignStackingExclusive (the default) or CampaignStackingStackable.
+	Stacking string `json:"stacking,omitempty"`
+
+	// Conditions the cart must meet for the campaign to apply. Nil means any cart.
+	Conditions *CampaignConditions `json:"conditions,omitempty"`
+	// Target limits the discount to matching lines. Nil discounts the whole order.
+	Target *ProductFilter `json:"target,omitempty"`
+}
+
+// ProductFilter matches products by ID or by category; a product matching either list matches.
+// An empty filter matches every product.
+type ProductFilter struct {
+	ProductIDs []string `json:"product_ids,omitempty"`
+	Categories []string `json:"categories,omitempty"` // Product.Category values such as "Burgers", case-insensitive
+}
+
+// CampaignConditions are what a cart needs for a campaign to apply. Every condition set must hold.
+type CampaignConditions struct {
+	MinSubtotal Money `json:"min_subtotal,omitempty"` // Of the whole cart, before discounts
+	// The cart must contain at least one matching product (when the filter is set) and at least
+	// MinQuantity units of matching products.
+	ProductFilter
+	MinQuantity int `json:"min_quantity,omitempty"`
+}
+
+// Campaign stacking rules. When an order qualifies for several promotions, the pricing engine
+// applies either one exclusive promotion or the stackable ones together (up to the configured
+// caps), whichever gives the customer the bigger discount.
+const (
+	CampaignStackingExclusive = "exclusive" // Never combined with other promotions
+	CampaignStackingStackable = "stackable" // Combined with other stackable promotions
+)
+
+// CampaignSchedule limits a campaign to an absolute period and/or recurring weekly windows.
+// Windows are evaluated in TimeZone (an IANA name such as "Asia/Singapore", default UTC).
+type CampaignSchedule struct {
+	StartsAt *time.Time     `json:"starts_at,omitempty"` // Inclusive
+	EndsAt   *time.Time     `json:"ends_at,omitempty"`   // Exclusive
+	TimeZone string         `json:"time_zone,omitempty"`
+	Windows  []WeeklyWindow `json:"windows,omitempty"` // If set, the campaign is only active inside one of them
+}
+
+// WeeklyWindow is a recurring time of day on some weekdays, e.g. mon-fri 17:00-19:00.
+// Start is inclusive and End exclusive; an End before Start runs past midnight into the next day.
+type WeeklyWindow struct {
+	Days  []string `json:"days,omitempty"` // "mon" ... "sun"; empty means every day
+	Start string   `json:"start"`          // "HH:MM"
+	End   string   `json:"end"`            // "HH:MM", "24:00" for end of day
+}
+
+// PromoCodeUsage reports how often a promo code has been redeemed against its campaign limits.
+// Remaining values are null when the code is unlimited.
+type PromoCodeUsage struct {
+	PromoCode          string `json:"promo_code"`
+	CampaignID         string `json:"campaign_id"`
+	Used               int    `json:"used"` // Includes uses held by orders still being created
+	MaxUses            int    `json:"max_uses,omitempty"`
+	Remaining          *int   `json:"remaining"`
+	CustomerID         string `json:"customer_id,omitempty"`
+	CustomerUsed       int    `json:"customer_used,omitempty"`
+	MaxUsesPerCustomer int    `json:"max_uses_per_customer,omitempty"`
+	CustomerRemaining  *int   `json:"customer_remaining,omitempty"`
 }
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor currency units (cents). It is encoded in JSON as a decimal number
+// with two fraction digits, e.g. 1299 <-> 12.99, so the API keeps its existing number format.
+type Money int64
+
+// String formats the amount with two fraction digits.
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// MarshalJSON encodes the amount as a JSON number, e.g. 12.99.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number (or numeric string) with at most two fraction digits.
+// Parsing is done on the decimal text, so no binary floating point rounding is involved. A JSON null
+// leaves the amount unchanged, as it does for the built-in number types.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// ParseMoney parses a decimal amount such as "12.99", "3" or "-0.5" into minor units. Only ASCII
+// digits with a single optional leading '-' and at most two fraction digits are accepted.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	text := s
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if !isDigits(whole) || (hasPoint && !isDigits(frac)) {
+		return 0, fmt.Errorf("invalid money amount %q: expected a decimal number", text)
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("invalid money amount %q: expected at most two decimal places", text)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid money amount %q: out of range", text)
+	}
+	cents, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid money amount %q: %w", text, err)
+	}
+	if units > (math.MaxInt64-cents)/100 {
+		return 0, fmt.Errorf("invalid money amount %q: out of range", text)
+	}
+
+	amount := Money(units*100 + cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestMoney_JSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected Money
+		encoded  string
+	}{
+		{"12.99", 1299, "12.99"},
+		{"3", 300, "3.00"},
+		{"0.5", 50, "0.50"},
+		{"-1.05", -105, "-1.05"},
+		{`"2.50"`, 250, "2.50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var m Money
+			if err := json.Unmarshal([]byte(tt.json), &m); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if m != tt.expected {
+				t.Errorf("Expected %d minor units, got %d", tt.expected, m)
+			}
+			encoded, err := json.Marshal(m)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(encoded) != tt.encoded {
+				t.Errorf("Expected encoding %s, got %s", tt.encoded, encoded)
+			}
+		})
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte("1.999"), &m); err == nil {
+		t.Errorf("Expected an error for more than two decimal places")
+	}
+}
+
+func TestMoney_UnmarshalNull(t *testing.T) {
+	var body struct {
+		Amount Money `json:"amount"`
+	}
+	body.Amount = 500
+	if err := json.Unmarshal([]byte(`{"amount":null}`), &body); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if body.Amount != 500 {
+		t.Errorf("Expected null to leave the amount unchanged, got %d", body.Amount)
+	}
+}
+
+func TestParseMoney_Invalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"-",
+		".5",
+		"5.",
+		"1.-5",
+		"1.+5",
+		"--5",
+		"+5",
+		"-+5",
+		"1_000",
+		"1e3",
+		"0x10",
+		"1.2.3",
+		"١٢",                   // Non-ASCII digits
+		"92233720368547758.08", // One cent past the largest Money
+		"99999999999999999999",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if m, err := ParseMoney(input); err == nil {
+				t.Errorf("Expected an error, got %d", m)
+			}
+		})
+	}
+}
+
+func TestParseMoney_Bounds(t *testing.T) {
+	m, err := ParseMoney("92233720368547758.07")
+	if err != nil || m != math.MaxInt64 {
+		t.Errorf("Expected the largest Money, got %d (%v)", m, err)
+	}
+	m, err = ParseMoney("-92233720368547758.07")
+	if err != nil || m != -math.MaxInt64 {
+		t.Errorf("Expected the most negative parseable Money, got %d (%v)", m, err)
+	}
+}
@@ -0,0 +1,230 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"kart-challenge/internal/domain"
+	"kart-challenge/internal/pricing"
+	"slices"
+	"strings"
+)
+
+// CartPolicy limits what an order may contain. Zero limits are not enforced; every order needs at
+// least one item, and no line may exceed pricing.MaxLineQuantity.
+type CartPolicy struct {
+	MergeDuplicateLines bool // Combine lines for the same product and modifiers into the first of them
+	MaxQuantityPerLine  int  // Checked on merged lines when MergeDuplicateLines is set
+	MaxDistinctItems    int  // Different products per order
+}
+
+// ValidationError is returned when the items of an order request are unusable. Each field error
+// points at the offending item by its index in the request. errors.Is matches the causes, e.g.
+// domain.ErrProductNotFound or domain.ErrInvalidQuantity.
+type ValidationError struct {
+	Errors []domain.FieldError
+	causes []error
+}
+
+func (e *ValidationError) add(field string, cause error, message string) {
+	e.Errors = append(e.Errors, domain.FieldError{Field: field, Message: message})
+	e.causes = append(e.causes, cause)
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return fmt.Sprintf("%s: %s", domain.ErrInvalidOrderItems, strings.Join(parts, "; "))
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return append([]error{domain.ErrInvalidOrderItems}, e.causes...)
+}
+
+// onlyCause reports whether every field error was caused by target.
+func (e *ValidationError) onlyCause(target error) bool {
+	for _, cause := range e.causes {
+		if !errors.Is(cause, target) {
+			return false
+		}
+	}
+	return len(e.causes) > 0
+}
+
+// cartLine is an order line with its product and the index of the request item it came from.
+type cartLine struct {
+	index   int
+	item    domain.OrderLineItem
+	product domain.Product
+}
+
+// resolveCart checks the items of an order request against the catalog and the cart policy and
+// returns the lines to price. All problems are reported together.
+func (s *OrderService) resolveCart(items []domain.OrderLineItem) ([]cartLine, error) {
+	invalid := &ValidationError{}
+	if len(items) == 0 {
+		invalid.add("items", domain.ErrInvalidOrderItems, "at least one item is required")
+		return nil, invalid
+	}
+
+	var lines []cartLine
+	lineOf := make(map[string]int) // Product and modifiers -> index in lines, when merging
+	distinct := make(map[string]bool)
+	for i, item := range items {
+		field := fmt.Sprintf("items[%d]", i)
+		reported := len(invalid.Errors)
+		product, found := s.ProductService.GetProductByID(item.ProductID)
+		switch {
+		case item.ProductID == "":
+			invalid.add(field+".product_id", domain.ErrInvalidOrderItems, "is required")
+		case !found:
+			invalid.add(field+".product_id", domain.ErrProductNotFound, fmt.Sprintf("product '%s' does not exist", item.ProductID))
+		}
+		switch {
+		case item.Quantity <= 0:
+			invalid.add(field+".quantity", domain.ErrInvalidQuantity, "must be positive")
+		case item.Quantity > pricing.MaxLineQuantity:
+			invalid.add(field+".quantity", domain.ErrInvalidQuantity, fmt.Sprintf("must not exceed %d per line", pricing.MaxLineQuantity))
+		}
+		var modifiers []domain.SelectedModifier
+		if found {
+			modifiers = resolveModifiers(product, item.Modifiers, field, invalid)
+		}
+		if len(invalid.Errors) > reported {
+			continue
+		}
+
+		// Lines only merge when the same options were chosen; a plain burger and one with extra cheese
+		// stay apart.
+		key := lineKey(item.ProductID, modifiers)
+		if existing, ok := lineOf[key]; ok && s.cart.MergeDuplicateLines {
+			lines[existing].item.Quantity += item.Quantity
+			continue
+		}
+		lineOf[key] = len(lines)
+		distinct[item.ProductID] = true
+		lines = append(lines, cartLine{
+			index: i,
+			item: domain.OrderLineItem{
+				ProductID:   item.ProductID,
+				ProductName: product.Name,
+				Quantity:    item.Quantity,
+				Modifiers:   modifiers,
+			},
+			product: product,
+		})
+	}
+
+	// Stock is checked against everything ordered of a product, whichever lines it is spread over.
+	// It is only taken when the order is created.
+	wanted := make(map[string]int)
+	for _, line := range lines {
+		wanted[line.item.ProductID] += line.item.Quantity
+	}
+	checked := make(map[string]bool)
+	for _, line := range lines {
+		product := line.product
+		if checked[product.ID] || product.InStock(wanted[product.ID]) {
+			continue
+		}
+		checked[product.ID] = true
+		if !product.Available {
+			invalid.add(fmt.Sprintf("items[%d].product_id", line.index), domain.ErrProductUnavailable, fmt.Sprintf("'%s' is sold out", product.Name))
+		} else {
+			invalid.add(fmt.Sprintf("items[%d].quantity", line.index), domain.ErrProductUnavailable, fmt.Sprintf("only %d of '%s' left", *product.Stock, product.Name))
+		}
+	}
+
+	maxQuantity := pricing.MaxLineQuantity
+	if s.cart.MaxQuantityPerLine > 0 {
+		maxQuantity = min(s.cart.MaxQuantityPerLine, pricing.MaxLineQuantity)
+	}
+	for _, line := range lines {
+		if line.item.Quantity > maxQuantity {
+			message := fmt.Sprintf("must not exceed %d per line", maxQuantity)
+			if line.item.Quantity != items[line.index].Quantity {
+				message = fmt.Sprintf("%d in total across the lines for '%s' exceeds the limit of %d per line", line.item.Quantity, line.item.ProductID, maxQuantity)
+			}
+			invalid.add(fmt.Sprintf("items[%d].quantity", line.index), domain.ErrInvalidQuantity, message)
+		}
+	}
+	if s.cart.MaxDistinctItems > 0 && len(distinct) > s.cart.MaxDistinctItems {
+		// Point at the first line for a product beyond the limit.
+		first := lines[0].index
+		seen := make(map[string]bool)
+		for _, line := range lines {
+			if !seen[line.item.ProductID] {
+				seen[line.item.ProductID] = true
+				if len(seen) > s.cart.MaxDistinctItems {
+					first = line.index
+					break
+				}
+			}
+		}
+		invalid.add(fmt.Sprintf("items[%d]", first), domain.ErrInvalidOrderItems, fmt.Sprintf("an order may contain at most %d different products", s.cart.MaxDistinctItems))
+	}
+
+	if len(invalid.Errors) > 0 {
+		return nil, invalid
+	}
+	return lines, nil
+}
+
+// resolveModifiers checks the modifiers chosen on a request item against its product's modifier
+// groups, reporting problems under field. It returns the valid choices with their names and price
+// deltas taken from the product, in the product's group and option order.
+func resolveModifiers(product domain.Product, selected []domain.SelectedModifier, field string, invalid *ValidationError) []domain.SelectedModifier {
+	chosen := make(map[string]bool) // Group ID + option ID
+	for j, modifier := range selected {
+		modifierField := fmt.Sprintf("%s.modifiers[%d]", field, j)
+		groupIndex := slices.IndexFunc(product.ModifierGroups, func(g domain.ModifierGroup) bool { return g.ID == modifier.GroupID })
+		if groupIndex < 0 {
+			invalid.add(modifierField+".group_id", domain.ErrInvalidModifiers, fmt.Sprintf("product '%s' has no modifier group '%s'", product.ID, modifier.GroupID))
+			continue
+		}
+		group := product.ModifierGroups[groupIndex]
+		if !slices.ContainsFunc(group.Options, func(o domain.ModifierOption) bool { return o.ID == modifier.OptionID }) {
+			invalid.add(modifierField+".option_id", domain.ErrInvalidModifiers, fmt.Sprintf("'%s' is not an option of '%s'", modifier.OptionID, group.Name))
+			continue
+		}
+		key := modifier.GroupID + "\x00" + modifier.OptionID
+		if chosen[key] {
+			invalid.add(modifierField, domain.ErrInvalidModifiers, "is selected more than once")
+			continue
+		}
+		chosen[key] = true
+	}
+
+	var resolved []domain.SelectedModifier
+	for _, group := range product.ModifierGroups {
+		count := 0
+		for _, option := range group.Options {
+			if chosen[group.ID+"\x00"+option.ID] {
+				count++
+				resolved = append(resolved, domain.SelectedModifier{GroupID: group.ID, OptionID: option.ID, Name: option.Name, PriceDelta: option.PriceDelta})
+			}
+		}
+		minimum := group.MinSelections
+		if group.Required {
+			minimum = max(minimum, 1)
+		}
+		switch {
+		case count < minimum:
+			invalid.add(field+".modifiers", domain.ErrInvalidModifiers, fmt.Sprintf("choose at least %d from '%s'", minimum, group.Name))
+		case group.MaxSelections > 0 && count > group.MaxSelections:
+			invalid.add(field+".modifiers", domain.ErrInvalidModifiers, fmt.Sprintf("choose at most %d from '%s'", group.MaxSelections, group.Name))
+		}
+	}
+	return resolved
+}
+
+// lineKey identifies a product with a set of resolved modifiers, for merging lines.
+func lineKey(productID string, modifiers []domain.SelectedModifier) string {
+	var key strings.Builder
+	key.WriteString(productID)
+	for _, modifier := range modifiers {
+		key.WriteString("\x00" + modifier.GroupID + "\x00" + modifier.OptionID)
+	}
+	return key.String()
+}
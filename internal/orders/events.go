@@ -0,0 +1,110 @@
+package orders
+
+import (
+	"kart-challenge/internal/domain"
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped. A dropped
+// client reconnects with its Last-Event-ID and catches up from the replay buffer.
+const subscriberBuffer = 64
+
+// EventBus fans order events out to the subscribers in this process and keeps the most recent ones,
+// so clients that reconnect can resume where they left off.
+type EventBus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	replay      []domain.OrderEvent // Oldest first, at most replaySize
+	replaySize  int
+	subscribers map[*Subscription]bool
+}
+
+// NewEventBus creates an event bus that keeps the last replaySize events for replay; a size that is
+// not positive means the default of 1000.
+func NewEventBus(replaySize int) *EventBus {
+	if replaySize <= 0 {
+		replaySize = defaultEventReplaySize
+	}
+	return &EventBus{
+		replaySize:  replaySize,
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Subscription receives the events of one order, or of every order.
+type Subscription struct {
+	Replay []domain.OrderEvent      // Buffered events after the last event ID the client saw
+	Missed bool                     // Some events after that ID are no longer buffered
+	Events <-chan domain.OrderEvent // Live events; closed when the subscriber falls too far behind
+
+	orderID string // Empty for every order
+	events  chan domain.OrderEvent
+	bus     *EventBus
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+// Publish numbers an event, keeps it for replay and hands it to the matching subscribers.
+func (b *EventBus) Publish(event domain.OrderEvent) domain.OrderEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	event.ID = b.lastID
+	if len(b.replay) == b.replaySize {
+		b.replay = b.replay[1:]
+	}
+	b.replay = append(b.replay, event)
+
+	for sub := range b.subscribers {
+		if sub.orderID != "" && sub.orderID != event.OrderID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			b.drop(sub) // Never let a slow client hold up the orders being placed
+		}
+	}
+	return event
+}
+
+// Subscribe starts receiving the events of orderID, or of every order if it is empty. With a
+// lastEventID it also returns the buffered events that came after it.
+func (b *EventBus) Subscribe(orderID string, lastEventID uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := make(chan domain.OrderEvent, subscriberBuffer)
+	sub := &Subscription{Events: events, orderID: orderID, events: events, bus: b}
+	b.subscribers[sub] = true
+
+	if lastEventID == 0 {
+		return sub
+	}
+	// An ID beyond the last one comes from an earlier run of the server; everything buffered is new.
+	if lastEventID > b.lastID {
+		lastEventID = 0
+		sub.Missed = true
+	}
+	if len(b.replay) > 0 && b.replay[0].ID > lastEventID+1 {
+		sub.Missed = true
+	}
+	for _, event := range b.replay {
+		if event.ID > lastEventID && (orderID == "" || event.OrderID == orderID) {
+			sub.Replay = append(sub.Replay, event)
+		}
+	}
+	return sub
+}
+
+// drop removes a subscriber and closes its channel; callers hold the lock.
+func (b *EventBus) drop(sub *Subscription) {
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
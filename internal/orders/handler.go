@@ -1,9 +1,17 @@
 package orders
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
 	"kart-challenge/internal/domain" // Corrected import path
+	"kart-challenge/internal/promos"
+	"kart-challenge/pkg/middleware"
+	"kart-challenge/pkg/sse"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -20,56 +28,117 @@ func NewHandler(service Service) *Handler {
 
 // CreateOrder handles POST /orders to create a new order.
 func (h *Handler) CreateOrder(c *fiber.Ctx) error {
-	req := new(domain.CreateOrderRequest)
+	req, ok := parseOrderRequest(c)
+	if !ok {
+		return nil // The response has been written
+	}
+
+	order, err := h.Service.CreateOrder(*req)
+	if err != nil {
+		log.Printf("Error creating order: %v", err)
+		return orderRequestError(c, err)
+	}
+
+	middleware.ReportFailedAttempts(c, failedGuesses(order.Coupons))
+	return c.Status(fiber.StatusCreated).JSON(order)
+}
+
+// QuoteOrder handles POST /orders/quote. It takes the same body as POST /orders and returns the price
+// breakdown that order would get, without placing it.
+func (h *Handler) QuoteOrder(c *fiber.Ctx) error {
+	req, ok := parseOrderRequest(c)
+	if !ok {
+		return nil // The response has been written
+	}
+
+	quote, err := h.Service.QuoteOrder(*req)
+	if err != nil {
+		log.Printf("Error quoting order: %v", err)
+		return orderRequestError(c, err)
+	}
+
+	middleware.ReportFailedAttempts(c, failedGuesses(quote.Coupons))
+	return c.Status(fiber.StatusOK).JSON(quote)
+}
+
+// parseOrderRequest reads and checks the body of POST /orders. If it is unusable the error response
+// is written and ok is false.
+func parseOrderRequest(c *fiber.Ctx) (req *domain.CreateOrderRequest, ok bool) {
+	req = new(domain.CreateOrderRequest)
 	if err := c.BodyParser(req); err != nil {
 		log.Printf("Error parsing create order request: %v", err)
-		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+		c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
 			Message: domain.ErrInvalidRequestPayload.Error(),
 		})
+		return nil, false
 	}
 
-	// Basic validation
-	// if req.CustomerID == "" || len(req.Items) == 0 {
-	// 	return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
-	// 		Message: "Customer ID and at least one item are required to create an order.",
-	// 	})
-	// }
+	// The items are checked against the cart policy by the service, which reports every problem at once.
+	return req, true
+}
 
-	for _, item := range req.Items {
-		if item.ProductID == "" || item.Quantity <= 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
-				Message: "All order items must have a product ID and a positive quantity.",
-			})
-		}
-	}
+// orderRequestError maps an error from pricing an order request to its HTTP response.
+func orderRequestError(c *fiber.Ctx, err error) error {
+	var errMsg string
+	var statusCode int
 
-	order, err := h.Service.CreateOrder(*req)
-	if err != nil {
-		log.Printf("Error creating order: %v", err)
-		var errMsg string
-		var statusCode int
+	var rejected *CouponRejectedError
+	if errors.As(err, &rejected) {
+		middleware.ReportFailedAttempts(c, failedGuesses([]domain.CouponOutcome{rejected.Outcome}))
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(domain.ErrorResponse{
+			Message: rejected.Outcome.Message,
+			Code:    fiber.StatusUnprocessableEntity,
+			Reason:  rejected.Outcome.Reason,
+		})
+	}
 
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		// Unknown products keep their 404 and sold out ones their 409; any other problem is a 400.
+		statusCode = fiber.StatusBadRequest
 		switch {
-		case errors.Is(err, domain.ErrProductNotFound):
-			errMsg = domain.ErrProductNotFound.Error()
+		case invalid.onlyCause(domain.ErrProductNotFound):
 			statusCode = fiber.StatusNotFound
-		case errors.Is(err, domain.ErrProductUnavailable):
-			errMsg = domain.ErrProductUnavailable.Error()
-			statusCode = fiber.StatusConflict // 409 Conflict for resource state
-		case errors.Is(err, domain.ErrInvalidQuantity):
-			errMsg = domain.ErrInvalidQuantity.Error()
-			statusCode = fiber.StatusBadRequest
-		default:
-			errMsg = domain.ErrInternalServerError.Error()
-			statusCode = fiber.StatusInternalServerError
+		case invalid.onlyCause(domain.ErrProductUnavailable):
+			statusCode = fiber.StatusConflict
 		}
-
 		return c.Status(statusCode).JSON(domain.ErrorResponse{
-			Message: errMsg,
+			Message: "The order items are invalid.",
+			Code:    statusCode,
+			Errors:  invalid.Errors,
 		})
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(order)
+	switch {
+	case errors.Is(err, domain.ErrProductNotFound):
+		errMsg = domain.ErrProductNotFound.Error()
+		statusCode = fiber.StatusNotFound
+	case errors.Is(err, domain.ErrProductUnavailable):
+		errMsg = domain.ErrProductUnavailable.Error()
+		statusCode = fiber.StatusConflict // 409 Conflict for resource state
+	case errors.Is(err, domain.ErrInvalidQuantity):
+		errMsg = domain.ErrInvalidQuantity.Error()
+		statusCode = fiber.StatusBadRequest
+	default:
+		errMsg = domain.ErrInternalServerError.Error()
+		statusCode = fiber.StatusInternalServerError
+	}
+
+	return c.Status(statusCode).JSON(domain.ErrorResponse{
+		Message: errMsg,
+	})
+}
+
+// failedGuesses counts the coupons whose codes turned out not to exist. Orders are another way to try
+// codes, so these count towards the brute-force limits like failed validations do.
+func failedGuesses(coupons []domain.CouponOutcome) int {
+	failed := 0
+	for _, coupon := range coupons {
+		if !coupon.Applied && promos.IsFailedGuess(coupon.Reason) {
+			failed++
+		}
+	}
+	return failed
 }
 
 // GetOrderByID handles GET /orders/:id to retrieve a single order by ID.
@@ -84,8 +153,267 @@ func (h *Handler) GetOrderByID(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(order)
 }
 
-// GetAllOrders (optional for admin/debug)
+// ListOrders handles GET /orders, which lists every customer's orders and is for admins only. Query
+// parameters: status (comma separated), created_from and created_to (RFC 3339), coupon_code, product_id,
+// sort, limit and cursor (next_cursor of the previous page).
+func (h *Handler) ListOrders(c *fiber.Ctx) error {
+	req, err := listOrdersRequest(c)
+	if err == nil {
+		var page domain.OrderPage
+		if page, err = h.Service.ListOrders(req); err == nil {
+			return c.Status(fiber.StatusOK).JSON(page)
+		}
+	}
+	if errors.Is(err, domain.ErrInvalidOrderQuery) {
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		})
+	}
+	log.Printf("Error listing orders: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+		Message: domain.ErrInternalServerError.Error(),
+		Code:    fiber.StatusInternalServerError,
+	})
+}
+
+// listOrdersRequest reads the query parameters of GET /orders.
+func listOrdersRequest(c *fiber.Ctx) (domain.ListOrdersRequest, error) {
+	req := domain.ListOrdersRequest{
+		CouponCode: c.Query("coupon_code"),
+		ProductID:  c.Query("product_id"),
+		Sort:       c.Query("sort"),
+		Cursor:     c.Query("cursor"),
+	}
+	for _, status := range strings.Split(c.Query("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			req.Statuses = append(req.Statuses, status)
+		}
+	}
+	for param, target := range map[string]*time.Time{"created_from": &req.CreatedFrom, "created_to": &req.CreatedTo} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return domain.ListOrdersRequest{}, fmt.Errorf("%w: '%s' must be an RFC 3339 timestamp", domain.ErrInvalidOrderQuery, param)
+		}
+		*target = parsed
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return domain.ListOrdersRequest{}, fmt.Errorf("%w: 'limit' must be a positive integer", domain.ErrInvalidOrderQuery)
+		}
+		req.Limit = limit
+	}
+	return req, nil
+}
+
+// GetAllOrders handles GET /debug/orders, an unpaginated dump of every order for admins.
+// Deprecated: use GET /orders.
 func (h *Handler) GetAllOrders(c *fiber.Ctx) error {
 	orders := h.Service.GetAllOrders()
 	return c.Status(fiber.StatusOK).JSON(orders)
 }
+
+// UpdateOrderStatus handles PATCH /orders/:id/status. It runs behind the admin key middleware, whose
+// key name is recorded as the actor of the change.
+func (h *Handler) UpdateOrderStatus(c *fiber.Ctx) error {
+	req := new(domain.UpdateOrderStatusRequest)
+	if err := c.BodyParser(req); err != nil {
+		log.Printf("Error parsing order status update request: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: domain.ErrInvalidRequestPayload.Error(),
+			Code:    fiber.StatusBadRequest,
+		})
+	}
+	if req.Status == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: "'status' is required.",
+			Code:    fiber.StatusBadRequest,
+		})
+	}
+	if req.ChangedBy = middleware.AdminName(c); req.ChangedBy == "" {
+		return adminKeyRequired(c)
+	}
+
+	order, err := h.Service.UpdateOrderStatus(c.Params("id"), *req)
+	if err != nil {
+		return orderUpdateError(c, err, "updating order status")
+	}
+	return c.Status(fiber.StatusOK).JSON(order)
+}
+
+// CancelOrder handles POST /orders/:id/cancel, behind the admin key middleware like UpdateOrderStatus.
+func (h *Handler) CancelOrder(c *fiber.Ctx) error {
+	req := new(domain.CancelOrderRequest)
+	if err := c.BodyParser(req); err != nil {
+		log.Printf("Error parsing order cancellation request: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: domain.ErrInvalidRequestPayload.Error(),
+			Code:    fiber.StatusBadRequest,
+		})
+	}
+	if req.ChangedBy = middleware.AdminName(c); req.ChangedBy == "" {
+		return adminKeyRequired(c)
+	}
+
+	order, err := h.Service.CancelOrder(c.Params("id"), *req)
+	if err != nil {
+		return orderUpdateError(c, err, "cancelling order")
+	}
+	return c.Status(fiber.StatusOK).JSON(order)
+}
+
+// RefundOrder handles POST /orders/:id/refunds, behind the admin key middleware like UpdateOrderStatus.
+// Without items, everything not yet refunded is refunded.
+func (h *Handler) RefundOrder(c *fiber.Ctx) error {
+	req := new(domain.RefundOrderRequest)
+	if err := c.BodyParser(req); err != nil {
+		log.Printf("Error parsing order refund request: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: domain.ErrInvalidRequestPayload.Error(),
+			Code:    fiber.StatusBadRequest,
+		})
+	}
+	if req.RefundedBy = middleware.AdminName(c); req.RefundedBy == "" {
+		return adminKeyRequired(c)
+	}
+
+	order, err := h.Service.RefundOrder(c.Params("id"), *req)
+	if err != nil {
+		return orderUpdateError(c, err, "refunding order")
+	}
+	return c.Status(fiber.StatusCreated).JSON(order)
+}
+
+// adminKeyRequired answers order changes that did not pass the admin key middleware, which records
+// who made them.
+func adminKeyRequired(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(domain.ErrorResponse{
+		Message: "Changing an order requires an admin key.",
+		Code:    fiber.StatusUnauthorized,
+	})
+}
+
+// orderUpdateError maps an error from changing an existing order to its HTTP response.
+func orderUpdateError(c *fiber.Ctx, err error, action string) error {
+	var statusCode int
+	switch {
+	case errors.Is(err, domain.ErrOrderNotFound):
+		statusCode = fiber.StatusNotFound
+	case errors.Is(err, domain.ErrInvalidOrderStatus), errors.Is(err, domain.ErrInvalidRefund):
+		statusCode = fiber.StatusBadRequest
+	case errors.Is(err, domain.ErrInvalidStatusTransition), errors.Is(err, domain.ErrOrderVersionConflict), errors.Is(err, domain.ErrNothingToRefund):
+		statusCode = fiber.StatusConflict
+	case errors.Is(err, domain.ErrOrderReturnsPending):
+		// The change itself is stored; say so rather than suggest it failed.
+		log.Printf("Error %s: %v", action, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+			Message: domain.ErrOrderReturnsPending.Error(),
+			Code:    fiber.StatusInternalServerError,
+		})
+	default:
+		log.Printf("Error %s: %v", action, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+			Message: domain.ErrInternalServerError.Error(),
+			Code:    fiber.StatusInternalServerError,
+		})
+	}
+	return c.Status(statusCode).JSON(domain.ErrorResponse{
+		Message: err.Error(),
+		Code:    statusCode,
+	})
+}
+
+// eventStreamKeepAlive is how often an idle event stream gets a comment, so proxies keep it open and
+// disconnected clients are noticed.
+const eventStreamKeepAlive = 15 * time.Second
+
+// StreamOrderEvents handles GET /orders/events, a server-sent event stream of every order's events
+// for kitchen and front-of-house screens. It is for admins only.
+func (h *Handler) StreamOrderEvents(c *fiber.Ctx) error {
+	return h.streamEvents(c, "")
+}
+
+// StreamEventsForOrder handles GET /orders/:id/events, a server-sent event stream of one order. It is
+// for admins only, and ends once the order is completed, cancelled or refunded.
+func (h *Handler) StreamEventsForOrder(c *fiber.Ctx) error {
+	return h.streamEvents(c, c.Params("id"))
+}
+
+// streamEvents sends order events as they happen. Clients resume with the Last-Event-ID header (sent
+// by EventSource when it reconnects) or the last_event_id query parameter. If some events since then
+// are no longer buffered, a "resync" event tells the client to reload the orders it shows. The stream
+// of one order ends after the event that completes, cancels or refunds it, or straight after the
+// replay if the order already was.
+func (h *Handler) streamEvents(c *fiber.Ctx, orderID string) error {
+	rawLastEventID := c.Get("Last-Event-ID", c.Query("last_event_id"))
+	var lastEventID uint64
+	if rawLastEventID != "" {
+		var err error
+		if lastEventID, err = strconv.ParseUint(rawLastEventID, 10, 64); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+				Message: "Last-Event-ID must be the ID of an event from this stream.",
+				Code:    fiber.StatusBadRequest,
+			})
+		}
+	}
+
+	// An order that has finished before subscribing has nothing left to stream but the replay.
+	finished := false
+	if orderID != "" {
+		order, _ := h.Service.GetOrder(orderID)
+		finished = endsOrderStream(order.Status)
+	}
+	sub, err := h.Service.SubscribeToEvents(orderID, lastEventID)
+	if err != nil {
+		return orderUpdateError(c, err, "subscribing to order events")
+	}
+	ends := func(event domain.OrderEvent) bool { return orderID != "" && endsOrderStream(event.Order.Status) }
+
+	sse.SetHeaders(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+		if sub.Missed {
+			resync := fiber.Map{"message": "Some order events are no longer available; reload the orders."}
+			if err := sse.Write(w, sse.Event{Event: "resync", Data: resync}); err != nil {
+				return
+			}
+		}
+		for _, event := range sub.Replay {
+			if err := writeOrderEvent(w, event); err != nil || ends(event) {
+				return
+			}
+		}
+		if finished {
+			return
+		}
+
+		ticker := time.NewTicker(eventStreamKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case event, ok := <-sub.Events:
+				if !ok {
+					log.Printf("Order event stream closed: the client fell too far behind")
+					return
+				}
+				if err := writeOrderEvent(w, event); err != nil || ends(event) {
+					return
+				}
+			case <-ticker.C:
+				if err := sse.WriteComment(w, "keep-alive"); err != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+func writeOrderEvent(w *bufio.Writer, event domain.OrderEvent) error {
+	return sse.Write(w, sse.Event{ID: strconv.FormatUint(event.ID, 10), Event: event.Type, Data: event})
+}
@@ -0,0 +1,307 @@
+package orders
+
+import (
+	"io"
+	"kart-challenge/internal/domain"
+	"kart-challenge/internal/pricing"
+	"kart-challenge/pkg/middleware"
+	"kart-challenge/pkg/ratelimit"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestHandler serves a handler over an order service with two products and one valid coupon, PROMO10.
+func newTestHandler(t *testing.T, cfg Config) (*Handler, *OrderService) {
+	t.Helper()
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+	}}
+	promoCodeService := &mockPromoCodeService{validPromoCodes: map[string]bool{"PROMO10": true}}
+	service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), cfg)
+	return NewHandler(service), service.(*OrderService)
+}
+
+// sendRequest sends a request to app and returns the status and body.
+func sendRequest(t *testing.T, app *fiber.App, method, path, body string) (int, string) {
+	t.Helper()
+	return sendAdminRequest(t, app, method, path, "", body)
+}
+
+// sendAdminRequest is sendRequest with adminKey, if any, in the X-Admin-Key header.
+func sendAdminRequest(t *testing.T, app *fiber.App, method, path, adminKey, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if adminKey != "" {
+		req.Header.Set(middleware.AdminKeyHeader, adminKey)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	payload, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(payload)
+}
+
+func TestHandler_ReportsFailedGuesses(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		policy   string
+		okStatus int // For a valid coupon
+		status   int // For an unknown coupon
+	}{
+		{"Create with warn policy", "/orders", InvalidCouponWarn, fiber.StatusCreated, fiber.StatusCreated},
+		{"Create with reject policy", "/orders", InvalidCouponReject, fiber.StatusCreated, fiber.StatusUnprocessableEntity},
+		{"Quote with warn policy", "/orders/quote", InvalidCouponWarn, fiber.StatusOK, fiber.StatusOK},
+		{"Quote with reject policy", "/orders/quote", InvalidCouponReject, fiber.StatusOK, fiber.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _ := newTestHandler(t, Config{InvalidCouponPolicy: tt.policy})
+			// The second failed guess locks the client out.
+			guard := middleware.NewBruteForceMiddleware(middleware.BruteForceConfig{
+				Store:                ratelimit.NewMemoryStore(),
+				Window:               time.Minute,
+				FailureWindow:        time.Minute,
+				LockoutAfterFailures: 2,
+				LockoutDuration:      time.Minute,
+			})
+			app := fiber.New()
+			app.Post("/orders", guard, handler.CreateOrder)
+			app.Post("/orders/quote", guard, handler.QuoteOrder)
+
+			valid := `{"coupon_code":"PROMO10","items":[{"product_id":"prod1","quantity":1}]}`
+			for i := 0; i < 3; i++ {
+				if status, body := sendRequest(t, app, fiber.MethodPost, tt.path, valid); status != tt.okStatus {
+					t.Fatalf("Expected a valid coupon not to count as a failed guess, got %d: %s", status, body)
+				}
+			}
+
+			guess := `{"coupon_code":"GUESS123","items":[{"product_id":"prod1","quantity":1}]}`
+			if status, body := sendRequest(t, app, fiber.MethodPost, tt.path, guess); status != tt.status {
+				t.Fatalf("Expected %d for an unknown coupon, got %d: %s", tt.status, status, body)
+			}
+			if status, body := sendRequest(t, app, fiber.MethodPost, tt.path, guess); status != tt.status {
+				t.Fatalf("Expected %d for the second unknown coupon, got %d: %s", tt.status, status, body)
+			}
+			if status, body := sendRequest(t, app, fiber.MethodPost, tt.path, valid); status != fiber.StatusTooManyRequests {
+				t.Errorf("Expected the client to be locked out after two unknown coupons, got %d: %s", status, body)
+			}
+		})
+	}
+}
+
+// readEventStream requests an order event stream and returns its body. Open streams end with their
+// subscription, so once the handler has subscribed every subscription is dropped.
+func readEventStream(t *testing.T, app *fiber.App, service *OrderService, path, lastEventID string) string {
+	t.Helper()
+	return readEventStreamWhile(t, app, service, path, lastEventID, func() {
+		service.events.mu.Lock()
+		defer service.events.mu.Unlock()
+		for sub := range service.events.subscribers {
+			service.events.drop(sub)
+		}
+	})
+}
+
+// readEventStreamWhile requests an order event stream, calls whileOpen once the handler has subscribed
+// and returns the body once the stream has ended.
+func readEventStreamWhile(t *testing.T, app *fiber.App, service *OrderService, path, lastEventID string, whileOpen func()) string {
+	t.Helper()
+	done := make(chan string, 1)
+	go func() {
+		req := httptest.NewRequest(fiber.MethodGet, path, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Errorf("Request failed: %v", err)
+			done <- ""
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+
+	bus := service.events
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		bus.mu.Lock()
+		subscribed := len(bus.subscribers) > 0
+		bus.mu.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the handler to subscribe to order events")
+		}
+	}
+	whileOpen()
+
+	select {
+	case body := <-done:
+		return body
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the stream to end")
+		return ""
+	}
+}
+
+// eventIDs returns the IDs of the events in an event stream body, in order.
+func eventIDs(body string) []string {
+	var ids []string
+	for _, line := range strings.Split(body, "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestHandler_StreamEvents(t *testing.T) {
+	handler, service := newTestHandler(t, Config{})
+	app := fiber.New()
+	app.Get("/orders/events", handler.StreamOrderEvents)
+	app.Get("/orders/:id/events", handler.StreamEventsForOrder)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		ids = append(ids, order.ID)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		lastEventID string
+		expected    []string
+	}{
+		{"Resumes after the Last-Event-ID header", "/orders/events", "1", []string{"2", "3"}},
+		{"Falls back to the last_event_id query parameter", "/orders/events?last_event_id=2", "", []string{"3"}},
+		{"Prefers the header to the query parameter", "/orders/events?last_event_id=2", "1", []string{"2", "3"}},
+		{"Replays only the requested order", "/orders/" + ids[1] + "/events", "1", []string{"2"}},
+		{"New connections start with live events", "/orders/events", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := readEventStream(t, app, service, tt.path, tt.lastEventID)
+			if got := eventIDs(body); !slices.Equal(got, tt.expected) {
+				t.Errorf("Expected events %v, got %v in %q", tt.expected, got, body)
+			}
+			if strings.Contains(body, "event: resync") {
+				t.Errorf("Expected no resync while every event is buffered, got %q", body)
+			}
+		})
+	}
+
+	t.Run("An ID from an earlier run asks for a resync", func(t *testing.T) {
+		body := readEventStream(t, app, service, "/orders/events", "99")
+		if !strings.HasPrefix(body, "event: resync\n") || !slices.Equal(eventIDs(body), []string{"1", "2", "3"}) {
+			t.Errorf("Expected a resync followed by every buffered event, got %q", body)
+		}
+	})
+
+	t.Run("The stream of an order ends with its final status", func(t *testing.T) {
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		path := "/orders/" + order.ID + "/events"
+		body := readEventStreamWhile(t, app, service, path, "", func() {
+			if _, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "staff"}); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+		if !slices.Equal(eventIDs(body), []string{"5"}) || !strings.Contains(body, `"to":"cancelled"`) {
+			t.Errorf("Expected the stream to end with the cancellation, got %q", body)
+		}
+
+		// Already cancelled: the replay is sent, then the stream ends.
+		if status, body := sendRequest(t, app, fiber.MethodGet, path+"?last_event_id=3", ""); status != fiber.StatusOK || !slices.Equal(eventIDs(body), []string{"4", "5"}) {
+			t.Errorf("Expected the replayed events of the finished order, got %d: %q", status, body)
+		}
+		if status, body := sendRequest(t, app, fiber.MethodGet, path, ""); status != fiber.StatusOK || body != "" {
+			t.Errorf("Expected the stream of a finished order to end straight away, got %d: %q", status, body)
+		}
+	})
+
+	for _, lastEventID := range []string{"abc", "-1", "1.5"} {
+		req := httptest.NewRequest(fiber.MethodGet, "/orders/events", nil)
+		req.Header.Set("Last-Event-ID", lastEventID)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected 400 for Last-Event-ID %q, got %d", lastEventID, resp.StatusCode)
+		}
+	}
+	if status, _ := sendRequest(t, app, fiber.MethodGet, "/orders/events?last_event_id=abc", ""); status != fiber.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid last_event_id, got %d", status)
+	}
+	if status, _ := sendRequest(t, app, fiber.MethodGet, "/orders/missing/events", ""); status != fiber.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown order, got %d", status)
+	}
+}
+
+func TestHandler_OrderChangesRequireAdminKey(t *testing.T) {
+	handler, service := newTestHandler(t, Config{})
+	app := fiber.New()
+	adminAuth := middleware.NewAdminAuthMiddleware(map[string]string{"kitchen-secret": "kitchen"})
+	app.Patch("/orders/:id/status", adminAuth, handler.UpdateOrderStatus)
+	app.Post("/orders/:id/cancel", adminAuth, handler.CancelOrder)
+	app.Post("/orders/:id/refunds", adminAuth, handler.RefundOrder)
+
+	tests := []struct {
+		name     string
+		method   string
+		action   string
+		body     string
+		status   string
+		expected int
+	}{
+		{"Status change", fiber.MethodPatch, "status", `{"status": "confirmed", "changed_by": "someone-else"}`, domain.OrderStatusConfirmed, fiber.StatusOK},
+		{"Cancellation", fiber.MethodPost, "cancel", `{"changed_by": "someone-else"}`, domain.OrderStatusCancelled, fiber.StatusOK},
+		{"Refund", fiber.MethodPost, "refunds", `{"refunded_by": "someone-else"}`, domain.OrderStatusRefunded, fiber.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.status == domain.OrderStatusRefunded {
+				for _, status := range []string{domain.OrderStatusConfirmed, domain.OrderStatusPreparing, domain.OrderStatusReady, domain.OrderStatusCompleted} {
+					if _, err := service.UpdateOrderStatus(order.ID, domain.UpdateOrderStatusRequest{Status: status, ChangedBy: "staff"}); err != nil {
+						t.Fatalf("Unexpected error: %v", err)
+					}
+				}
+			}
+			path := "/orders/" + order.ID + "/" + tt.action
+
+			if status, _ := sendAdminRequest(t, app, tt.method, path, "", tt.body); status != fiber.StatusUnauthorized {
+				t.Errorf("Expected %d without an admin key, got %d", fiber.StatusUnauthorized, status)
+			}
+			if status, _ := sendAdminRequest(t, app, tt.method, path, "guess", tt.body); status != fiber.StatusForbidden {
+				t.Errorf("Expected %d with an unknown admin key, got %d", fiber.StatusForbidden, status)
+			}
+			if status, payload := sendAdminRequest(t, app, tt.method, path, "kitchen-secret", tt.body); status != tt.expected {
+				t.Fatalf("Expected %d, got %d: %s", tt.expected, status, payload)
+			}
+			updated, _ := service.GetOrder(order.ID)
+			if last := updated.StatusHistory[len(updated.StatusHistory)-1]; last.To != tt.status || last.ChangedBy != "kitchen" {
+				t.Errorf("Expected the change to be recorded as made by the key's holder, got %+v", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package orders
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kart-challenge/internal/domain"
+	"log"
+	"strings"
+
+	"github.com/lib/pq" // PostgreSQL array support
+)
+
+// PostgresOrderRepository implements OrderRepository for PostgreSQL. Orders and their line items are
+// stored in separate tables; each line keeps the product name, category and price it was ordered at.
+// The promotion breakdown, status history, refunds and pending returns are only ever read with their
+// order, so they are stored as JSON on the order row.
+type PostgresOrderRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresOrderRepository creates a PostgreSQL order repository on an open database.
+// It also ensures the table schema exists.
+func NewPostgresOrderRepository(db *sql.DB) (OrderRepository, error) {
+	createTablesSQL := `
+	CREATE TABLE IF NOT EXISTS orders (
+		id TEXT PRIMARY KEY,
+		customer_id TEXT NOT NULL DEFAULT '',
+		promo_code TEXT NOT NULL DEFAULT '',
+		total BIGINT NOT NULL,
+		discount BIGINT NOT NULL,
+		tax BIGINT NOT NULL,
+		final_price BIGINT NOT NULL,
+		refunded_total BIGINT NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL,
+		coupons JSONB NOT NULL DEFAULT '[]',
+		promotions JSONB NOT NULL DEFAULT '[]',
+		dropped_promotions JSONB NOT NULL DEFAULT '[]',
+		warnings JSONB NOT NULL DEFAULT '[]',
+		status_history JSONB NOT NULL DEFAULT '[]',
+		refunds JSONB NOT NULL DEFAULT '[]',
+		pending_returns JSONB NOT NULL DEFAULT '[]'
+	);
+	CREATE TABLE IF NOT EXISTS order_items (
+		order_id TEXT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+		line_no INTEGER NOT NULL,
+		product_id TEXT NOT NULL,
+		product_name TEXT NOT NULL,
+		product_category TEXT NOT NULL DEFAULT '',
+		product_price BIGINT,
+		unit_price BIGINT NOT NULL,
+		quantity INTEGER NOT NULL,
+		subtotal BIGINT NOT NULL,
+		discount BIGINT NOT NULL,
+		refunded_quantity INTEGER NOT NULL DEFAULT 0,
+		modifiers JSONB NOT NULL DEFAULT '[]',
+		PRIMARY KEY (order_id, line_no)
+	);
+	-- Columns added with product modifiers; lines stored before them priced the product at the unit price.
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS product_price BIGINT;
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS modifiers JSONB NOT NULL DEFAULT '[]';
+	-- Column added with retried returns of cancelled and refunded orders.
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS pending_returns JSONB NOT NULL DEFAULT '[]';
+	CREATE INDEX IF NOT EXISTS orders_created_at_idx ON orders (created_at, id);
+	CREATE INDEX IF NOT EXISTS orders_final_price_idx ON orders (final_price, id);
+	CREATE INDEX IF NOT EXISTS orders_status_created_at_idx ON orders (status, created_at, id);
+	CREATE INDEX IF NOT EXISTS orders_coupons_idx ON orders USING GIN (coupons jsonb_path_ops);
+	CREATE INDEX IF NOT EXISTS order_items_product_id_idx ON order_items (product_id, order_id);
+	CREATE INDEX IF NOT EXISTS orders_pending_returns_idx ON orders (created_at, id) WHERE pending_returns <> '[]';`
+	if _, err := db.Exec(createTablesSQL); err != nil {
+		return nil, fmt.Errorf("failed to create order tables: %w", err)
+	}
+
+	log.Println("PostgreSQL OrderRepository initialized and tables checked.")
+	return &PostgresOrderRepository{db: db}, nil
+}
+
+const selectOrderSQL = `
+	SELECT id, customer_id, promo_code, total, discount, tax, final_price, refunded_total, status, version,
+		created_at, updated_at, coupons, promotions, dropped_promotions, warnings, status_history, refunds, pending_returns
+	FROM orders`
+
+// orderJSONColumns are the order fields stored as JSON, in column order.
+func orderJSONColumns(order *domain.Order) []any {
+	return []any{&order.Coupons, &order.Promotions, &order.DroppedPromotions, &order.Warnings, &order.StatusHistory, &order.Refunds,
+		&order.PendingReturns}
+}
+
+// Create stores an order and its line items in one transaction.
+func (r *PostgresOrderRepository) Create(order domain.Order) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after a successful commit
+
+	args := []any{order.ID, order.CustomerID, order.PromoCode, order.Total, order.Discount, order.Tax, order.FinalPrice,
+		order.RefundedTotal, order.Status, order.Version, order.CreatedAt, order.UpdatedAt}
+	for _, field := range orderJSONColumns(&order) {
+		encoded, err := marshalColumn(field)
+		if err != nil {
+			return err
+		}
+		args = append(args, encoded)
+	}
+	_, err = tx.Exec(`
+	INSERT INTO orders (id, customer_id, promo_code, total, discount, tax, final_price, refunded_total, status, version,
+		created_at, updated_at, coupons, promotions, dropped_promotions, warnings, status_history, refunds, pending_returns)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to insert order %s: %w", order.ID, err)
+	}
+	if err := insertItems(tx, order); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit order %s: %w", order.ID, err)
+	}
+	return nil
+}
+
+// GetByID loads an order with its line items.
+func (r *PostgresOrderRepository) GetByID(id string) (domain.Order, bool) {
+	order, err := loadOrder(r.db, id, false)
+	if errors.Is(err, domain.ErrOrderNotFound) {
+		return domain.Order{}, false
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to get order %s from DB: %v", id, err)
+		return domain.Order{}, false
+	}
+	return order, true
+}
+
+// GetAll loads every order, oldest first.
+func (r *PostgresOrderRepository) GetAll() []domain.Order {
+	allOrders, err := r.queryOrders(selectOrderSQL + " ORDER BY created_at, id")
+	if err != nil {
+		log.Printf("ERROR: Failed to get orders from DB: %v", err)
+		return []domain.Order{}
+	}
+	return allOrders
+}
+
+// List builds one keyset-paginated query from the filters, so each page costs the same however deep
+// it is. The indexes created with the tables cover the sort orders and filters.
+func (r *PostgresOrderRepository) List(query OrderQuery) ([]domain.Order, error) {
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(query.Statuses) > 0 {
+		conditions = append(conditions, "status = ANY("+arg(pq.Array(query.Statuses))+")")
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(query.CreatedFrom))
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(query.CreatedTo))
+	}
+	if query.CouponCode != "" {
+		coupon, err := json.Marshal([]map[string]string{{"code": query.CouponCode}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode coupon filter: %w", err)
+		}
+		conditions = append(conditions, "coupons @> "+arg(string(coupon))+"::jsonb")
+	}
+	if query.ProductID != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM order_items i WHERE i.order_id = orders.id AND i.product_id = "+arg(query.ProductID)+")")
+	}
+	if query.PendingReturns {
+		conditions = append(conditions, "pending_returns <> '[]'")
+	}
+
+	column, direction, after := "created_at", "ASC", ">"
+	if query.SortBy == orderSortFinalPrice {
+		column = "final_price"
+	}
+	if query.Descending {
+		direction, after = "DESC", "<"
+	}
+	if query.After != nil {
+		var key any = query.After.CreatedAt
+		if query.SortBy == orderSortFinalPrice {
+			key = query.After.FinalPrice
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, after, arg(key), arg(query.After.ID)))
+	}
+
+	sqlQuery := selectOrderSQL
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT " + arg(query.Limit)
+	}
+
+	orders, err := r.queryOrders(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	return orders, nil
+}
+
+// queryOrders runs a query over the orders table, then loads the items of the orders it returned.
+func (r *PostgresOrderRepository) queryOrders(query string, args ...any) ([]domain.Order, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []domain.Order{}
+	index := make(map[string]int)
+	var ids []string
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[order.ID] = len(orders)
+		ids = append(ids, order.ID)
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return orders, nil
+	}
+
+	itemRows, err := r.db.Query(selectItemsSQL+" WHERE order_id = ANY($1) ORDER BY order_id, line_no", pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read order items: %w", err)
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		orderID, item, product, err := scanItem(itemRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read order items: %w", err)
+		}
+		i := index[orderID]
+		orders[i].Items = append(orders[i].Items, item)
+		orders[i].Products = append(orders[i].Products, product)
+	}
+	if err := itemRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read order items: %w", err)
+	}
+	return orders, nil
+}
+
+// Update replaces an order if nobody has updated it since expectedVersion.
+func (r *PostgresOrderRepository) Update(order domain.Order, expectedVersion int) (domain.Order, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return domain.Order{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	order.Version = expectedVersion + 1
+	if err := updateOrder(tx, order, expectedVersion); err != nil {
+		return domain.Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Order{}, fmt.Errorf("failed to commit order %s: %w", order.ID, err)
+	}
+	return order, nil
+}
+
+// UpdateTx locks the order row for the duration of fn, so concurrent updates of the same order wait
+// for each other.
+func (r *PostgresOrderRepository) UpdateTx(id string, fn func(order *domain.Order) error) (domain.Order, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return domain.Order{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	order, err := loadOrder(tx, id, true)
+	if err != nil {
+		return domain.Order{}, err
+	}
+	expectedVersion := order.Version
+	if err := fn(&order); err != nil {
+		return domain.Order{}, err
+	}
+	order.Version = expectedVersion + 1
+	if err := updateOrder(tx, order, expectedVersion); err != nil {
+		return domain.Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Order{}, fmt.Errorf("failed to commit order %s: %w", id, err)
+	}
+	return order, nil
+}
+
+// querier is what loadOrder needs from either the database or a transaction.
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+const selectItemsSQL = `
+	SELECT order_id, product_id, product_name, product_category, COALESCE(product_price, unit_price), unit_price, quantity,
+		subtotal, discount, refunded_quantity, modifiers
+	FROM order_items`
+
+// loadOrder reads one order with its line items, optionally locking the order row.
+func loadOrder(q querier, id string, forUpdate bool) (domain.Order, error) {
+	query := selectOrderSQL + " WHERE id = $1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	order, err := scanOrder(q.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Order{}, domain.ErrOrderNotFound
+	}
+	if err != nil {
+		return domain.Order{}, fmt.Errorf("failed to read order %s: %w", id, err)
+	}
+
+	rows, err := q.Query(selectItemsSQL+" WHERE order_id = $1 ORDER BY line_no", id)
+	if err != nil {
+		return domain.Order{}, fmt.Errorf("failed to read items of order %s: %w", id, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		_, item, product, err := scanItem(rows)
+		if err != nil {
+			return domain.Order{}, fmt.Errorf("failed to read items of order %s: %w", id, err)
+		}
+		order.Items = append(order.Items, item)
+		order.Products = append(order.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return domain.Order{}, fmt.Errorf("failed to read items of order %s: %w", id, err)
+	}
+	return order, nil
+}
+
+func scanOrder(row scanner) (domain.Order, error) {
+	var order domain.Order
+	encoded := make([][]byte, len(orderJSONColumns(&order)))
+	dest := []any{&order.ID, &order.CustomerID, &order.PromoCode, &order.Total, &order.Discount, &order.Tax, &order.FinalPrice,
+		&order.RefundedTotal, &order.Status, &order.Version, &order.CreatedAt, &order.UpdatedAt}
+	for i := range encoded {
+		dest = append(dest, &encoded[i])
+	}
+	if err := row.Scan(dest...); err != nil {
+		return domain.Order{}, err
+	}
+	for i, field := range orderJSONColumns(&order) {
+		if err := json.Unmarshal(encoded[i], field); err != nil {
+			return domain.Order{}, fmt.Errorf("failed to decode order %s: %w", order.ID, err)
+		}
+	}
+	order.CreatedAt = order.CreatedAt.UTC()
+	order.UpdatedAt = order.UpdatedAt.UTC()
+	return order, nil
+}
+
+// scanItem reads an order line and the product snapshot stored with it.
+func scanItem(row scanner) (string, domain.OrderLineItem, domain.Product, error) {
+	var orderID string
+	var item domain.OrderLineItem
+	var product domain.Product
+	var modifiers []byte
+	err := row.Scan(&orderID, &item.ProductID, &item.ProductName, &product.Category, &product.Price, &item.UnitPrice,
+		&item.Quantity, &item.Subtotal, &item.Discount, &item.RefundedQuantity, &modifiers)
+	if err != nil {
+		return "", domain.OrderLineItem{}, domain.Product{}, err
+	}
+	if err := json.Unmarshal(modifiers, &item.Modifiers); err != nil {
+		return "", domain.OrderLineItem{}, domain.Product{}, fmt.Errorf("failed to decode item modifiers: %w", err)
+	}
+	if len(item.Modifiers) == 0 {
+		item.Modifiers = nil
+	}
+	product.ID, product.Name = item.ProductID, item.ProductName
+	return orderID, item, product, nil
+}
+
+// updateOrder writes an order whose stored version is still expectedVersion, replacing its line items.
+func updateOrder(tx *sql.Tx, order domain.Order, expectedVersion int) error {
+	args := []any{order.ID, expectedVersion, order.CustomerID, order.PromoCode, order.Total, order.Discount, order.Tax,
+		order.FinalPrice, order.RefundedTotal, order.Status, order.Version, order.UpdatedAt}
+	for _, field := range orderJSONColumns(&order) {
+		encoded, err := marshalColumn(field)
+		if err != nil {
+			return err
+		}
+		args = append(args, encoded)
+	}
+	result, err := tx.Exec(`
+	UPDATE orders SET customer_id = $3, promo_code = $4, total = $5, discount = $6, tax = $7, final_price = $8,
+		refunded_total = $9, status = $10, version = $11, updated_at = $12, coupons = $13, promotions = $14,
+		dropped_promotions = $15, warnings = $16, status_history = $17, refunds = $18, pending_returns = $19
+	WHERE id = $1 AND version = $2`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update order %s: %w", order.ID, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update order %s: %w", order.ID, err)
+	}
+	if updated == 0 {
+		var current int
+		switch err := tx.QueryRow("SELECT version FROM orders WHERE id = $1", order.ID).Scan(&current); {
+		case errors.Is(err, sql.ErrNoRows):
+			return domain.ErrOrderNotFound
+		case err != nil:
+			return fmt.Errorf("failed to read order %s: %w", order.ID, err)
+		}
+		return fmt.Errorf("%w: order %s is at version %d, expected %d", domain.ErrOrderVersionConflict, order.ID, current, expectedVersion)
+	}
+
+	if _, err := tx.Exec("DELETE FROM order_items WHERE order_id = $1", order.ID); err != nil {
+		return fmt.Errorf("failed to replace items of order %s: %w", order.ID, err)
+	}
+	return insertItems(tx, order)
+}
+
+func insertItems(tx *sql.Tx, order domain.Order) error {
+	for i, item := range order.Items {
+		category, productPrice := "", item.UnitPrice
+		if i < len(order.Products) {
+			category, productPrice = order.Products[i].Category, order.Products[i].Price
+		}
+		modifiers, err := marshalColumn(item.Modifiers)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO order_items (order_id, line_no, product_id, product_name, product_category, product_price, unit_price,
+			quantity, subtotal, discount, refunded_quantity, modifiers)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			order.ID, i, item.ProductID, item.ProductName, category, productPrice, item.UnitPrice,
+			item.Quantity, item.Subtotal, item.Discount, item.RefundedQuantity, modifiers)
+		if err != nil {
+			return fmt.Errorf("failed to insert item %d of order %s: %w", i, order.ID, err)
+		}
+	}
+	return nil
+}
+
+// marshalColumn encodes a JSON column; nil slices are stored as empty arrays.
+func marshalColumn(field any) ([]byte, error) {
+	encoded, err := json.Marshal(field)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode order column: %w", err)
+	}
+	if string(encoded) == "null" {
+		return []byte("[]"), nil
+	}
+	return encoded, nil
+}
@@ -0,0 +1,81 @@
+package orders
+
+import (
+	"fmt"
+	"kart-challenge/internal/domain"
+)
+
+// buildRefund works out what refunding items is worth from the prices stored on the order, and marks
+// those units as refunded. Without items, every unit not yet refunded is refunded. Amounts are
+// cumulative shares of each line's discounted price and of the order tax, rounded half up, so any
+// sequence of partial refunds adds up exactly to the final price.
+func buildRefund(order *domain.Order, items []domain.RefundItem) ([]domain.RefundLine, domain.Money, domain.Money, error) {
+	quantities := make([]int, len(order.Items)) // Units to refund per order line
+	if len(items) == 0 {
+		for i, line := range order.Items {
+			quantities[i] = line.Quantity - line.RefundedQuantity
+		}
+	}
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, 0, 0, fmt.Errorf("%w: quantity for '%s' must be positive", domain.ErrInvalidRefund, item.ProductID)
+		}
+		// A product may be on several lines; fill them in order.
+		left := item.Quantity
+		for i, line := range order.Items {
+			if line.ProductID != item.ProductID || left == 0 {
+				continue
+			}
+			take := min(left, line.Quantity-line.RefundedQuantity-quantities[i])
+			quantities[i] += take
+			left -= take
+		}
+		if left > 0 {
+			return nil, 0, 0, fmt.Errorf("%w: %d more units of '%s' than can be refunded", domain.ErrInvalidRefund, left, item.ProductID)
+		}
+	}
+
+	var lines []domain.RefundLine
+	var amount domain.Money
+	for i, quantity := range quantities {
+		if quantity == 0 {
+			continue
+		}
+		line := &order.Items[i]
+		net := line.Subtotal - line.Discount
+		refunded, total := int64(line.RefundedQuantity), int64(line.Quantity)
+		lineAmount := proportion(net, refunded+int64(quantity), total) - proportion(net, refunded, total)
+		line.RefundedQuantity += quantity
+		lines = append(lines, domain.RefundLine{ProductID: line.ProductID, Quantity: quantity, Amount: lineAmount})
+		amount += lineAmount
+	}
+	if len(lines) == 0 {
+		return nil, 0, 0, domain.ErrNothingToRefund
+	}
+
+	var refundedBefore domain.Money
+	for _, refund := range order.Refunds {
+		refundedBefore += refund.Amount
+	}
+	orderNet := int64(order.Total - order.Discount)
+	tax := proportion(order.Tax, int64(refundedBefore+amount), orderNet) - proportion(order.Tax, int64(refundedBefore), orderNet)
+	return lines, amount, tax, nil
+}
+
+// proportion returns value x part / whole rounded half up; zero when whole is zero.
+func proportion(value domain.Money, part, whole int64) domain.Money {
+	if whole == 0 {
+		return 0
+	}
+	return domain.Money((int64(value)*part + whole/2) / whole)
+}
+
+// fullyRefunded reports whether every unit of the order has been refunded.
+func fullyRefunded(order *domain.Order) bool {
+	for _, line := range order.Items {
+		if line.RefundedQuantity < line.Quantity {
+			return false
+		}
+	}
+	return true
+}
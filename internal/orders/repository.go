@@ -1,9 +1,13 @@
 package orders
 
 import (
+	"cmp"
 	"fmt"
 	"kart-challenge/internal/domain"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 // OrderRepository defines the interface for order data access.
@@ -11,6 +15,81 @@ type OrderRepository interface {
 	Create(order domain.Order) error
 	GetByID(id string) (domain.Order, bool)
 	GetAll() []domain.Order
+	// List returns up to query.Limit orders matching the query, in its sort order.
+	List(query OrderQuery) ([]domain.Order, error)
+	// Update replaces a stored order if its version is still expectedVersion, and stores it with the
+	// next version. It fails with domain.ErrOrderVersionConflict if someone else updated it first.
+	Update(order domain.Order, expectedVersion int) (domain.Order, error)
+	// UpdateTx runs fn on the stored order inside a transaction and stores the result with the next
+	// version. If fn returns an error nothing is stored. fn must not call the repository.
+	UpdateTx(id string, fn func(order *domain.Order) error) (domain.Order, error)
+}
+
+// Fields orders can be sorted by.
+const (
+	orderSortCreatedAt  = "created_at"
+	orderSortFinalPrice = "final_price"
+)
+
+// OrderQuery selects a page of orders. Zero fields do not filter.
+type OrderQuery struct {
+	Statuses       []string
+	CreatedFrom    time.Time // Inclusive
+	CreatedTo      time.Time // Exclusive
+	CouponCode     string
+	ProductID      string
+	PendingReturns bool   // Only orders with domain.OrderReturn steps still to do
+	SortBy         string // orderSortCreatedAt or orderSortFinalPrice
+	Descending     bool
+	After          *OrderCursor // Last order of the previous page
+	Limit          int
+}
+
+// OrderCursor is the position of an order in a sort order: its sort key, then its ID to break ties.
+type OrderCursor struct {
+	CreatedAt  time.Time    `json:"created_at"`
+	FinalPrice domain.Money `json:"final_price"`
+	ID         string       `json:"id"`
+}
+
+// cursorOf returns the position of an order.
+func cursorOf(order domain.Order) OrderCursor {
+	return OrderCursor{CreatedAt: order.CreatedAt, FinalPrice: order.FinalPrice, ID: order.ID}
+}
+
+// compare orders two positions by the query's sort order.
+func (q OrderQuery) compare(a, b OrderCursor) int {
+	var c int
+	if q.SortBy == orderSortFinalPrice {
+		c = cmp.Compare(a.FinalPrice, b.FinalPrice)
+	} else {
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	if q.Descending {
+		return -c
+	}
+	return c
+}
+
+// matches reports whether an order passes the query's filters and comes after its cursor.
+func (q OrderQuery) matches(order domain.Order) bool {
+	switch {
+	case len(q.Statuses) > 0 && !slices.Contains(q.Statuses, order.Status),
+		!q.CreatedFrom.IsZero() && order.CreatedAt.Before(q.CreatedFrom),
+		!q.CreatedTo.IsZero() && !order.CreatedAt.Before(q.CreatedTo),
+		q.After != nil && q.compare(*q.After, cursorOf(order)) >= 0:
+		return false
+	case q.CouponCode != "" && !slices.ContainsFunc(order.Coupons, func(c domain.CouponOutcome) bool { return c.Code == q.CouponCode }):
+		return false
+	case q.ProductID != "" && !slices.ContainsFunc(order.Items, func(i domain.OrderLineItem) bool { return i.ProductID == q.ProductID }):
+		return false
+	case q.PendingReturns && len(order.PendingReturns) == 0:
+		return false
+	}
+	return true
 }
 
 // inMemoryOrderRepository is an in-memory implementation of OrderRepository.
@@ -55,3 +134,64 @@ func (r *inMemoryOrderRepository) GetAll() []domain.Order {
 	}
 	return allOrders
 }
+
+// List filters the orders, then sorts only the matches.
+func (r *inMemoryOrderRepository) List(query OrderQuery) ([]domain.Order, error) {
+	r.mu.RLock()
+	matches := make([]domain.Order, 0)
+	for _, order := range r.orders {
+		if query.matches(order) {
+			matches = append(matches, order)
+		}
+	}
+	r.mu.RUnlock()
+
+	slices.SortFunc(matches, func(a, b domain.Order) int { return query.compare(cursorOf(a), cursorOf(b)) })
+	if query.Limit > 0 && len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+	return matches, nil
+}
+
+// Update replaces an order if nobody has updated it since expectedVersion.
+func (r *inMemoryOrderRepository) Update(order domain.Order, expectedVersion int) (domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, found := r.orders[order.ID]
+	if !found {
+		return domain.Order{}, domain.ErrOrderNotFound
+	}
+	if current.Version != expectedVersion {
+		return domain.Order{}, fmt.Errorf("%w: order %s is at version %d, expected %d", domain.ErrOrderVersionConflict, order.ID, current.Version, expectedVersion)
+	}
+	order.Version = expectedVersion + 1
+	r.orders[order.ID] = order
+	return order, nil
+}
+
+// UpdateTx applies fn to a copy of the order while holding the lock, so no other update can interleave.
+func (r *inMemoryOrderRepository) UpdateTx(id string, fn func(order *domain.Order) error) (domain.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, found := r.orders[id]
+	if !found {
+		return domain.Order{}, domain.ErrOrderNotFound
+	}
+	order := cloneOrder(current)
+	if err := fn(&order); err != nil {
+		return domain.Order{}, err
+	}
+	order.Version = current.Version + 1
+	r.orders[id] = order
+	return order, nil
+}
+
+// cloneOrder copies the slices of an order that updates modify, so a failed update leaves the stored
+// order untouched.
+func cloneOrder(order domain.Order) domain.Order {
+	order.Items = append([]domain.OrderLineItem(nil), order.Items...)
+	order.StatusHistory = append([]domain.OrderStatusChange(nil), order.StatusHistory...)
+	order.Refunds = append([]domain.Refund(nil), order.Refunds...)
+	order.PendingReturns = append([]string(nil), order.PendingReturns...)
+	return order
+}
@@ -1,11 +1,18 @@
 package orders
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"kart-challenge/internal/domain" // Corrected import path
+	"kart-challenge/internal/pricing"
 	"kart-challenge/internal/products"
 	"kart-challenge/internal/promos"
 
@@ -15,8 +22,55 @@ import (
 // Service defines the interface for order business logic.
 type Service interface {
 	CreateOrder(req domain.CreateOrderRequest) (domain.Order, error)
+	QuoteOrder(req domain.CreateOrderRequest) (domain.OrderQuote, error)
 	GetOrder(orderID string) (domain.Order, bool)
 	GetAllOrders() []domain.Order
+	ListOrders(req domain.ListOrdersRequest) (domain.OrderPage, error)
+	UpdateOrderStatus(orderID string, req domain.UpdateOrderStatusRequest) (domain.Order, error)
+	CancelOrder(orderID string, req domain.CancelOrderRequest) (domain.Order, error)
+	RefundOrder(orderID string, req domain.RefundOrderRequest) (domain.Order, error)
+	// RetryPendingReturns gives back what cancelled and refunded orders still owe (see
+	// domain.OrderReturnStock) and returns how many orders were settled.
+	RetryPendingReturns() (int, error)
+	// SubscribeToEvents streams the events of one order, or of every order if orderID is empty,
+	// replaying the buffered ones after lastEventID. The caller must close the subscription.
+	SubscribeToEvents(orderID string, lastEventID uint64) (*Subscription, error)
+}
+
+// defaultEventReplaySize is how many order events are kept for clients that reconnect.
+const defaultEventReplaySize = 1000
+
+// maxStatusUpdateAttempts bounds how often an unpinned status update is retried after losing a race
+// with a concurrent update.
+const maxStatusUpdateAttempts = 3
+
+// Policies for orders whose coupon code cannot be applied.
+const (
+	InvalidCouponReject = "reject" // Refuse the order (422) with the validation reason
+	InvalidCouponWarn   = "warn"   // Create the order at full price with a warning
+	InvalidCouponIgnore = "ignore" // Create the order at full price; only the coupon outcome says why
+)
+
+// Config holds the settings an OrderService is built with.
+type Config struct {
+	InvalidCouponPolicy string           // One of the InvalidCoupon* policies, defaults to InvalidCouponWarn
+	Now                 func() time.Time // Clock for order timestamps; defaults to time.Now
+	Cart                CartPolicy
+	EventReplaySize     int // Order events kept for clients resuming a stream; defaults to 1000
+}
+
+// CouponRejectedError is returned by CreateOrder when the reject policy refuses an order because its
+// coupon cannot be applied.
+type CouponRejectedError struct {
+	Outcome domain.CouponOutcome
+}
+
+func (e *CouponRejectedError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", domain.ErrCouponRejected, e.Outcome.Message, e.Outcome.Reason)
+}
+
+func (e *CouponRejectedError) Unwrap() error {
+	return domain.ErrCouponRejected
 }
 
 // OrderService implements the Service interface.
@@ -25,76 +79,278 @@ type OrderService struct {
 	mu               sync.RWMutex
 	ProductService   products.Service // Dependency to get product details
 	PromoCodeService promos.Service   // Dependency to validate promo codes
+	PricingService   pricing.Service  // Dependency to compute totals, discounts and tax
+
+	invalidCouponPolicy string
+	now                 func() time.Time
+	cart                CartPolicy
+	events              *EventBus
 }
 
 // NewService creates a new OrderService.
-func NewService(repo OrderRepository, productService products.Service, promoCodeService promos.Service) Service {
+func NewService(repo OrderRepository, productService products.Service, promoCodeService promos.Service, pricingService pricing.Service, cfg Config) Service {
+	if cfg.InvalidCouponPolicy == "" {
+		cfg.InvalidCouponPolicy = InvalidCouponWarn
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
 	return &OrderService{
-		repo:             repo,
-		ProductService:   productService,
-		PromoCodeService: promoCodeService,
+		repo:                repo,
+		ProductService:      productService,
+		PromoCodeService:    promoCodeService,
+		PricingService:      pricingService,
+		invalidCouponPolicy: cfg.InvalidCouponPolicy,
+		now:                 cfg.Now,
+		cart:                cfg.Cart,
+		events:              NewEventBus(cfg.EventReplaySize),
 	}
 }
 
 // CreateOrder processes a new order request.
 func (s *OrderService) CreateOrder(req domain.CreateOrderRequest) (domain.Order, error) {
-	newOrder := domain.Order{
-		ID:    uuid.New().String(), // Generate a unique UUID for the order
-		Items: make([]domain.OrderLineItem, 0, len(req.Items)),
-		// OrderStatus: "pending",
-		// CreatedAt:   time.Now().Format(time.RFC3339), // ISO 8601 format
-		// UpdatedAt:   time.Now().Format(time.RFC3339),
+	newOrder, reservations, err := s.priceOrder(req, true)
+	if err != nil {
+		return domain.Order{}, err
 	}
 
-	// var totalPrice float64 = 0.0
-	// Validate and calculate total price for line items
-	for _, itemReq := range req.Items {
-		product, found := s.ProductService.GetProductByID(itemReq.ProductID)
-		if !found {
-			return domain.Order{}, domain.ErrProductNotFound // Use custom error
-		}
-		if itemReq.Quantity <= 0 {
-			return domain.Order{}, domain.ErrInvalidQuantity
-		}
-
-		lineItem := domain.OrderLineItem{
-			ProductID: itemReq.ProductID,
-			Quantity:  itemReq.Quantity,
-		}
-		newOrder.Items = append(newOrder.Items, lineItem)
-		newOrder.Products = append(newOrder.Products, product)
-		// totalPrice += product.Price * float64(itemReq.Quantity)
-	}
-	// newOrder.TotalPrice = totalPrice
-	// newOrder.FinalPrice = totalPrice // Initialize final price before discount
-
-	// Apply promo code if provided
-	if req.CouponCode != "" {
-		isValid, _ := s.PromoCodeService.ValidatePromoCode(req.CouponCode)
-		if isValid {
-			// newOrder.PromoCode = req.CouponCode
-			// Apply a simple discount (e.g., 10% off for valid promo codes)
-			// discountAmount := newOrder.TotalPrice * 0.10
-			// newOrder.Discount = discountAmount
-			// newOrder.FinalPrice = newOrder.TotalPrice - discountAmount
-			// log.Printf("Order %s: Promo code '%s' applied. Discount: %.2f, Final Price: %.2f", newOrder.ID, req.PromoCode, newOrder.Discount, newOrder.FinalPrice)
-			log.Printf("Order %s: Promo code '%s' applied", newOrder.ID, req.CouponCode)
-		} else {
-			// Even if promo code is invalid, we proceed with the order without discount
-			log.Printf("Order %s: Invalid promo code '%s' provided. Proceeding without discount.", newOrder.ID, req.CouponCode)
-			// Optionally, you might want to return an error here or add a specific message to the order object
-			// newOrder.Message = "Invalid promo code, order created without discount."
-		}
+	// Someone else may have taken the last units since the cart was checked; then nothing is taken.
+	quantities := stockQuantities(newOrder.Items)
+	if err := s.ProductService.ReserveStock(quantities); err != nil {
+		s.releaseRedemptions(newOrder.ID, reservations)
+		return domain.Order{}, err
 	}
-
 	if err := s.repo.Create(newOrder); err != nil {
+		s.ProductService.ReleaseStock(quantities)
+		s.releaseRedemptions(newOrder.ID, reservations)
 		return domain.Order{}, fmt.Errorf("failed to save order: %w", err)
 	}
+	for code, reservationID := range reservations {
+		if err := s.PromoCodeService.CommitRedemption(reservationID, newOrder.ID); err != nil {
+			log.Printf("ERROR: Order %s: failed to record redemption of promo code '%s': %v", newOrder.ID, code, err)
+		}
+	}
 
-	log.Printf("Created new order: %s", newOrder.ID)
+	log.Printf("Created new order: %s (total %s, discount %s, tax %s, final %s)", newOrder.ID, newOrder.Total, newOrder.Discount, newOrder.Tax, newOrder.FinalPrice)
+	s.publish(domain.OrderEventCreated, newOrder, nil)
 	return newOrder, nil
 }
 
+// QuoteOrder prices an order request exactly as CreateOrder would, without saving an order or using
+// up any coupon.
+func (s *OrderService) QuoteOrder(req domain.CreateOrderRequest) (domain.OrderQuote, error) {
+	order, _, err := s.priceOrder(req, false)
+	if err != nil {
+		return domain.OrderQuote{}, err
+	}
+	return domain.OrderQuote{
+		Items:             order.Items,
+		Products:          order.Products,
+		Coupons:           order.Coupons,
+		Promotions:        order.Promotions,
+		DroppedPromotions: order.DroppedPromotions,
+		Warnings:          order.Warnings,
+		Total:             order.Total,
+		Discount:          order.Discount,
+		Tax:               order.Tax,
+		FinalPrice:        order.FinalPrice,
+	}, nil
+}
+
+// priceOrder builds an order from a request: it resolves the products, checks every coupon against
+// the cart, and prices the order with the coupons and automatic promotions. With reserve set, one use
+// of each applied coupon is held and the reservations are returned by code; the caller must commit or
+// release them. Without it nothing is held, so the order must not be saved.
+func (s *OrderService) priceOrder(req domain.CreateOrderRequest, reserve bool) (domain.Order, map[string]string, error) {
+	now := s.now()
+	newOrder := domain.Order{
+		ID:         uuid.New().String(), // Generate a unique UUID for the order
+		CustomerID: req.CustomerID,
+		Items:      make([]domain.OrderLineItem, 0, len(req.Items)),
+		Status:     domain.OrderStatusPending,
+		Version:    1,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		StatusHistory: []domain.OrderStatusChange{
+			{To: domain.OrderStatusPending, ChangedBy: orderCreator(req), ChangedAt: now},
+		},
+	}
+
+	// Validate line items against the cart policy and resolve their products
+	cart, err := s.resolveCart(req.Items)
+	if err != nil {
+		return domain.Order{}, nil, err
+	}
+	lines := make([]pricing.Line, 0, len(cart))
+	for _, line := range cart {
+		snapshot := line.product
+		snapshot.Stock = nil // The count at order time would only go stale on the order
+		newOrder.Items = append(newOrder.Items, line.item)
+		newOrder.Products = append(newOrder.Products, snapshot)
+		lines = append(lines, pricing.Line{Item: line.item, Product: line.product})
+	}
+	if !pricing.WithinMaxTotal(lines) {
+		invalid := &ValidationError{}
+		invalid.add("items", domain.ErrOrderTotalTooLarge, fmt.Sprintf("the order total must not exceed %s", pricing.MaxTotal))
+		return domain.Order{}, nil, invalid
+	}
+
+	// Collect the promotions the order qualifies for: one per usable coupon code, plus the automatic
+	// ones. Every coupon's outcome is recorded on the order, so the customer can see why it was not applied.
+	var promotions []pricing.Promotion
+	reservations := make(map[string]string) // Coupon code -> redemption reservation ID; empty when nothing is held
+	for _, code := range couponCodes(req) {
+		outcome, campaign, reservationID := s.redeemCoupon(newOrder.ID, code, req.CustomerID, lines, reserve)
+		newOrder.Coupons = append(newOrder.Coupons, outcome)
+		if campaign != nil {
+			reservations[code] = reservationID
+			promotions = append(promotions, pricing.Promotion{Campaign: *campaign, Code: code})
+			continue
+		}
+
+		// A valid code that simply has no campaign is not an error; everything else is.
+		if outcome.Reason != domain.PromoReasonValid {
+			switch s.invalidCouponPolicy {
+			case InvalidCouponReject:
+				s.releaseRedemptions(newOrder.ID, reservations)
+				return domain.Order{}, nil, &CouponRejectedError{Outcome: outcome}
+			case InvalidCouponWarn:
+				newOrder.Warnings = append(newOrder.Warnings, fmt.Sprintf("Coupon '%s' was not applied: %s", code, outcome.Message))
+			}
+		}
+	}
+	for _, campaign := range s.PromoCodeService.ActiveAutomaticCampaigns() {
+		promotions = append(promotions, pricing.Promotion{Campaign: campaign})
+	}
+
+	priced := s.PricingService.PriceOrder(lines, promotions)
+	newOrder.Items = priced.Items
+	newOrder.Total = priced.Total
+	newOrder.Discount = priced.Discount
+	newOrder.Tax = priced.Tax
+	newOrder.FinalPrice = priced.FinalPrice
+	newOrder.Promotions = priced.Applied
+	newOrder.DroppedPromotions = priced.Dropped
+
+	// Coupons that lost out to other promotions give their reserved use back. Losing out is not an
+	// invalid coupon, so it never rejects the order.
+	dropped := make(map[string]domain.DroppedPromotion)
+	for _, d := range priced.Dropped {
+		if d.Code != "" {
+			dropped[d.Code] = d
+		}
+	}
+	for i := range newOrder.Coupons {
+		coupon := &newOrder.Coupons[i]
+		reservationID, offered := reservations[coupon.Code]
+		if !offered {
+			continue
+		}
+		d, lost := dropped[coupon.Code]
+		if !lost {
+			coupon.Applied = true
+			if newOrder.PromoCode == "" {
+				newOrder.PromoCode = coupon.Code
+			}
+			log.Printf("Order %s: Promo code '%s' applied with campaign '%s'", newOrder.ID, coupon.Code, coupon.CampaignID)
+			continue
+		}
+
+		coupon.Reason = d.Reason
+		coupon.Message = fmt.Sprintf("Not applied because %s.", d.Message)
+		log.Printf("Order %s: Promo code '%s' dropped (%s): %s", newOrder.ID, coupon.Code, d.Reason, d.Message)
+		s.releaseRedemptions(newOrder.ID, map[string]string{coupon.Code: reservationID})
+		delete(reservations, coupon.Code)
+		if s.invalidCouponPolicy == InvalidCouponWarn {
+			newOrder.Warnings = append(newOrder.Warnings, fmt.Sprintf("Coupon '%s' was not applied: %s", coupon.Code, coupon.Message))
+		}
+	}
+
+	return newOrder, reservations, nil
+}
+
+// orderCreator names who created an order in its status history.
+func orderCreator(req domain.CreateOrderRequest) string {
+	if req.CustomerID != "" {
+		return "customer:" + req.CustomerID
+	}
+	return "customer"
+}
+
+// couponCodes returns the coupon codes of a request in the order given, without blanks or repeats.
+func couponCodes(req domain.CreateOrderRequest) []string {
+	var codes []string
+	seen := make(map[string]bool)
+	for _, code := range append([]string{req.CouponCode}, req.CouponCodes...) {
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// redeemCoupon validates a coupon code against the cart and, if the cart qualifies for its campaign
+// and reserve is set, holds one use of it until the order is saved, so concurrent orders cannot exceed
+// its limits. The campaign is nil when the code cannot be applied; the outcome says why.
+func (s *OrderService) redeemCoupon(orderID, code, customerID string, lines []pricing.Line, reserve bool) (domain.CouponOutcome, *domain.Campaign, string) {
+	result := s.PromoCodeService.ValidatePromoCodeForCart(code, customerID, lines)
+	outcome := domain.CouponOutcome{Code: code, Reason: result.Reason, Message: result.Message}
+	switch {
+	case !result.Valid:
+		// Even if promo code is invalid, we proceed with the order without its discount
+		log.Printf("Order %s: Promo code '%s' rejected (%s). Proceeding without its discount.", orderID, code, result.Reason)
+		return outcome, nil, ""
+	case result.Campaign == nil:
+		outcome.Message = "Promo code is valid but no campaign matches it, so no discount applies."
+		log.Printf("Order %s: Promo code '%s' is valid but no campaign matches it. No discount applied.", orderID, code)
+		return outcome, nil, ""
+	}
+
+	outcome.CampaignID = result.Campaign.ID
+	if result.Applicable != nil && !*result.Applicable {
+		log.Printf("Order %s: Promo code '%s' does not apply to this cart: %s", orderID, code, result.Message)
+		return outcome, nil, ""
+	}
+	if !reserve {
+		return outcome, result.Campaign, ""
+	}
+	reservationID, err := s.PromoCodeService.ReserveRedemption(code, customerID)
+	if err != nil {
+		outcome.Reason, outcome.Message = redemptionRejection(err)
+		log.Printf("Order %s: Promo code '%s' cannot be redeemed: %v. Proceeding without its discount.", orderID, code, err)
+		return outcome, nil, ""
+	}
+	return outcome, result.Campaign, reservationID
+}
+
+// releaseRedemptions gives back the uses reserved for an order that will not be saved with them.
+func (s *OrderService) releaseRedemptions(orderID string, reservations map[string]string) {
+	for code, reservationID := range reservations {
+		if reservationID == "" {
+			continue
+		}
+		if err := s.PromoCodeService.ReleaseRedemption(reservationID); err != nil {
+			log.Printf("ERROR: Order %s: failed to release reservation of promo code '%s': %v", orderID, code, err)
+		}
+	}
+}
+
+// redemptionRejection maps a failed redemption reservation to the reason and message shown on the order.
+func redemptionRejection(err error) (string, string) {
+	switch {
+	case errors.Is(err, domain.ErrPromoCodeExhausted):
+		return domain.PromoReasonExhausted, "Promo code has reached its usage limit."
+	case errors.Is(err, domain.ErrPromoCodeCustomerLimit):
+		return domain.PromoReasonCustomerLimitReached, "Promo code has already been used the maximum number of times by this customer."
+	case errors.Is(err, domain.ErrCustomerIDRequired):
+		return domain.PromoReasonCustomerIDRequired, "This promo code can only be used with a customer_id."
+	default:
+		return domain.PromoReasonExhausted, "Promo code could not be redeemed."
+	}
+}
+
 // GetOrder implements the logic to retrieve an order by its ID.
 func (s *OrderService) GetOrder(orderID string) (domain.Order, bool) {
 	return s.repo.GetByID(orderID)
@@ -104,3 +360,337 @@ func (s *OrderService) GetOrder(orderID string) (domain.Order, bool) {
 func (s *OrderService) GetAllOrders() []domain.Order {
 	return s.repo.GetAll()
 }
+
+// Page sizes for listing orders.
+const (
+	defaultOrderPageSize = 20
+	maxOrderPageSize     = 100
+)
+
+// pageToken is what an order list cursor encodes: where the previous page ended and the sort order it
+// belongs to, so a cursor cannot be reused with another sort.
+type pageToken struct {
+	Sort string `json:"sort"`
+	OrderCursor
+}
+
+// ListOrders returns one page of the orders matching the request's filters.
+func (s *OrderService) ListOrders(req domain.ListOrdersRequest) (domain.OrderPage, error) {
+	if req.Sort == "" {
+		req.Sort = domain.OrderSortNewest
+	}
+	query := OrderQuery{
+		Statuses:    req.Statuses,
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+		CouponCode:  req.CouponCode,
+		ProductID:   req.ProductID,
+		SortBy:      strings.TrimPrefix(req.Sort, "-"),
+		Descending:  strings.HasPrefix(req.Sort, "-"),
+		Limit:       req.Limit,
+	}
+
+	switch req.Sort {
+	case domain.OrderSortNewest, domain.OrderSortOldest, domain.OrderSortPriceLowest, domain.OrderSortPriceHighest:
+	default:
+		return domain.OrderPage{}, fmt.Errorf("%w: unknown sort '%s'", domain.ErrInvalidOrderQuery, req.Sort)
+	}
+	for _, status := range req.Statuses {
+		if _, known := statusTransitions[status]; !known {
+			return domain.OrderPage{}, fmt.Errorf("%w: unknown status '%s'", domain.ErrInvalidOrderQuery, status)
+		}
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultOrderPageSize
+	}
+	if query.Limit < 0 || query.Limit > maxOrderPageSize {
+		return domain.OrderPage{}, fmt.Errorf("%w: limit must be between 1 and %d", domain.ErrInvalidOrderQuery, maxOrderPageSize)
+	}
+	if req.Cursor != "" {
+		var token pageToken
+		raw, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+		if err == nil {
+			err = json.Unmarshal(raw, &token)
+		}
+		if err != nil || token.ID == "" {
+			return domain.OrderPage{}, fmt.Errorf("%w: malformed cursor", domain.ErrInvalidOrderQuery)
+		}
+		if token.Sort != req.Sort {
+			return domain.OrderPage{}, fmt.Errorf("%w: cursor belongs to sort '%s'", domain.ErrInvalidOrderQuery, token.Sort)
+		}
+		query.After = &token.OrderCursor
+	}
+
+	// Ask for one more order than fits on the page to learn whether there is a next page.
+	pageSize := query.Limit
+	query.Limit++
+	orders, err := s.repo.List(query)
+	if err != nil {
+		return domain.OrderPage{}, err
+	}
+
+	page := domain.OrderPage{Orders: orders}
+	if len(orders) > pageSize {
+		page.Orders = orders[:pageSize]
+		raw, err := json.Marshal(pageToken{Sort: req.Sort, OrderCursor: cursorOf(page.Orders[pageSize-1])})
+		if err != nil {
+			return domain.OrderPage{}, fmt.Errorf("failed to encode cursor: %w", err)
+		}
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return page, nil
+}
+
+// UpdateOrderStatus moves an order to a new status if the state machine allows it, and records who
+// made the change. If req.Version is set the change only applies to that version of the order;
+// otherwise it is checked against the latest version and retried if a concurrent update wins the race.
+// Cancelling and refunding go through CancelOrder and RefundOrder (a full refund), so promo
+// redemptions are given back whichever way the order gets there.
+func (s *OrderService) UpdateOrderStatus(orderID string, req domain.UpdateOrderStatusRequest) (domain.Order, error) {
+	switch req.Status {
+	case domain.OrderStatusCancelled:
+		return s.CancelOrder(orderID, domain.CancelOrderRequest{ChangedBy: req.ChangedBy, Reason: req.Reason, Version: req.Version})
+	case domain.OrderStatusRefunded:
+		return s.RefundOrder(orderID, domain.RefundOrderRequest{RefundedBy: req.ChangedBy, Reason: req.Reason, Version: req.Version})
+	}
+
+	for attempt := 1; ; attempt++ {
+		order, found := s.repo.GetByID(orderID)
+		if !found {
+			return domain.Order{}, domain.ErrOrderNotFound
+		}
+		if err := checkVersion(order, req.Version); err != nil {
+			return domain.Order{}, err
+		}
+		if err := checkTransition(order.Status, req.Status); err != nil {
+			return domain.Order{}, err
+		}
+
+		expectedVersion := order.Version
+		order.StatusHistory = append([]domain.OrderStatusChange(nil), order.StatusHistory...)
+		change := recordStatus(&order, req.Status, req.ChangedBy, req.Reason, s.now())
+
+		updated, err := s.repo.Update(order, expectedVersion)
+		if errors.Is(err, domain.ErrOrderVersionConflict) && req.Version == 0 && attempt < maxStatusUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return domain.Order{}, err
+		}
+		log.Printf("Order %s: status %s -> %s by %s", orderID, change.From, change.To, change.ChangedBy)
+		s.publish(domain.OrderEventStatusChanged, updated, &change)
+		return updated, nil
+	}
+}
+
+// CancelOrder cancels an order that is not ready yet. The cancellation is stored together with the
+// returns it owes, the stock and promo code uses the order took, which are then given back. If that
+// fails the cancellation stands and the error wraps domain.ErrOrderReturnsPending; the returns are
+// retried by RetryPendingReturns.
+func (s *OrderService) CancelOrder(orderID string, req domain.CancelOrderRequest) (domain.Order, error) {
+	now := s.now()
+	var change domain.OrderStatusChange
+	order, err := s.repo.UpdateTx(orderID, func(order *domain.Order) error {
+		if err := checkVersion(*order, req.Version); err != nil {
+			return err
+		}
+		if err := checkTransition(order.Status, domain.OrderStatusCancelled); err != nil {
+			return err
+		}
+		change = recordStatus(order, domain.OrderStatusCancelled, req.ChangedBy, req.Reason, now)
+		order.PendingReturns = append(order.PendingReturns, domain.OrderReturnStock)
+		if hasCoupon(*order) {
+			order.PendingReturns = append(order.PendingReturns, domain.OrderReturnRedemptions)
+		}
+		log.Printf("Order %s: cancelled by %s", order.ID, req.ChangedBy)
+		return nil
+	})
+	if err != nil {
+		return domain.Order{}, err
+	}
+	s.publish(domain.OrderEventStatusChanged, order, &change)
+	// Only once the cancellation is stored, so a failed one never hands out the same units or uses twice.
+	return s.settleReturns(order)
+}
+
+// RefundOrder refunds some or all units of a completed order, using the prices stored on it, in one
+// repository transaction. Once every unit is refunded the order moves to refunded and its promo code
+// uses are given back like CancelOrder gives back a cancelled order's.
+func (s *OrderService) RefundOrder(orderID string, req domain.RefundOrderRequest) (domain.Order, error) {
+	now := s.now()
+	var change *domain.OrderStatusChange
+	order, err := s.repo.UpdateTx(orderID, func(order *domain.Order) error {
+		if err := checkVersion(*order, req.Version); err != nil {
+			return err
+		}
+		if order.Status != domain.OrderStatusCompleted {
+			return fmt.Errorf("%w: only completed orders can be refunded, order is %s", domain.ErrInvalidStatusTransition, order.Status)
+		}
+
+		lines, amount, tax, err := buildRefund(order, req.Items)
+		if err != nil {
+			return err
+		}
+		refund := domain.Refund{
+			ID:         uuid.New().String(),
+			Items:      lines,
+			Amount:     amount,
+			Tax:        tax,
+			Total:      amount + tax,
+			RefundedBy: req.RefundedBy,
+			Reason:     req.Reason,
+			CreatedAt:  now,
+		}
+		order.Refunds = append(order.Refunds, refund)
+		order.RefundedTotal += refund.Total
+		order.UpdatedAt = now
+		log.Printf("Order %s: refunded %s by %s", order.ID, refund.Total, req.RefundedBy)
+
+		if !fullyRefunded(order) {
+			return nil
+		}
+		refunded := recordStatus(order, domain.OrderStatusRefunded, req.RefundedBy, req.Reason, now)
+		change = &refunded
+		if hasCoupon(*order) {
+			order.PendingReturns = append(order.PendingReturns, domain.OrderReturnRedemptions)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Order{}, err
+	}
+	if change == nil {
+		return order, nil
+	}
+	s.publish(domain.OrderEventStatusChanged, order, change)
+	return s.settleReturns(order)
+}
+
+// checkVersion fails with a conflict when the caller pinned a version the order is no longer at.
+func checkVersion(order domain.Order, version int) error {
+	if version != 0 && version != order.Version {
+		return fmt.Errorf("%w: order %s is at version %d, expected %d", domain.ErrOrderVersionConflict, order.ID, order.Version, version)
+	}
+	return nil
+}
+
+// recordStatus moves an order to a new status and appends the change to its history.
+func recordStatus(order *domain.Order, status, changedBy, reason string, now time.Time) domain.OrderStatusChange {
+	change := domain.OrderStatusChange{From: order.Status, To: status, ChangedBy: changedBy, Reason: reason, ChangedAt: now}
+	order.Status = status
+	order.UpdatedAt = now
+	order.StatusHistory = append(order.StatusHistory, change)
+	return change
+}
+
+// SubscribeToEvents subscribes to the order event bus. Unknown orders are rejected, since no events
+// would ever arrive for them.
+func (s *OrderService) SubscribeToEvents(orderID string, lastEventID uint64) (*Subscription, error) {
+	if orderID != "" {
+		if _, found := s.repo.GetByID(orderID); !found {
+			return nil, domain.ErrOrderNotFound
+		}
+	}
+	return s.events.Subscribe(orderID, lastEventID), nil
+}
+
+// publish tells the subscribers about a stored change to an order.
+func (s *OrderService) publish(eventType string, order domain.Order, change *domain.OrderStatusChange) {
+	s.events.Publish(domain.OrderEvent{
+		Type:       eventType,
+		OrderID:    order.ID,
+		Change:     change,
+		Order:      order,
+		OccurredAt: s.now(),
+	})
+}
+
+// stockQuantities adds up the units of each product on order lines that have not been refunded.
+func stockQuantities(items []domain.OrderLineItem) map[string]int {
+	quantities := make(map[string]int)
+	for _, item := range items {
+		if units := item.Quantity - item.RefundedQuantity; units > 0 {
+			quantities[item.ProductID] += units
+		}
+	}
+	return quantities
+}
+
+// hasCoupon reports whether an order redeemed any promo code uses.
+func hasCoupon(order domain.Order) bool {
+	for _, promotion := range order.Promotions {
+		if promotion.Code != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingReturnsBatchSize bounds how many orders one RetryPendingReturns run settles.
+const pendingReturnsBatchSize = 100
+
+// RetryPendingReturns settles the oldest orders whose returns failed after they were cancelled or
+// refunded. It carries on past orders that fail again and reports their errors together.
+func (s *OrderService) RetryPendingReturns() (int, error) {
+	pending, err := s.repo.List(OrderQuery{PendingReturns: true, Limit: pendingReturnsBatchSize})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list orders with pending returns: %w", err)
+	}
+	settled := 0
+	var errs []error
+	for _, order := range pending {
+		if _, err := s.settleReturns(order); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		settled++
+	}
+	return settled, errors.Join(errs...)
+}
+
+// settleReturns does the pending returns of an order and removes the ones done from the stored
+// order, which it returns. Revoking promo code uses is idempotent, so it is done first and simply
+// repeated if removing it fails. Stock lives in memory and releasing it cannot fail, so it is only
+// released by whoever removed its step, never twice.
+func (s *OrderService) settleReturns(order domain.Order) (domain.Order, error) {
+	if len(order.PendingReturns) == 0 {
+		return order, nil
+	}
+	done := map[string]bool{domain.OrderReturnStock: true}
+	var failed error
+	if slices.Contains(order.PendingReturns, domain.OrderReturnRedemptions) {
+		revoked, err := s.PromoCodeService.RevokeRedemptions(order.ID)
+		if err != nil {
+			failed = returnsFailed(order.ID, domain.OrderReturnRedemptions, err)
+		} else {
+			log.Printf("Order %s: gave back %d promo code redemptions", order.ID, revoked)
+			done[domain.OrderReturnRedemptions] = true
+		}
+	}
+
+	if failed != nil && !slices.Contains(order.PendingReturns, domain.OrderReturnStock) {
+		return domain.Order{}, failed // Nothing done to store
+	}
+	releaseStock := false
+	updated, err := s.repo.UpdateTx(order.ID, func(order *domain.Order) error {
+		releaseStock = slices.Contains(order.PendingReturns, domain.OrderReturnStock)
+		order.PendingReturns = slices.DeleteFunc(order.PendingReturns, func(step string) bool { return done[step] })
+		return nil
+	})
+	if err != nil {
+		return domain.Order{}, returnsFailed(order.ID, strings.Join(order.PendingReturns, " and "), err)
+	}
+	if releaseStock {
+		s.ProductService.ReleaseStock(stockQuantities(updated.Items))
+	}
+	if failed != nil {
+		return domain.Order{}, failed
+	}
+	return updated, nil
+}
+
+// returnsFailed logs a failed return step for follow-up and wraps the error for the caller.
+func returnsFailed(orderID, step string, err error) error {
+	log.Printf("ERROR: Order %s: failed to give back %s, will retry: %v", orderID, step, err)
+	return fmt.Errorf("%w: order %s: %s: %v", domain.ErrOrderReturnsPending, orderID, step, err)
+}
@@ -1,17 +1,29 @@
 package orders
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"kart-challenge/internal/domain"
+	"kart-challenge/internal/pricing"
+	"kart-challenge/internal/promos"
+	"reflect"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Mock OrderRepository for testing OrderService
 type mockOrderRepository struct {
-	orders map[string]domain.Order
+	orders    map[string]domain.Order
+	createErr error
 }
 
 func (m *mockOrderRepository) Create(order domain.Order) error {
+	if m.createErr != nil {
+		return m.createErr
+	}
 	m.orders[order.ID] = order
 	return nil
 }
@@ -29,9 +41,73 @@ func (m *mockOrderRepository) GetAll() []domain.Order {
 	return allOrders
 }
 
-// Mock ProductService for OrderService tests
+func (m *mockOrderRepository) List(query OrderQuery) ([]domain.Order, error) {
+	var matches []domain.Order
+	for _, o := range m.orders {
+		if query.matches(o) {
+			matches = append(matches, o)
+		}
+	}
+	slices.SortFunc(matches, func(a, b domain.Order) int { return query.compare(cursorOf(a), cursorOf(b)) })
+	return matches[:min(len(matches), query.Limit)], nil
+}
+
+func (m *mockOrderRepository) Update(order domain.Order, expectedVersion int) (domain.Order, error) {
+	current, found := m.orders[order.ID]
+	if !found {
+		return domain.Order{}, domain.ErrOrderNotFound
+	}
+	if current.Version != expectedVersion {
+		return domain.Order{}, domain.ErrOrderVersionConflict
+	}
+	order.Version = expectedVersion + 1
+	m.orders[order.ID] = order
+	return order, nil
+}
+
+func (m *mockOrderRepository) UpdateTx(id string, fn func(order *domain.Order) error) (domain.Order, error) {
+	current, found := m.orders[id]
+	if !found {
+		return domain.Order{}, domain.ErrOrderNotFound
+	}
+	order := cloneOrder(current)
+	if err := fn(&order); err != nil {
+		return domain.Order{}, err
+	}
+	order.Version = current.Version + 1
+	m.orders[id] = order
+	return order, nil
+}
+
+// commitFailingRepository runs update functions like mockOrderRepository, then fails to store the
+// result while failCommit is set, as a database commit can.
+type commitFailingRepository struct {
+	*mockOrderRepository
+	failCommit bool
+}
+
+func (r *commitFailingRepository) UpdateTx(id string, fn func(order *domain.Order) error) (domain.Order, error) {
+	if !r.failCommit {
+		return r.mockOrderRepository.UpdateTx(id, fn)
+	}
+	current, found := r.orders[id]
+	if !found {
+		return domain.Order{}, domain.ErrOrderNotFound
+	}
+	order := cloneOrder(current)
+	if err := fn(&order); err != nil {
+		return domain.Order{}, err
+	}
+	return domain.Order{}, errors.New("commit failed")
+}
+
+// Mock ProductService for OrderService tests. Products are available unless sold out, and their
+// stock is tracked when it is in stock.
 type mockProductService struct {
 	products map[string]domain.Product
+	soldOut  map[string]bool
+	stock    map[string]int
+	mu       sync.Mutex
 }
 
 func (m *mockProductService) GetAllProducts() []domain.Product {
@@ -39,31 +115,131 @@ func (m *mockProductService) GetAllProducts() []domain.Product {
 }
 
 func (m *mockProductService) GetProductByID(id string) (domain.Product, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	p, found := m.products[id]
+	p.Available = !m.soldOut[id]
+	if left, tracked := m.stock[id]; tracked {
+		p.Stock = &left
+	}
 	return p, found
 }
 
-// Mock PromoCodeService for OrderService tests
+func (m *mockProductService) SetAvailability(id string, req domain.SetAvailabilityRequest) (domain.Product, error) {
+	return domain.Product{}, errors.New("not needed for these tests")
+}
+
+func (m *mockProductService) ReserveStock(quantities map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, quantity := range quantities {
+		if left, tracked := m.stock[id]; m.soldOut[id] || (tracked && left < quantity) {
+			return fmt.Errorf("%w: %s", domain.ErrProductUnavailable, id)
+		}
+	}
+	for id, quantity := range quantities {
+		if _, tracked := m.stock[id]; tracked {
+			m.stock[id] -= quantity
+		}
+	}
+	return nil
+}
+
+func (m *mockProductService) ReleaseStock(quantities map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, quantity := range quantities {
+		if _, tracked := m.stock[id]; tracked {
+			m.stock[id] += quantity
+		}
+	}
+}
+
+// Mock PromoCodeService for OrderService tests.
+// The embedded interface satisfies the admin/dataset methods these tests never call.
 type mockPromoCodeService struct {
+	promos.Service
 	validPromoCodes map[string]bool
+	campaigns       map[string]domain.Campaign
+	automatic       []domain.Campaign
+	reserveErr      error
+	revokeErr       error
+	reserved        []string
+	committed       []string
+	released        []string
+	revoked         []string
 }
 
-func (m *mockPromoCodeService) LoadPromoCodesFromURLs(urls []string) error {
+func (m *mockPromoCodeService) LoadPromoCodesFromURLs(ctx context.Context, urls []string) error {
 	return nil // Not needed for these tests
 }
 
-func (m *mockPromoCodeService) ValidatePromoCode(code string) (bool, string) {
-	isValid := m.validPromoCodes[code]
-	if isValid {
-		return true, "Promo code is valid."
+func (m *mockPromoCodeService) ValidatePromoCode(code, customerID string) domain.PromoValidationResult {
+	if !m.validPromoCodes[code] {
+		return domain.PromoValidationResult{Reason: domain.PromoReasonNotEnoughSources, Message: "Promo code is invalid."}
+	}
+	result := domain.PromoValidationResult{Valid: true, Reason: domain.PromoReasonValid, Message: "Promo code is valid."}
+	if campaign, ok := m.campaigns[code]; ok {
+		result.Campaign = &campaign
+	}
+	return result
+}
+
+func (m *mockPromoCodeService) ValidatePromoCodeForCart(code, customerID string, lines []pricing.Line) domain.PromoValidationResult {
+	result := m.ValidatePromoCode(code, customerID)
+	if result.Valid && result.Campaign != nil {
+		applicable, message := pricing.CheckConditions(*result.Campaign, lines)
+		result.Applicable = &applicable
+		if !applicable {
+			result.Reason, result.Message = domain.PromoReasonNotApplicable, message
+		}
+	}
+	return result
+}
+
+func (m *mockPromoCodeService) ResolveCampaign(code string) (domain.Campaign, bool) {
+	campaign, ok := m.campaigns[code]
+	return campaign, ok
+}
+
+func (m *mockPromoCodeService) ActiveAutomaticCampaigns() []domain.Campaign {
+	return m.automatic
+}
+
+func (m *mockPromoCodeService) ReserveRedemption(code, customerID string) (string, error) {
+	if m.reserveErr != nil {
+		return "", m.reserveErr
 	}
-	return false, "Promo code is invalid."
+	m.reserved = append(m.reserved, code)
+	return "reservation-" + code, nil
+}
+
+func (m *mockPromoCodeService) CommitRedemption(reservationID, orderID string) error {
+	m.committed = append(m.committed, reservationID)
+	return nil
+}
+
+func (m *mockPromoCodeService) ReleaseRedemption(reservationID string) error {
+	m.released = append(m.released, reservationID)
+	return nil
+}
+
+func (m *mockPromoCodeService) RevokeRedemptions(orderID string) (int, error) {
+	if m.revokeErr != nil {
+		return 0, m.revokeErr
+	}
+	m.revoked = append(m.revoked, orderID)
+	return 1, nil
 }
 
 func (m *mockPromoCodeService) GetPromoCodeCounts() map[string]int {
 	return nil // Not needed for these tests
 }
 
+func (m *mockPromoCodeService) GetLoadStatus() domain.PromoLoadStatus {
+	return domain.PromoLoadStatus{State: domain.PromoLoadStateCompleted}
+}
+
 func (m *mockPromoCodeService) Close() error {
 	return nil // No-op
 }
@@ -71,9 +247,9 @@ func (m *mockPromoCodeService) Close() error {
 func TestOrderService_CreateOrder(t *testing.T) {
 	// Setup mocks
 	mockProducts := map[string]domain.Product{
-		"prod1": {ID: "prod1", Name: "Burger", Price: 10.0},
-		"prod2": {ID: "prod2", Name: "Fries", Price: 5.0},
-		"prod3": {ID: "prod3", Name: "Unavailable Drink", Price: 3.0},
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+		"prod3": {ID: "prod3", Name: "Unavailable Drink", Price: 300},
 	}
 	productService := &mockProductService{products: mockProducts}
 
@@ -81,7 +257,7 @@ func TestOrderService_CreateOrder(t *testing.T) {
 	promoCodeService := &mockPromoCodeService{validPromoCodes: validPromoCodes}
 
 	orderRepo := &mockOrderRepository{orders: make(map[string]domain.Order)}
-	service := NewService(orderRepo, productService, promoCodeService)
+	service := NewService(orderRepo, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
 
 	tests := []struct {
 		name             string
@@ -179,7 +355,7 @@ func TestOrderService_GetOrder(t *testing.T) {
 	productService := &mockProductService{}
 	promoCodeService := &mockPromoCodeService{}
 
-	service := NewService(orderRepo, productService, promoCodeService)
+	service := NewService(orderRepo, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
 
 	tests := []struct {
 		name          string
@@ -226,7 +402,7 @@ func TestOrderService_GetAllOrders(t *testing.T) {
 	productService := &mockProductService{}
 	promoCodeService := &mockPromoCodeService{}
 
-	service := NewService(orderRepo, productService, promoCodeService)
+	service := NewService(orderRepo, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
 
 	orders := service.GetAllOrders()
 
@@ -245,3 +421,1232 @@ func TestOrderService_GetAllOrders(t *testing.T) {
 		t.Errorf("Not all expected orders were found in the returned list")
 	}
 }
+
+func TestOrderService_CreateOrder_AppliesCampaign(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1299},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 349},
+	}}
+	promoCodeService := &mockPromoCodeService{
+		validPromoCodes: map[string]bool{"PERCENT10": true, "FIVEOFF1": true, "FREEFRIES": true, "BURGER2X1": true, "NOCAMPAIGN": true},
+		campaigns: map[string]domain.Campaign{
+			"PERCENT10": {ID: "pct", Type: domain.CampaignTypePercentage, PercentOff: 10},
+			"FIVEOFF1":  {ID: "fixed", Type: domain.CampaignTypeFixedAmount, AmountOff: 500},
+			"FREEFRIES": {ID: "free", Type: domain.CampaignTypeFreeItem, FreeProductID: "prod2"},
+			"BURGER2X1": {ID: "bogo", Type: domain.CampaignTypeBuyXGetY, ProductID: "prod1", BuyQuantity: 1, GetQuantity: 1},
+		},
+	}
+	service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
+
+	items := []domain.OrderLineItem{{ProductID: "prod1", Quantity: 3}, {ProductID: "prod2", Quantity: 2}} // Subtotal 45.95
+
+	tests := []struct {
+		code             string
+		expectedCampaign string
+		expectedDiscount domain.Money
+	}{
+		{"PERCENT10", "pct", 460},   // 459.5 rounds half up to 460
+		{"FIVEOFF1", "fixed", 500},  // Flat amount
+		{"FREEFRIES", "free", 349},  // One unit of fries
+		{"BURGER2X1", "bogo", 1299}, // 3 burgers: one group of buy 1 get 1
+		{"NOCAMPAIGN", "", 0},       // Valid code without a campaign
+		{"INVALIDCODE", "", 0},      // Invalid code
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: tt.code, Items: items})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var campaignID string
+			if len(order.Promotions) == 1 {
+				campaignID = order.Promotions[0].CampaignID
+			}
+			if campaignID != tt.expectedCampaign {
+				t.Errorf("Expected campaign %q, got %q", tt.expectedCampaign, campaignID)
+			}
+			if order.Discount != tt.expectedDiscount {
+				t.Errorf("Expected discount %s, got %s", tt.expectedDiscount, order.Discount)
+			}
+			if len(order.Coupons) != 1 || order.Coupons[0].Applied != (tt.expectedCampaign != "") {
+				t.Errorf("Expected coupon outcome applied=%t, got %+v", tt.expectedCampaign != "", order.Coupons)
+			}
+			if order.Total != 4595 || order.FinalPrice != order.Total-order.Discount {
+				t.Errorf("Expected total 45.95 and final price %s, got total %s and final %s", 4595-tt.expectedDiscount, order.Total, order.FinalPrice)
+			}
+		})
+	}
+}
+
+func TestOrderService_CreateOrder_Redemptions(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+	}}
+	items := []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}
+	campaigns := map[string]domain.Campaign{
+		"LIMITED10": {ID: "limited", Type: domain.CampaignTypePercentage, PercentOff: 10, MaxUses: 1},
+	}
+
+	t.Run("Redemption is committed with the order", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{validPromoCodes: map[string]bool{"LIMITED10": true}, campaigns: campaigns}
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
+
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: "LIMITED10", CustomerID: "cust1", Items: items})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if order.Discount != 100 || order.CustomerID != "cust1" {
+			t.Errorf("Expected discount 1.00 for customer cust1, got %s for %q", order.Discount, order.CustomerID)
+		}
+		if len(promoCodeService.committed) != 1 || len(promoCodeService.released) != 0 {
+			t.Errorf("Expected one committed redemption, got committed=%v released=%v", promoCodeService.committed, promoCodeService.released)
+		}
+	})
+
+	t.Run("Exhausted code gives no discount", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{
+			validPromoCodes: map[string]bool{"LIMITED10": true},
+			campaigns:       campaigns,
+			reserveErr:      domain.ErrPromoCodeExhausted,
+		}
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
+
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: "LIMITED10", Items: items})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if order.Discount != 0 || len(order.Promotions) != 0 {
+			t.Errorf("Expected no discount, got %s from %+v", order.Discount, order.Promotions)
+		}
+		if len(order.Coupons) != 1 || order.Coupons[0].Applied || order.Coupons[0].Reason != domain.PromoReasonExhausted {
+			t.Errorf("Expected the coupon to be rejected as EXHAUSTED, got %+v", order.Coupons)
+		}
+		if len(promoCodeService.committed) != 0 {
+			t.Errorf("Expected no committed redemption, got %v", promoCodeService.committed)
+		}
+	})
+
+	t.Run("Reservation is released when the order cannot be saved", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{validPromoCodes: map[string]bool{"LIMITED10": true}, campaigns: campaigns}
+		repo := &mockOrderRepository{orders: make(map[string]domain.Order), createErr: errors.New("disk full")}
+		service := NewService(repo, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
+
+		if _, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: "LIMITED10", Items: items}); err == nil {
+			t.Fatalf("Expected an error when the order cannot be saved")
+		}
+		if len(promoCodeService.released) != 1 || len(promoCodeService.committed) != 0 {
+			t.Errorf("Expected the reservation to be released, got committed=%v released=%v", promoCodeService.committed, promoCodeService.released)
+		}
+	})
+}
+
+func TestOrderService_CreateOrder_InvalidCouponPolicy(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+	}}
+	items := []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}
+
+	tests := []struct {
+		policy           string
+		code             string
+		expectRejected   bool
+		expectedWarnings int
+	}{
+		{InvalidCouponReject, "BADCODE1", true, 0},
+		{InvalidCouponWarn, "BADCODE1", false, 1},
+		{InvalidCouponIgnore, "BADCODE1", false, 0},
+		{InvalidCouponReject, "NOCAMPAIGN", false, 0}, // Valid code without a campaign is not rejected
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy+"/"+tt.code, func(t *testing.T) {
+			repo := &mockOrderRepository{orders: make(map[string]domain.Order)}
+			promoCodeService := &mockPromoCodeService{validPromoCodes: map[string]bool{"NOCAMPAIGN": true}}
+			service := NewService(repo, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{InvalidCouponPolicy: tt.policy})
+
+			order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: tt.code, Items: items})
+
+			var rejected *CouponRejectedError
+			if tt.expectRejected {
+				if !errors.As(err, &rejected) || rejected.Outcome.Reason != domain.PromoReasonNotEnoughSources {
+					t.Fatalf("Expected a coupon rejection with reason NOT_ENOUGH_SOURCES, got %v", err)
+				}
+				if len(repo.orders) != 0 {
+					t.Errorf("Expected no order to be stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(order.Warnings) != tt.expectedWarnings {
+				t.Errorf("Expected %d warnings, got %v", tt.expectedWarnings, order.Warnings)
+			}
+			stored, _ := repo.GetByID(order.ID)
+			if len(stored.Coupons) != 1 || stored.Coupons[0].Code != tt.code || stored.Coupons[0].Applied {
+				t.Errorf("Expected the coupon outcome to be stored with the order, got %+v", stored.Coupons)
+			}
+		})
+	}
+}
+
+func TestOrderService_CreateOrder_StackedPromotions(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+	}}
+	items := []domain.OrderLineItem{{ProductID: "prod1", Quantity: 2}, {ProductID: "prod2", Quantity: 1}} // Subtotal 25.00
+	campaigns := map[string]domain.Campaign{
+		"FREEFRIES": {ID: "free-fries", Type: domain.CampaignTypeFreeItem, FreeProductID: "prod2", Stacking: domain.CampaignStackingStackable},
+		"FIVEOFF1":  {ID: "five-off", Type: domain.CampaignTypeFixedAmount, AmountOff: 500, Stacking: domain.CampaignStackingStackable},
+		"HALFOFF1":  {ID: "half-off", Type: domain.CampaignTypePercentage, PercentOff: 50},
+		"TENOFF12":  {ID: "ten-off", Type: domain.CampaignTypePercentage, PercentOff: 10},
+	}
+	validCodes := map[string]bool{"FREEFRIES": true, "FIVEOFF1": true, "HALFOFF1": true, "TENOFF12": true}
+	autumn := domain.Campaign{ID: "autumn", Type: domain.CampaignTypePercentage, PercentOff: 10, Automatic: true, Stacking: domain.CampaignStackingStackable}
+
+	t.Run("Stackable coupons combine with automatic promotions", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{validPromoCodes: validCodes, campaigns: campaigns, automatic: []domain.Campaign{autumn}}
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
+
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: "FREEFRIES", CouponCodes: []string{"FIVEOFF1", "FREEFRIES"}, Items: items})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// Fries free (5.00), then 5.00 off, then 10% of 15.00
+		if order.Discount != 1150 || len(order.Promotions) != 3 {
+			t.Errorf("Expected 11.50 off from three promotions, got %s from %+v", order.Discount, order.Promotions)
+		}
+		if len(order.Coupons) != 2 || !order.Coupons[0].Applied || !order.Coupons[1].Applied || order.PromoCode != "FREEFRIES" {
+			t.Errorf("Expected both coupons applied, got %+v", order.Coupons)
+		}
+		if len(promoCodeService.committed) != 2 || len(promoCodeService.released) != 0 {
+			t.Errorf("Expected two committed redemptions, got committed=%v released=%v", promoCodeService.committed, promoCodeService.released)
+		}
+	})
+
+	t.Run("Better exclusive coupon wins and the others are released", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{validPromoCodes: validCodes, campaigns: campaigns, automatic: []domain.Campaign{autumn}}
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{InvalidCouponPolicy: InvalidCouponReject})
+
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCodes: []string{"FIVEOFF1", "HALFOFF1", "TENOFF12"}, Items: items})
+		if err != nil {
+			t.Fatalf("Losing out to a better promotion must not reject the order, got %v", err)
+		}
+		if order.Discount != 1250 || len(order.Promotions) != 1 || order.Promotions[0].CampaignID != "half-off" {
+			t.Errorf("Expected 12.50 off from half-off alone, got %s from %+v", order.Discount, order.Promotions)
+		}
+		if len(order.DroppedPromotions) != 3 {
+			t.Errorf("Expected five-off, ten-off and autumn to be dropped, got %+v", order.DroppedPromotions)
+		}
+		for _, coupon := range order.Coupons {
+			if coupon.Code != "HALFOFF1" && (coupon.Applied || coupon.Reason != domain.PromotionDroppedNotCombinable || coupon.Message == "") {
+				t.Errorf("Expected %s to be explained as NOT_COMBINABLE, got %+v", coupon.Code, coupon)
+			}
+		}
+		if len(promoCodeService.committed) != 1 || promoCodeService.committed[0] != "reservation-HALFOFF1" || len(promoCodeService.released) != 2 {
+			t.Errorf("Expected only HALFOFF1 committed, got committed=%v released=%v", promoCodeService.committed, promoCodeService.released)
+		}
+	})
+
+	t.Run("Reject policy releases earlier reservations", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{validPromoCodes: validCodes, campaigns: campaigns}
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{InvalidCouponPolicy: InvalidCouponReject})
+
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CouponCodes: []string{"FIVEOFF1", "BADCODE1"}, Items: items})
+		var rejected *CouponRejectedError
+		if !errors.As(err, &rejected) || rejected.Outcome.Code != "BADCODE1" {
+			t.Fatalf("Expected BADCODE1 to be rejected, got %v", err)
+		}
+		if len(promoCodeService.released) != 1 || len(promoCodeService.committed) != 0 {
+			t.Errorf("Expected the FIVEOFF1 reservation to be released, got committed=%v released=%v", promoCodeService.committed, promoCodeService.released)
+		}
+	})
+}
+
+func TestOrderService_CreateOrder_CampaignConditions(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000, Category: "Burgers"},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500, Category: "Sides"},
+	}}
+	promoCodeService := &mockPromoCodeService{
+		validPromoCodes: map[string]bool{"BURGERS20": true},
+		campaigns: map[string]domain.Campaign{
+			"BURGERS20": {
+				ID: "burgers-20", Type: domain.CampaignTypePercentage, PercentOff: 20,
+				Conditions: &domain.CampaignConditions{MinSubtotal: 2000, ProductFilter: domain.ProductFilter{Categories: []string{"Burgers"}}},
+				Target:     &domain.ProductFilter{Categories: []string{"Burgers"}},
+			},
+		},
+	}
+
+	t.Run("Qualifying cart gets the discount on matching lines only", func(t *testing.T) {
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{})
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: "BURGERS20", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 2}, {ProductID: "prod2", Quantity: 1},
+		}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if order.Discount != 400 || order.Items[0].Discount != 400 || order.Items[1].Discount != 0 {
+			t.Errorf("Expected 4.00 off the burgers only, got %+v", order.Items)
+		}
+	})
+
+	t.Run("Cart below the minimum is not applicable and reserves nothing", func(t *testing.T) {
+		promoCodeService.committed, promoCodeService.released = nil, nil
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{InvalidCouponPolicy: InvalidCouponReject})
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: "BURGERS20", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1}, {ProductID: "prod2", Quantity: 1},
+		}})
+
+		var rejected *CouponRejectedError
+		if !errors.As(err, &rejected) || rejected.Outcome.Reason != domain.PromoReasonNotApplicable || rejected.Outcome.Message == "" {
+			t.Fatalf("Expected a NOT_APPLICABLE rejection with an explanation, got %v", err)
+		}
+		if len(promoCodeService.committed)+len(promoCodeService.released) != 0 {
+			t.Errorf("Expected no reservation for an inapplicable coupon, got committed=%v released=%v", promoCodeService.committed, promoCodeService.released)
+		}
+	})
+}
+
+// racingRepository lets another update win the race the first time Update is called.
+type racingRepository struct {
+	OrderRepository
+	raced bool
+	race  func(order domain.Order)
+}
+
+func (r *racingRepository) Update(order domain.Order, expectedVersion int) (domain.Order, error) {
+	if !r.raced {
+		r.raced = true
+		current, _ := r.GetByID(order.ID)
+		r.race(current)
+	}
+	return r.OrderRepository.Update(order, expectedVersion)
+}
+
+func TestOrderService_UpdateOrderStatus(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+	}}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	newOrder := func(t *testing.T, repo OrderRepository) (Service, domain.Order) {
+		t.Helper()
+		service := NewService(repo, productService, &mockPromoCodeService{}, pricing.NewService(pricing.Config{}), Config{Now: clock})
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}})
+		if err != nil {
+			t.Fatalf("Unexpected error creating order: %v", err)
+		}
+		return service, order
+	}
+
+	t.Run("Walks the lifecycle and records the history", func(t *testing.T) {
+		service, order := newOrder(t, NewInMemoryOrderRepository())
+		if order.Status != domain.OrderStatusPending || order.Version != 1 || !order.CreatedAt.Equal(now) || len(order.StatusHistory) != 1 {
+			t.Fatalf("Expected a pending order at version 1 with one history entry, got %+v", order)
+		}
+
+		for i, status := range []string{domain.OrderStatusConfirmed, domain.OrderStatusPreparing, domain.OrderStatusReady, domain.OrderStatusCompleted, domain.OrderStatusRefunded} {
+			now = now.Add(time.Minute)
+			updated, err := service.UpdateOrderStatus(order.ID, domain.UpdateOrderStatusRequest{Status: status, ChangedBy: "staff:ana", Version: order.Version})
+			if err != nil {
+				t.Fatalf("Moving to %s: unexpected error: %v", status, err)
+			}
+			if updated.Status != status || updated.Version != i+2 || !updated.UpdatedAt.Equal(now) || !updated.CreatedAt.Equal(order.CreatedAt) {
+				t.Errorf("Moving to %s: got status %s, version %d, updated at %s", status, updated.Status, updated.Version, updated.UpdatedAt)
+			}
+			last := updated.StatusHistory[len(updated.StatusHistory)-1]
+			if last.From != order.Status || last.To != status || last.ChangedBy != "staff:ana" || !last.ChangedAt.Equal(now) {
+				t.Errorf("Moving to %s: unexpected history entry %+v", status, last)
+			}
+			order = updated
+		}
+		if len(order.StatusHistory) != 6 {
+			t.Errorf("Expected 6 history entries, got %d", len(order.StatusHistory))
+		}
+	})
+
+	t.Run("Rejects invalid transitions, unknown statuses and stale versions", func(t *testing.T) {
+		service, order := newOrder(t, NewInMemoryOrderRepository())
+		tests := []struct {
+			req         domain.UpdateOrderStatusRequest
+			expectedErr error
+		}{
+			{domain.UpdateOrderStatusRequest{Status: domain.OrderStatusReady, ChangedBy: "staff"}, domain.ErrInvalidStatusTransition},
+			{domain.UpdateOrderStatusRequest{Status: domain.OrderStatusRefunded, ChangedBy: "staff"}, domain.ErrInvalidStatusTransition},
+			{domain.UpdateOrderStatusRequest{Status: "shipped", ChangedBy: "staff"}, domain.ErrInvalidOrderStatus},
+			{domain.UpdateOrderStatusRequest{Status: domain.OrderStatusConfirmed, ChangedBy: "staff", Version: 7}, domain.ErrOrderVersionConflict},
+		}
+		for _, tt := range tests {
+			if _, err := service.UpdateOrderStatus(order.ID, tt.req); !errors.Is(err, tt.expectedErr) {
+				t.Errorf("%+v: expected %v, got %v", tt.req, tt.expectedErr, err)
+			}
+		}
+		if _, err := service.UpdateOrderStatus("missing", domain.UpdateOrderStatusRequest{Status: domain.OrderStatusConfirmed, ChangedBy: "staff"}); !errors.Is(err, domain.ErrOrderNotFound) {
+			t.Errorf("Expected order not found, got %v", err)
+		}
+
+		cancelled, err := service.UpdateOrderStatus(order.ID, domain.UpdateOrderStatusRequest{Status: domain.OrderStatusCancelled, ChangedBy: "cust1", Reason: "changed my mind"})
+		if err != nil || cancelled.StatusHistory[1].Reason != "changed my mind" {
+			t.Fatalf("Expected the order to be cancelled with a reason, got %+v, %v", cancelled, err)
+		}
+		if _, err := service.UpdateOrderStatus(order.ID, domain.UpdateOrderStatusRequest{Status: domain.OrderStatusConfirmed, ChangedBy: "staff"}); !errors.Is(err, domain.ErrInvalidStatusTransition) {
+			t.Errorf("Expected cancelled to be final, got %v", err)
+		}
+	})
+
+	t.Run("Concurrent updates of the same version: exactly one wins", func(t *testing.T) {
+		service, order := newOrder(t, NewInMemoryOrderRepository())
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		wins, conflicts := 0, 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := service.UpdateOrderStatus(order.ID, domain.UpdateOrderStatusRequest{Status: domain.OrderStatusConfirmed, ChangedBy: "staff", Version: order.Version})
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					wins++
+				case errors.Is(err, domain.ErrOrderVersionConflict), errors.Is(err, domain.ErrInvalidStatusTransition):
+					conflicts++
+				default:
+					t.Errorf("Unexpected error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if wins != 1 || conflicts != 19 {
+			t.Errorf("Expected 1 winner and 19 conflicts, got %d and %d", wins, conflicts)
+		}
+	})
+
+	t.Run("Unpinned update is retried against the latest version", func(t *testing.T) {
+		repo := &racingRepository{OrderRepository: NewInMemoryOrderRepository()}
+		service, order := newOrder(t, repo)
+		repo.race = func(current domain.Order) {
+			current.UpdatedAt = current.UpdatedAt.Add(time.Second)
+			if _, err := repo.OrderRepository.Update(current, current.Version); err != nil {
+				t.Fatalf("Racing update failed: %v", err)
+			}
+		}
+
+		updated, err := service.UpdateOrderStatus(order.ID, domain.UpdateOrderStatusRequest{Status: domain.OrderStatusConfirmed, ChangedBy: "kitchen"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !repo.raced || updated.Version != 3 || len(updated.StatusHistory) != 2 || updated.Status != domain.OrderStatusConfirmed {
+			t.Errorf("Expected the confirmation to build on the racing update, got %+v", updated)
+		}
+	})
+}
+
+func TestOrderService_CancelAndRefund(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+	}, stock: map[string]int{"prod1": 1000}}
+	stockLeft := func() int {
+		productService.mu.Lock()
+		defer productService.mu.Unlock()
+		return productService.stock["prod1"]
+	}
+	campaigns := map[string]domain.Campaign{
+		"SAVE15OFF": {ID: "save-15", Type: domain.CampaignTypePercentage, PercentOff: 15},
+	}
+	newOrderIn := func(t *testing.T, repo OrderRepository, promoCodeService *mockPromoCodeService) (Service, domain.Order) {
+		t.Helper()
+		promoCodeService.validPromoCodes = map[string]bool{"SAVE15OFF": true}
+		promoCodeService.campaigns = campaigns
+		service := NewService(repo, productService, promoCodeService, pricing.NewService(pricing.Config{TaxRatePercent: 8.25}), Config{})
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CouponCode: "SAVE15OFF", CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 3},
+			{ProductID: "prod2", Quantity: 1},
+		}})
+		if err != nil {
+			t.Fatalf("Unexpected error creating order: %v", err)
+		}
+		return service, order
+	}
+	newOrder := func(t *testing.T, promoCodeService *mockPromoCodeService) (Service, domain.Order) {
+		t.Helper()
+		return newOrderIn(t, &mockOrderRepository{orders: make(map[string]domain.Order)}, promoCodeService)
+	}
+	complete := func(t *testing.T, service Service, orderID string) {
+		t.Helper()
+		for _, status := range []string{domain.OrderStatusConfirmed, domain.OrderStatusPreparing, domain.OrderStatusReady, domain.OrderStatusCompleted} {
+			if _, err := service.UpdateOrderStatus(orderID, domain.UpdateOrderStatusRequest{Status: status, ChangedBy: "staff"}); err != nil {
+				t.Fatalf("Moving to %s: unexpected error: %v", status, err)
+			}
+		}
+	}
+
+	t.Run("Cancelling gives back the promo code redemptions", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{}
+		service, order := newOrder(t, promoCodeService)
+
+		cancelled, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "cust1", Reason: "ordered twice", Version: order.Version})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// Version 2 is the cancellation, version 3 records its returns as done.
+		if cancelled.Status != domain.OrderStatusCancelled || cancelled.Version != 3 || cancelled.StatusHistory[1].Reason != "ordered twice" || len(cancelled.PendingReturns) != 0 {
+			t.Errorf("Expected a settled cancelled order at version 3, got %+v", cancelled)
+		}
+		if len(promoCodeService.revoked) != 1 || promoCodeService.revoked[0] != order.ID {
+			t.Errorf("Expected the order's redemptions to be revoked, got %v", promoCodeService.revoked)
+		}
+		if _, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "cust1"}); !errors.Is(err, domain.ErrInvalidStatusTransition) {
+			t.Errorf("Expected a second cancellation to fail, got %v", err)
+		}
+	})
+
+	t.Run("Failing to give back redemptions still cancels the order and retries later", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{revokeErr: errors.New("store unavailable")}
+		service, order := newOrder(t, promoCodeService)
+		before := stockLeft()
+
+		if _, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "cust1"}); !errors.Is(err, domain.ErrOrderReturnsPending) {
+			t.Fatalf("Expected %v, got %v", domain.ErrOrderReturnsPending, err)
+		}
+		cancelled, _ := service.GetOrder(order.ID)
+		if cancelled.Status != domain.OrderStatusCancelled || len(promoCodeService.revoked) != 0 {
+			t.Errorf("Expected a cancelled order without revoked redemptions, got %s, %v", cancelled.Status, promoCodeService.revoked)
+		}
+		if !slices.Equal(cancelled.PendingReturns, []string{domain.OrderReturnRedemptions}) || stockLeft() != before+3 {
+			t.Errorf("Expected the stock back and the redemptions pending, got %v and stock %d (was %d)", cancelled.PendingReturns, stockLeft(), before)
+		}
+
+		if settled, err := service.RetryPendingReturns(); settled != 0 || !errors.Is(err, domain.ErrOrderReturnsPending) {
+			t.Errorf("Expected the retry to fail again, got %d settled, %v", settled, err)
+		}
+		promoCodeService.revokeErr = nil
+		if settled, err := service.RetryPendingReturns(); settled != 1 || err != nil {
+			t.Fatalf("Expected the order to be settled, got %d, %v", settled, err)
+		}
+		settled, _ := service.GetOrder(order.ID)
+		if len(settled.PendingReturns) != 0 || len(promoCodeService.revoked) != 1 || stockLeft() != before+3 {
+			t.Errorf("Expected the redemptions given back once and the stock not again, got %v, revoked %v, stock %d", settled.PendingReturns, promoCodeService.revoked, stockLeft())
+		}
+		if n, err := service.RetryPendingReturns(); n != 0 || err != nil {
+			t.Errorf("Expected nothing left to retry, got %d, %v", n, err)
+		}
+	})
+
+	t.Run("A failed commit gives nothing back", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{}
+		repo := &commitFailingRepository{mockOrderRepository: &mockOrderRepository{orders: make(map[string]domain.Order)}}
+		service, order := newOrderIn(t, repo, promoCodeService)
+		before := stockLeft()
+
+		repo.failCommit = true
+		if _, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "cust1"}); err == nil || errors.Is(err, domain.ErrInvalidStatusTransition) {
+			t.Errorf("Expected the cancellation to fail on commit, got %v", err)
+		}
+		repo.failCommit = false
+		complete(t, service, order.ID)
+		repo.failCommit = true
+		if _, err := service.RefundOrder(order.ID, domain.RefundOrderRequest{RefundedBy: "staff"}); err == nil || errors.Is(err, domain.ErrInvalidStatusTransition) {
+			t.Errorf("Expected the refund to fail on commit, got %v", err)
+		}
+		if len(promoCodeService.revoked) != 0 || stockLeft() != before {
+			t.Errorf("Expected no redemptions or stock to be given back, got revoked %v and stock %d (was %d)", promoCodeService.revoked, stockLeft(), before)
+		}
+		current, _ := service.GetOrder(order.ID)
+		if current.Status != domain.OrderStatusCompleted || len(current.Refunds) != 0 {
+			t.Errorf("Expected the order to be unchanged, got %+v", current)
+		}
+
+		repo.failCommit = false
+		if _, err := service.RefundOrder(order.ID, domain.RefundOrderRequest{RefundedBy: "staff"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(promoCodeService.revoked) != 1 {
+			t.Errorf("Expected the redemptions to be revoked once the refund is stored, got %v", promoCodeService.revoked)
+		}
+	})
+
+	t.Run("Partial refunds add up to the final price", func(t *testing.T) {
+		promoCodeService := &mockPromoCodeService{}
+		service, order := newOrder(t, promoCodeService)
+		if order.FinalPrice != 3220 || order.Tax != 245 {
+			t.Fatalf("Expected final price 32.20 with tax 2.45, got %s and %s", order.FinalPrice, order.Tax)
+		}
+
+		if _, err := service.RefundOrder(order.ID, domain.RefundOrderRequest{RefundedBy: "staff"}); !errors.Is(err, domain.ErrInvalidStatusTransition) {
+			t.Errorf("Expected refunding a pending order to fail, got %v", err)
+		}
+		complete(t, service, order.ID)
+
+		if _, err := service.RefundOrder(order.ID, domain.RefundOrderRequest{RefundedBy: "staff", Items: []domain.RefundItem{{ProductID: "prod1", Quantity: 4}}}); !errors.Is(err, domain.ErrInvalidRefund) {
+			t.Errorf("Expected refunding more than was ordered to fail, got %v", err)
+		}
+
+		refunds := [][]domain.RefundItem{
+			{{ProductID: "prod1", Quantity: 1}},
+			{{ProductID: "prod1", Quantity: 1}, {ProductID: "prod2", Quantity: 1}},
+			nil, // Whatever is left
+		}
+		var refunded domain.Order
+		for i, items := range refunds {
+			var err error
+			refunded, err = service.RefundOrder(order.ID, domain.RefundOrderRequest{RefundedBy: "staff", Reason: "cold food", Items: items})
+			if err != nil {
+				t.Fatalf("Refund %d: unexpected error: %v", i+1, err)
+			}
+		}
+		if len(refunded.Refunds) != 3 || refunded.Refunds[0].Amount != 850 || refunded.Refunds[0].Tax != 70 {
+			t.Errorf("Expected the first refund to be 8.50 plus 0.70 tax, got %+v", refunded.Refunds)
+		}
+		var total domain.Money
+		for _, refund := range refunded.Refunds {
+			total += refund.Total
+		}
+		if total != order.FinalPrice || refunded.RefundedTotal != order.FinalPrice {
+			t.Errorf("Expected refunds to add up to %s, got %s (refunded total %s)", order.FinalPrice, total, refunded.RefundedTotal)
+		}
+		if refunded.Status != domain.OrderStatusRefunded || len(promoCodeService.revoked) != 1 {
+			t.Errorf("Expected the fully refunded order to be refunded with redemptions revoked, got %s, %v", refunded.Status, promoCodeService.revoked)
+		}
+		if _, err := service.RefundOrder(order.ID, domain.RefundOrderRequest{RefundedBy: "staff"}); !errors.Is(err, domain.ErrInvalidStatusTransition) {
+			t.Errorf("Expected refunding a refunded order to fail, got %v", err)
+		}
+	})
+}
+
+func TestOrderService_ListOrders(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+	}}
+	promoCodeService := &mockPromoCodeService{
+		validPromoCodes: map[string]bool{"SAVE15OFF": true},
+		campaigns:       map[string]domain.Campaign{"SAVE15OFF": {ID: "save-15", Type: domain.CampaignTypePercentage, PercentOff: 15}},
+	}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	service := NewService(NewInMemoryOrderRepository(), productService, promoCodeService, pricing.NewService(pricing.Config{}), Config{Now: func() time.Time { return now }})
+
+	// Seven orders a minute apart: odd ones have fries, every third uses the coupon, two share a price.
+	var created []domain.Order
+	for i := 0; i < 7; i++ {
+		req := domain.CreateOrderRequest{Items: []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1 + i%3}}}
+		if i%2 == 1 {
+			req.Items = append(req.Items, domain.OrderLineItem{ProductID: "prod2", Quantity: 1})
+		}
+		if i%3 == 0 {
+			req.CouponCode = "SAVE15OFF"
+		}
+		order, err := service.CreateOrder(req)
+		if err != nil {
+			t.Fatalf("Unexpected error creating order: %v", err)
+		}
+		created = append(created, order)
+		now = now.Add(time.Minute)
+	}
+	if _, err := service.UpdateOrderStatus(created[2].ID, domain.UpdateOrderStatusRequest{Status: domain.OrderStatusConfirmed, ChangedBy: "staff"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ids := func(orders []domain.Order) []string {
+		var result []string
+		for _, o := range orders {
+			result = append(result, o.ID)
+		}
+		return result
+	}
+	walk := func(t *testing.T, req domain.ListOrdersRequest) []domain.Order {
+		t.Helper()
+		var all []domain.Order
+		for pages := 0; ; pages++ {
+			page, err := service.ListOrders(req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(page.Orders) > req.Limit {
+				t.Fatalf("Expected at most %d orders per page, got %d", req.Limit, len(page.Orders))
+			}
+			all = append(all, page.Orders...)
+			if page.NextCursor == "" {
+				return all
+			}
+			if pages > 10 {
+				t.Fatal("Pagination does not terminate")
+			}
+			req.Cursor = page.NextCursor
+		}
+	}
+
+	t.Run("Pages through every order newest first", func(t *testing.T) {
+		got := ids(walk(t, domain.ListOrdersRequest{Limit: 3}))
+		var expected []string
+		for i := len(created) - 1; i >= 0; i-- {
+			expected = append(expected, created[i].ID)
+		}
+		if !slices.Equal(got, expected) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("Sorts by price with ties broken by ID", func(t *testing.T) {
+		got := walk(t, domain.ListOrdersRequest{Sort: domain.OrderSortPriceHighest, Limit: 2})
+		if len(got) != len(created) {
+			t.Fatalf("Expected %d orders, got %d", len(created), len(got))
+		}
+		for i := 1; i < len(got); i++ {
+			prev, cur := got[i-1], got[i]
+			if prev.FinalPrice < cur.FinalPrice || (prev.FinalPrice == cur.FinalPrice && prev.ID < cur.ID) {
+				t.Errorf("Orders %d and %d are out of order: %s/%s then %s/%s", i-1, i, prev.FinalPrice, prev.ID, cur.FinalPrice, cur.ID)
+			}
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			req      domain.ListOrdersRequest
+			expected []int // Indexes into created, in the default newest-first order
+		}{
+			{"status", domain.ListOrdersRequest{Statuses: []string{domain.OrderStatusConfirmed}}, []int{2}},
+			{"created range", domain.ListOrdersRequest{CreatedFrom: created[2].CreatedAt, CreatedTo: created[5].CreatedAt}, []int{4, 3, 2}},
+			{"coupon code", domain.ListOrdersRequest{CouponCode: "SAVE15OFF"}, []int{6, 3, 0}},
+			{"product", domain.ListOrdersRequest{ProductID: "prod2", Sort: domain.OrderSortOldest}, []int{1, 3, 5}},
+			{"combined", domain.ListOrdersRequest{ProductID: "prod2", CouponCode: "SAVE15OFF"}, []int{3}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.req.Limit = 2
+				var expected []string
+				for _, i := range tt.expected {
+					expected = append(expected, created[i].ID)
+				}
+				if got := ids(walk(t, tt.req)); !slices.Equal(got, expected) {
+					t.Errorf("Expected %v, got %v", expected, got)
+				}
+			})
+		}
+	})
+
+	t.Run("Rejects invalid queries", func(t *testing.T) {
+		page, err := service.ListOrders(domain.ListOrdersRequest{Limit: 1})
+		if err != nil || page.NextCursor == "" {
+			t.Fatalf("Expected a first page with a cursor, got %v", err)
+		}
+		for _, req := range []domain.ListOrdersRequest{
+			{Sort: "name"},
+			{Statuses: []string{"shipped"}},
+			{Limit: maxOrderPageSize + 1},
+			{Cursor: "not-a-cursor"},
+			{Cursor: page.NextCursor, Sort: domain.OrderSortPriceLowest},
+		} {
+			if _, err := service.ListOrders(req); !errors.Is(err, domain.ErrInvalidOrderQuery) {
+				t.Errorf("%+v: expected an invalid query, got %v", req, err)
+			}
+		}
+	})
+}
+
+func TestOrderService_QuoteOrder(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+	}}
+	newService := func(policy string) (Service, *mockPromoCodeService, *mockOrderRepository) {
+		promoCodeService := &mockPromoCodeService{
+			validPromoCodes: map[string]bool{"SAVE15OFF": true, "FRIES500": true},
+			campaigns: map[string]domain.Campaign{
+				"SAVE15OFF": {ID: "save-15", Type: domain.CampaignTypePercentage, PercentOff: 15},
+				"FRIES500":  {ID: "fries-off", Type: domain.CampaignTypeFixedAmount, AmountOff: 500},
+			},
+			automatic: []domain.Campaign{{ID: "autumn", Type: domain.CampaignTypePercentage, PercentOff: 5, Stacking: domain.CampaignStackingStackable}},
+		}
+		repo := &mockOrderRepository{orders: make(map[string]domain.Order)}
+		service := NewService(repo, productService, promoCodeService, pricing.NewService(pricing.Config{TaxRatePercent: 10}), Config{InvalidCouponPolicy: policy})
+		return service, promoCodeService, repo
+	}
+	req := domain.CreateOrderRequest{
+		CustomerID:  "cust1",
+		CouponCodes: []string{"SAVE15OFF", "FRIES500", "UNKNOWN1"},
+		Items:       []domain.OrderLineItem{{ProductID: "prod1", Quantity: 2}, {ProductID: "prod2", Quantity: 1}},
+	}
+
+	t.Run("Quote matches the order it would create and holds nothing", func(t *testing.T) {
+		service, promoCodeService, repo := newService(InvalidCouponWarn)
+		quote, err := service.QuoteOrder(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(repo.orders) != 0 || len(promoCodeService.reserved) != 0 {
+			t.Fatalf("Expected a quote to save nothing and reserve nothing, got %d orders and reservations %v", len(repo.orders), promoCodeService.reserved)
+		}
+
+		order, err := service.CreateOrder(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if quote.Total != order.Total || quote.Discount != order.Discount || quote.Tax != order.Tax || quote.FinalPrice != order.FinalPrice {
+			t.Errorf("Expected the quote %s/%s/%s/%s to match the order %s/%s/%s/%s", quote.Total, quote.Discount, quote.Tax, quote.FinalPrice, order.Total, order.Discount, order.Tax, order.FinalPrice)
+		}
+		if !reflect.DeepEqual(quote.Items, order.Items) || !slices.Equal(quote.Coupons, order.Coupons) || !slices.Equal(quote.Warnings, order.Warnings) {
+			t.Errorf("Expected the same breakdown, got quote %+v and order %+v", quote, order)
+		}
+		if !slices.Equal(quote.Promotions, order.Promotions) || !slices.Equal(quote.DroppedPromotions, order.DroppedPromotions) {
+			t.Errorf("Expected the same promotions, got %+v/%+v and %+v/%+v", quote.Promotions, quote.DroppedPromotions, order.Promotions, order.DroppedPromotions)
+		}
+		if len(quote.Warnings) != 2 || quote.Discount != 500 || quote.FinalPrice != 2200 {
+			t.Errorf("Expected 5.00 off, 22.00 to pay and warnings about the unknown and the losing coupon, got %+v", quote)
+		}
+	})
+
+	t.Run("Quote rejects unusable coupons like the create path", func(t *testing.T) {
+		service, _, _ := newService(InvalidCouponReject)
+		var rejected *CouponRejectedError
+		if _, err := service.QuoteOrder(req); !errors.As(err, &rejected) || rejected.Outcome.Code != "UNKNOWN1" {
+			t.Errorf("Expected the unknown coupon to be rejected, got %v", err)
+		}
+		if _, err := service.QuoteOrder(domain.CreateOrderRequest{Items: []domain.OrderLineItem{{ProductID: "missing", Quantity: 1}}}); !errors.Is(err, domain.ErrProductNotFound) {
+			t.Errorf("Expected product not found, got %v", err)
+		}
+	})
+}
+
+func TestOrderService_CartPolicy(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+		"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+		"prod3": {ID: "prod3", Name: "Cola", Price: 300},
+		"prod4": {ID: "prod4", Name: "Catering tray", Price: 200000},
+	}}
+	newService := func(policy CartPolicy) (Service, *mockOrderRepository) {
+		repo := &mockOrderRepository{orders: make(map[string]domain.Order)}
+		service := NewService(repo, productService, &mockPromoCodeService{}, pricing.NewService(pricing.Config{}), Config{Cart: policy})
+		return service, repo
+	}
+	fieldsOf := func(err error) []string {
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			t.Fatalf("Expected a ValidationError, got %v", err)
+		}
+		fields := make([]string, len(invalid.Errors))
+		for i, fe := range invalid.Errors {
+			fields[i] = fe.Field
+		}
+		return fields
+	}
+
+	t.Run("Merges lines for the same product", func(t *testing.T) {
+		service, _ := newService(CartPolicy{MergeDuplicateLines: true, MaxQuantityPerLine: 5})
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 2},
+			{ProductID: "prod2", Quantity: 1},
+			{ProductID: "prod1", Quantity: 3},
+		}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(order.Items) != 2 || order.Items[0].ProductID != "prod1" || order.Items[0].Quantity != 5 || order.Items[1].ProductID != "prod2" {
+			t.Errorf("Expected the burgers merged into the first line, got %+v", order.Items)
+		}
+		if len(order.Products) != 2 || order.Total != 5500 {
+			t.Errorf("Expected two products totalling 5500, got %d products totalling %s", len(order.Products), order.Total)
+		}
+
+		service, _ = newService(CartPolicy{})
+		order, err = service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 2},
+			{ProductID: "prod1", Quantity: 3},
+		}})
+		if err != nil || len(order.Items) != 2 {
+			t.Errorf("Expected the lines to be kept apart without merging, got %+v (%v)", order.Items, err)
+		}
+	})
+
+	t.Run("Reports every invalid item by index", func(t *testing.T) {
+		service, repo := newService(CartPolicy{MergeDuplicateLines: true})
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1},
+			{ProductID: "", Quantity: 1},
+			{ProductID: "prod9", Quantity: 0},
+		}})
+		expected := []string{"items[1].product_id", "items[2].product_id", "items[2].quantity"}
+		if fields := fieldsOf(err); !slices.Equal(fields, expected) {
+			t.Errorf("Expected field errors %v, got %v", expected, fields)
+		}
+		if !errors.Is(err, domain.ErrInvalidOrderItems) || !errors.Is(err, domain.ErrProductNotFound) || !errors.Is(err, domain.ErrInvalidQuantity) {
+			t.Errorf("Expected the error to match its causes, got %v", err)
+		}
+		if len(repo.orders) != 0 {
+			t.Error("Expected no order to be saved")
+		}
+
+		_, err = service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1"})
+		if fields := fieldsOf(err); !slices.Equal(fields, []string{"items"}) {
+			t.Errorf("Expected an empty order to be rejected on items, got %v", fields)
+		}
+	})
+
+	t.Run("Caps the merged quantity per product", func(t *testing.T) {
+		service, _ := newService(CartPolicy{MergeDuplicateLines: true, MaxQuantityPerLine: 5})
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod2", Quantity: 1},
+			{ProductID: "prod1", Quantity: 4},
+			{ProductID: "prod1", Quantity: 2},
+		}})
+		if fields := fieldsOf(err); !slices.Equal(fields, []string{"items[1].quantity"}) {
+			t.Errorf("Expected the first burger line to be reported, got %v", fields)
+		}
+		if !errors.Is(err, domain.ErrInvalidQuantity) {
+			t.Errorf("Expected an invalid quantity, got %v", err)
+		}
+	})
+
+	t.Run("Caps quantities and totals without a policy", func(t *testing.T) {
+		service, _ := newService(CartPolicy{MergeDuplicateLines: true})
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1 << 62},
+			{ProductID: "prod1", Quantity: 1 << 62},
+		}})
+		if fields := fieldsOf(err); !slices.Equal(fields, []string{"items[0].quantity", "items[1].quantity"}) {
+			t.Errorf("Expected both huge quantities to be reported, got %v", fields)
+		}
+
+		_, err = service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: pricing.MaxLineQuantity},
+			{ProductID: "prod1", Quantity: 1},
+		}})
+		if fields := fieldsOf(err); !slices.Equal(fields, []string{"items[0].quantity"}) {
+			t.Errorf("Expected the merged quantity to be capped, got %v", fields)
+		}
+
+		_, err = service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod4", Quantity: pricing.MaxLineQuantity},
+		}})
+		if fields := fieldsOf(err); !slices.Equal(fields, []string{"items"}) || !errors.Is(err, domain.ErrOrderTotalTooLarge) {
+			t.Errorf("Expected the order total to be too large, got %v", err)
+		}
+
+		if _, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: pricing.MaxLineQuantity},
+		}}); err != nil {
+			t.Errorf("Expected the largest line to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("Caps the number of different products", func(t *testing.T) {
+		service, _ := newService(CartPolicy{MergeDuplicateLines: true, MaxDistinctItems: 2})
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1},
+			{ProductID: "prod1", Quantity: 1},
+			{ProductID: "prod2", Quantity: 1},
+			{ProductID: "prod3", Quantity: 1},
+		}})
+		if fields := fieldsOf(err); !slices.Equal(fields, []string{"items[3]"}) {
+			t.Errorf("Expected the first product over the limit to be reported, got %v", fields)
+		}
+
+		_, err = service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1},
+			{ProductID: "prod1", Quantity: 1},
+			{ProductID: "prod2", Quantity: 1},
+		}})
+		if err != nil {
+			t.Errorf("Expected repeated lines to count once, got %v", err)
+		}
+	})
+}
+
+func TestOrderService_CreateOrder_Modifiers(t *testing.T) {
+	toppings := domain.ModifierGroup{ID: "toppings", Name: "Toppings", MaxSelections: 2, Options: []domain.ModifierOption{
+		{ID: "no-onions", Name: "No onions"},
+		{ID: "cheese", Name: "Extra cheese", PriceDelta: 100},
+		{ID: "bacon", Name: "Bacon", PriceDelta: 150},
+	}}
+	size := domain.ModifierGroup{ID: "size", Name: "Size", Required: true, MaxSelections: 1, Options: []domain.ModifierOption{
+		{ID: "regular", Name: "Regular"},
+		{ID: "large", Name: "Large", PriceDelta: 100},
+	}}
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000, ModifierGroups: []domain.ModifierGroup{toppings}},
+		"prod2": {ID: "prod2", Name: "Cola", Price: 250, ModifierGroups: []domain.ModifierGroup{size}},
+	}}
+	service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, &mockPromoCodeService{}, pricing.NewService(pricing.Config{}), Config{Cart: CartPolicy{MergeDuplicateLines: true}})
+	cheese := domain.SelectedModifier{GroupID: "toppings", OptionID: "cheese"}
+	noOnions := domain.SelectedModifier{GroupID: "toppings", OptionID: "no-onions"}
+	large := domain.SelectedModifier{GroupID: "size", OptionID: "large"}
+
+	t.Run("Prices modifiers and merges only identical choices", func(t *testing.T) {
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1, Modifiers: []domain.SelectedModifier{cheese, noOnions}},
+			{ProductID: "prod1", Quantity: 1},
+			{ProductID: "prod1", Quantity: 2, Modifiers: []domain.SelectedModifier{noOnions, {GroupID: "toppings", OptionID: "cheese", PriceDelta: -900}}},
+			{ProductID: "prod2", Quantity: 1, Modifiers: []domain.SelectedModifier{large}},
+		}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(order.Items) != 3 || order.Items[0].Quantity != 3 || order.Items[1].Quantity != 1 {
+			t.Fatalf("Expected the lines with the same toppings merged, got %+v", order.Items)
+		}
+		burger := order.Items[0]
+		if burger.UnitPrice != 1100 || burger.Subtotal != 3300 {
+			t.Errorf("Expected the product's price delta to be used, got unit price %s and subtotal %s", burger.UnitPrice, burger.Subtotal)
+		}
+		if len(burger.Modifiers) != 2 || burger.Modifiers[0].OptionID != "no-onions" || burger.Modifiers[1].Name != "Extra cheese" {
+			t.Errorf("Expected the modifiers in the product's order with their names, got %+v", burger.Modifiers)
+		}
+		if order.Items[1].UnitPrice != 1000 || order.Items[2].UnitPrice != 350 || order.Total != 4650 {
+			t.Errorf("Unexpected pricing %+v totalling %s", order.Items, order.Total)
+		}
+	})
+
+	t.Run("Validates choices against the product", func(t *testing.T) {
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1, Modifiers: []domain.SelectedModifier{cheese, {GroupID: "sauces", OptionID: "bbq"}, {GroupID: "toppings", OptionID: "ham"}, cheese}},
+			{ProductID: "prod1", Quantity: 1, Modifiers: []domain.SelectedModifier{cheese, noOnions, {GroupID: "toppings", OptionID: "bacon"}}},
+			{ProductID: "prod2", Quantity: 1},
+		}})
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || !errors.Is(err, domain.ErrInvalidModifiers) {
+			t.Fatalf("Expected invalid modifiers, got %v", err)
+		}
+		expected := []string{
+			"items[0].modifiers[1].group_id",
+			"items[0].modifiers[2].option_id",
+			"items[0].modifiers[3]",
+			"items[1].modifiers",
+			"items[2].modifiers",
+		}
+		var fields []string
+		for _, fe := range invalid.Errors {
+			fields = append(fields, fe.Field)
+		}
+		if !slices.Equal(fields, expected) {
+			t.Errorf("Expected field errors %v, got %+v", expected, invalid.Errors)
+		}
+	})
+}
+
+func TestOrderService_Stock(t *testing.T) {
+	newService := func() (Service, *mockProductService) {
+		productService := &mockProductService{
+			products: map[string]domain.Product{
+				"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+				"prod2": {ID: "prod2", Name: "Fries", Price: 500},
+				"prod3": {ID: "prod3", Name: "Cola", Price: 250},
+			},
+			soldOut: map[string]bool{"prod2": true},
+			stock:   map[string]int{"prod1": 3},
+		}
+		service := NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, &mockPromoCodeService{}, pricing.NewService(pricing.Config{}), Config{})
+		return service, productService
+	}
+
+	t.Run("Takes stock on create and gives it back on cancel", func(t *testing.T) {
+		service, productService := newService()
+		order, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod1", Quantity: 1},
+			{ProductID: "prod3", Quantity: 4},
+			{ProductID: "prod1", Quantity: 2},
+		}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if productService.stock["prod1"] != 0 {
+			t.Errorf("Expected every burger to be taken, %d left", productService.stock["prod1"])
+		}
+
+		_, err = service.QuoteOrder(domain.CreateOrderRequest{CustomerID: "cust2", Items: []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}})
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || !invalid.onlyCause(domain.ErrProductUnavailable) || invalid.Errors[0].Field != "items[0].quantity" {
+			t.Fatalf("Expected the burgers to be gone, got %v", err)
+		}
+
+		if _, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "cust1"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if productService.stock["prod1"] != 3 {
+			t.Errorf("Expected the cancelled burgers back in stock, got %d", productService.stock["prod1"])
+		}
+		if _, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "cust1"}); err == nil || productService.stock["prod1"] != 3 {
+			t.Errorf("Expected a second cancellation to fail without releasing again, got %v and %d burgers", err, productService.stock["prod1"])
+		}
+	})
+
+	t.Run("Rejects sold out products and orders beyond the stock", func(t *testing.T) {
+		service, productService := newService()
+		_, err := service.CreateOrder(domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{
+			{ProductID: "prod3", Quantity: 1},
+			{ProductID: "prod1", Quantity: 2},
+			{ProductID: "prod2", Quantity: 1},
+			{ProductID: "prod1", Quantity: 2},
+		}})
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || !errors.Is(err, domain.ErrProductUnavailable) {
+			t.Fatalf("Expected unavailable products, got %v", err)
+		}
+		expected := []domain.FieldError{
+			{Field: "items[1].quantity", Message: "only 3 of 'Burger' left"},
+			{Field: "items[2].product_id", Message: "'Fries' is sold out"},
+		}
+		if !slices.Equal(invalid.Errors, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, invalid.Errors)
+		}
+		if productService.stock["prod1"] != 3 {
+			t.Errorf("Expected a rejected order to take nothing, got %d burgers left", productService.stock["prod1"])
+		}
+	})
+}
+
+func TestOrderService_Events(t *testing.T) {
+	productService := &mockProductService{products: map[string]domain.Product{
+		"prod1": {ID: "prod1", Name: "Burger", Price: 1000},
+	}}
+	newService := func(replaySize int) Service {
+		return NewService(&mockOrderRepository{orders: make(map[string]domain.Order)}, productService, &mockPromoCodeService{}, pricing.NewService(pricing.Config{}), Config{EventReplaySize: replaySize})
+	}
+	req := domain.CreateOrderRequest{CustomerID: "cust1", Items: []domain.OrderLineItem{{ProductID: "prod1", Quantity: 1}}}
+	next := func(sub *Subscription) domain.OrderEvent {
+		t.Helper()
+		select {
+		case event := <-sub.Events:
+			return event
+		default:
+			t.Fatal("Expected an event")
+			return domain.OrderEvent{}
+		}
+	}
+
+	t.Run("Publishes creation and status changes", func(t *testing.T) {
+		service := newService(0)
+		all, _ := service.SubscribeToEvents("", 0)
+		defer all.Close()
+
+		order, err := service.CreateOrder(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		one, err := service.SubscribeToEvents(order.ID, 0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer one.Close()
+		other, _ := service.CreateOrder(req)
+		if _, err := service.UpdateOrderStatus(order.ID, domain.UpdateOrderStatusRequest{Status: domain.OrderStatusConfirmed, ChangedBy: "staff"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := service.CancelOrder(order.ID, domain.CancelOrderRequest{ChangedBy: "staff", Reason: "out of buns"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		expected := []struct {
+			id      uint64
+			typ     string
+			orderID string
+			status  string
+		}{
+			{1, domain.OrderEventCreated, order.ID, domain.OrderStatusPending},
+			{2, domain.OrderEventCreated, other.ID, domain.OrderStatusPending},
+			{3, domain.OrderEventStatusChanged, order.ID, domain.OrderStatusConfirmed},
+			{4, domain.OrderEventStatusChanged, order.ID, domain.OrderStatusCancelled},
+		}
+		for _, e := range expected {
+			event := next(all)
+			if event.ID != e.id || event.Type != e.typ || event.OrderID != e.orderID || event.Order.Status != e.status {
+				t.Errorf("Expected event %d %s for %s (%s), got %d %s for %s (%s)", e.id, e.typ, e.orderID, e.status, event.ID, event.Type, event.OrderID, event.Order.Status)
+			}
+		}
+		if event := next(one); event.ID != 3 || event.Change == nil || event.Change.From != domain.OrderStatusPending || event.Change.ChangedBy != "staff" {
+			t.Errorf("Expected the confirmation of the order, got %+v", event)
+		}
+		if event := next(one); event.ID != 4 || event.Change.Reason != "out of buns" {
+			t.Errorf("Expected the cancellation of the order, got %+v", event)
+		}
+		if len(one.Events) != 0 {
+			t.Error("Expected the order's stream to leave out other orders")
+		}
+
+		if _, err := service.SubscribeToEvents("missing", 0); !errors.Is(err, domain.ErrOrderNotFound) {
+			t.Errorf("Expected order not found, got %v", err)
+		}
+	})
+
+	t.Run("Replays buffered events after the last event ID", func(t *testing.T) {
+		service := newService(3)
+		var ids []string
+		for i := 0; i < 5; i++ {
+			order, err := service.CreateOrder(req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids = append(ids, order.ID)
+		}
+
+		tests := []struct {
+			name        string
+			orderID     string
+			lastEventID uint64
+			replayed    []uint64
+			missed      bool
+		}{
+			{"up to date", "", 5, nil, false},
+			{"still buffered", "", 3, []uint64{4, 5}, false},
+			{"oldest buffered is next", "", 2, []uint64{3, 4, 5}, false},
+			{"older than the buffer", "", 1, []uint64{3, 4, 5}, true},
+			{"from an earlier run", "", 9, []uint64{3, 4, 5}, true},
+			{"one order", ids[3], 2, []uint64{4}, false},
+			{"new connection", "", 0, nil, false},
+		}
+		for _, tt := range tests {
+			sub, err := service.SubscribeToEvents(tt.orderID, tt.lastEventID)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+			var replayed []uint64
+			for _, event := range sub.Replay {
+				replayed = append(replayed, event.ID)
+			}
+			if !slices.Equal(replayed, tt.replayed) || sub.Missed != tt.missed {
+				t.Errorf("%s: expected replay %v (missed %t), got %v (missed %t)", tt.name, tt.replayed, tt.missed, replayed, sub.Missed)
+			}
+			sub.Close()
+		}
+	})
+
+	t.Run("A bus without a replay size keeps the default number of events", func(t *testing.T) {
+		bus := NewEventBus(0)
+		for i := 0; i < 3; i++ {
+			bus.Publish(domain.OrderEvent{Type: domain.OrderEventCreated, OrderID: "order"})
+		}
+		resumed := bus.Subscribe("", 1)
+		defer resumed.Close()
+		if bus.replaySize != defaultEventReplaySize || len(resumed.Replay) != 2 || resumed.Missed {
+			t.Errorf("Expected events 2 and 3 from a buffer of %d, got %+v from %d", defaultEventReplaySize, resumed.Replay, bus.replaySize)
+		}
+	})
+
+	t.Run("Drops subscribers that fall behind", func(t *testing.T) {
+		bus := NewEventBus(10)
+		slow := bus.Subscribe("", 0)
+		for i := 0; i <= subscriberBuffer; i++ {
+			bus.Publish(domain.OrderEvent{Type: domain.OrderEventCreated, OrderID: "order"})
+		}
+		received := 0
+		for range slow.Events {
+			received++
+		}
+		if received != subscriberBuffer {
+			t.Errorf("Expected %d events before the subscription was closed, got %d", subscriberBuffer, received)
+		}
+		slow.Close() // Closing a dropped subscription is harmless
+
+		resumed := bus.Subscribe("", uint64(received))
+		defer resumed.Close()
+		if len(resumed.Replay) != 1 || resumed.Replay[0].ID != subscriberBuffer+1 || resumed.Missed {
+			t.Errorf("Expected the dropped event to be replayed, got %+v", resumed.Replay)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package orders
+
+import (
+	"fmt"
+	"kart-challenge/internal/domain"
+)
+
+// statusTransitions lists the statuses an order may move to from each status. Cancelled and refunded
+// orders are final.
+var statusTransitions = map[string][]string{
+	domain.OrderStatusPending:   {domain.OrderStatusConfirmed, domain.OrderStatusCancelled},
+	domain.OrderStatusConfirmed: {domain.OrderStatusPreparing, domain.OrderStatusCancelled},
+	domain.OrderStatusPreparing: {domain.OrderStatusReady, domain.OrderStatusCancelled},
+	domain.OrderStatusReady:     {domain.OrderStatusCompleted},
+	domain.OrderStatusCompleted: {domain.OrderStatusRefunded},
+	domain.OrderStatusCancelled: nil,
+	domain.OrderStatusRefunded:  nil,
+}
+
+// checkTransition returns an error unless an order in status from may move to status to.
+func checkTransition(from, to string) error {
+	if _, known := statusTransitions[to]; !known {
+		return fmt.Errorf("%w: '%s'", domain.ErrInvalidOrderStatus, to)
+	}
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", domain.ErrInvalidStatusTransition, from, to)
+}
+
+// endsOrderStream reports whether an order in status is done with, so its event stream can end:
+// cancelled and refunded orders never change again and completed ones have been served.
+func endsOrderStream(status string) bool {
+	switch status {
+	case domain.OrderStatusCompleted, domain.OrderStatusCancelled, domain.OrderStatusRefunded:
+		return true
+	}
+	return false
+}
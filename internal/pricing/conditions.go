@@ -0,0 +1,80 @@
+package pricing
+
+import (
+	"fmt"
+	"kart-challenge/internal/domain"
+	"strings"
+)
+
+// CheckConditions reports whether a cart meets a campaign's conditions and has something its target
+// can discount. When it does not, the message tells the customer what is missing.
+func CheckConditions(campaign domain.Campaign, lines []Line) (bool, string) {
+	if cond := campaign.Conditions; cond != nil {
+		var subtotal domain.Money
+		var matching, units int
+		for _, line := range lines {
+			subtotal += unitPrice(line) * domain.Money(line.Item.Quantity)
+			if matchesProduct(cond.ProductFilter, line.Product) {
+				matching++
+				units += line.Item.Quantity
+			}
+		}
+
+		if subtotal < cond.MinSubtotal {
+			return false, fmt.Sprintf("Requires a subtotal of at least %s; the cart comes to %s.", cond.MinSubtotal, subtotal)
+		}
+		if !isEmptyFilter(cond.ProductFilter) && matching == 0 {
+			return false, fmt.Sprintf("Requires %s in the cart.", describeFilter(cond.ProductFilter))
+		}
+		if units < cond.MinQuantity {
+			if isEmptyFilter(cond.ProductFilter) {
+				return false, fmt.Sprintf("Requires at least %d items; the cart has %d.", cond.MinQuantity, units)
+			}
+			return false, fmt.Sprintf("Requires at least %d units of %s; the cart has %d.", cond.MinQuantity, describeFilter(cond.ProductFilter), units)
+		}
+	}
+
+	if campaign.Target != nil {
+		for _, line := range lines {
+			if matchesProduct(*campaign.Target, line.Product) {
+				return true, ""
+			}
+		}
+		return false, fmt.Sprintf("Only discounts %s, and the cart has none.", describeFilter(*campaign.Target))
+	}
+	return true, ""
+}
+
+// matchesProduct reports whether a product is in a filter's product IDs or categories.
+func matchesProduct(filter domain.ProductFilter, product domain.Product) bool {
+	if isEmptyFilter(filter) {
+		return true
+	}
+	for _, id := range filter.ProductIDs {
+		if id == product.ID {
+			return true
+		}
+	}
+	for _, category := range filter.Categories {
+		if strings.EqualFold(category, product.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyFilter(filter domain.ProductFilter) bool {
+	return len(filter.ProductIDs) == 0 && len(filter.Categories) == 0
+}
+
+// describeFilter renders a filter for customer-facing messages, e.g. "a product in Burgers".
+func describeFilter(filter domain.ProductFilter) string {
+	var parts []string
+	if len(filter.ProductIDs) > 0 {
+		parts = append(parts, "product "+strings.Join(filter.ProductIDs, ", "))
+	}
+	if len(filter.Categories) > 0 {
+		parts = append(parts, "a product in "+strings.Join(filter.Categories, ", "))
+	}
+	return strings.Join(parts, " or ")
+}
@@ -0,0 +1,79 @@
+package pricing
+
+import (
+	"kart-challenge/internal/domain"
+	"sort"
+)
+
+// campaignLineDiscounts returns the discount a campaign gives on each line. Only lines matching the
+// campaign's target (all lines if it has none) are discounted. Item-based campaigns (free item, buy X
+// get Y) discount the lines they name directly; order-level campaigns are spread across the targeted
+// lines in proportion to their subtotals. products[i] is the product of items[i].
+func campaignLineDiscounts(campaign domain.Campaign, items []domain.OrderLineItem, products []domain.Product) []domain.Money {
+	discounts := make([]domain.Money, len(items))
+
+	weights := make([]domain.Money, len(items))
+	var total domain.Money
+	for i, item := range items {
+		if campaign.Target == nil || matchesProduct(*campaign.Target, products[i]) {
+			weights[i] = item.Subtotal
+			total += item.Subtotal
+		}
+	}
+
+	switch campaign.Type {
+	case domain.CampaignTypePercentage:
+		amount := mulDivRoundHalfUp(int64(total), percentToBasisPoints(campaign.PercentOff), 10000)
+		allocate(discounts, weights, min(domain.Money(amount), total))
+	case domain.CampaignTypeFixedAmount:
+		allocate(discounts, weights, min(campaign.AmountOff, total))
+	case domain.CampaignTypeFreeItem:
+		for i, item := range items {
+			if item.ProductID == campaign.FreeProductID && item.Quantity > 0 && weights[i] > 0 {
+				discounts[i] = min(item.UnitPrice, item.Subtotal)
+				break
+			}
+		}
+	case domain.CampaignTypeBuyXGetY:
+		groupSize := campaign.BuyQuantity + campaign.GetQuantity
+		for i, item := range items {
+			if (campaign.ProductID != "" && item.ProductID != campaign.ProductID) || weights[i] == 0 {
+				continue
+			}
+			freeUnits := (item.Quantity / groupSize) * campaign.GetQuantity
+			discounts[i] = min(item.UnitPrice*domain.Money(freeUnits), item.Subtotal)
+		}
+	}
+	return discounts
+}
+
+// allocate spreads amount across lines in proportion to their weights (usually subtotals) using the
+// largest remainder method, so the allocations always sum to amount exactly.
+func allocate(discounts []domain.Money, weights []domain.Money, amount domain.Money) {
+	var total int64
+	for _, weight := range weights {
+		total += int64(weight)
+	}
+	if total == 0 || amount <= 0 {
+		return
+	}
+
+	type share struct {
+		index     int
+		remainder int64
+	}
+	shares := make([]share, 0, len(weights))
+	var allocated domain.Money
+	for i, weight := range weights {
+		product := int64(amount) * int64(weight)
+		discounts[i] = domain.Money(product / total)
+		allocated += discounts[i]
+		shares = append(shares, share{index: i, remainder: product % total})
+	}
+
+	sort.SliceStable(shares, func(a, b int) bool { return shares[a].remainder > shares[b].remainder })
+	for i := 0; allocated < amount; i++ {
+		discounts[shares[i].index]++
+		allocated++
+	}
+}
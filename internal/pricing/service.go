@@ -0,0 +1,137 @@
+package pricing
+
+import (
+	"kart-challenge/internal/domain"
+	"math"
+)
+
+// Rounding rules. All amounts are integer minor units; the only divisions are percentages, and
+// every one of them rounds half up exactly once:
+//  1. Unit price = product price + modifier price deltas, at least zero.
+//     Line subtotal = unit price x quantity (exact).
+//  2. A percentage discount is computed on the order total and rounded half up, then allocated
+//     to lines in proportion to their subtotals (largest remainder, ties to the earlier line).
+//  3. Discounts never exceed the total they apply to. Stacked promotions apply one after another
+//     to what is left of each line, item-based ones first, then fixed amounts, then percentages.
+//  4. Tax = (total - discount) x tax rate, rounded half up.
+//  5. Final price = total - discount + tax.
+
+// MaxLineQuantity is the most units a line may have, so line subtotals stay far from overflowing.
+const MaxLineQuantity = 10000
+
+// MaxTotal is the largest order total that can be priced. Sharing a discount out across lines
+// multiplies two amounts, and below this bound the product always fits in an int64.
+const MaxTotal domain.Money = 1_000_000_000
+
+// Line is an order line together with the product it refers to.
+type Line struct {
+	Item    domain.OrderLineItem
+	Product domain.Product
+}
+
+// Promotion is a campaign an order qualifies for, with the coupon code that brought it in.
+type Promotion struct {
+	Campaign domain.Campaign
+	Code     string // Empty for automatic promotions
+}
+
+// Result is the priced form of an order.
+type Result struct {
+	Items      []domain.OrderLineItem // Input items with UnitPrice, Subtotal and Discount filled in
+	Total      domain.Money
+	Discount   domain.Money
+	Tax        domain.Money
+	FinalPrice domain.Money
+	Applied    []domain.AppliedPromotion // In the order they were applied
+	Dropped    []domain.DroppedPromotion // In the order they were offered
+}
+
+// Config holds the pricing settings.
+type Config struct {
+	TaxRatePercent float64 // Applied to the discounted total, e.g. 10 for 10%
+
+	// Caps on combining stackable promotions. Zero means no cap.
+	MaxStackedPromotions      int
+	MaxStackedDiscountPercent float64 // Of the order total
+}
+
+// Service defines the interface for order pricing.
+type Service interface {
+	PriceOrder(lines []Line, promotions []Promotion) Result
+}
+
+// PricingService implements the Service interface.
+type PricingService struct {
+	taxRateBasisPoints         int64
+	maxStacked                 int
+	maxStackedDiscountBasisPts int64
+}
+
+// NewService creates a new PricingService.
+func NewService(cfg Config) Service {
+	return &PricingService{
+		taxRateBasisPoints:         percentToBasisPoints(cfg.TaxRatePercent),
+		maxStacked:                 cfg.MaxStackedPromotions,
+		maxStackedDiscountBasisPts: percentToBasisPoints(cfg.MaxStackedDiscountPercent),
+	}
+}
+
+// PriceOrder computes line subtotals, resolves which promotions apply, then computes the discount,
+// tax and final price. The same lines and promotions always give the same result. The lines must be
+// WithinMaxTotal.
+func (s *PricingService) PriceOrder(lines []Line, promotions []Promotion) Result {
+	result := Result{Items: make([]domain.OrderLineItem, len(lines))}
+	for i, line := range lines {
+		item := line.Item
+		item.UnitPrice = unitPrice(line)
+		item.Subtotal = item.UnitPrice * domain.Money(item.Quantity)
+		item.Discount = 0
+		result.Items[i] = item
+		result.Total += item.Subtotal
+	}
+
+	var discounts []domain.Money
+	discounts, result.Applied, result.Dropped = s.resolvePromotions(lines, result.Items, promotions)
+	for i, discount := range discounts {
+		result.Items[i].Discount = discount
+		result.Discount += discount
+	}
+
+	taxable := result.Total - result.Discount
+	result.Tax = domain.Money(mulDivRoundHalfUp(int64(taxable), s.taxRateBasisPoints, 10000))
+	result.FinalPrice = taxable + result.Tax
+	return result
+}
+
+// WithinMaxTotal reports whether the subtotals of lines add up to at most MaxTotal. It never
+// overflows, however large the prices or quantities.
+func WithinMaxTotal(lines []Line) bool {
+	var total domain.Money
+	for _, line := range lines {
+		price, quantity := unitPrice(line), domain.Money(line.Item.Quantity)
+		if quantity < 0 || (price > 0 && quantity > (MaxTotal-total)/price) {
+			return false
+		}
+		total += price * quantity
+	}
+	return true
+}
+
+// unitPrice is the price of one unit of a line: its product's price plus the selected modifiers.
+func unitPrice(line Line) domain.Money {
+	price := line.Product.Price
+	for _, modifier := range line.Item.Modifiers {
+		price += modifier.PriceDelta
+	}
+	return max(price, 0)
+}
+
+// percentToBasisPoints converts a percentage such as 12.5 into basis points (1250).
+func percentToBasisPoints(percent float64) int64 {
+	return int64(math.Round(percent * 100))
+}
+
+// mulDivRoundHalfUp returns value*num/den rounded half up, for non-negative inputs.
+func mulDivRoundHalfUp(value, num, den int64) int64 {
+	return (value*num + den/2) / den
+}
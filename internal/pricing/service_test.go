@@ -0,0 +1,430 @@
+package pricing
+
+import (
+	"kart-challenge/internal/domain"
+	"strings"
+	"testing"
+)
+
+func pricingLines(prices []domain.Money, quantities []int) []Line {
+	lines := make([]Line, len(prices))
+	for i := range prices {
+		id := string(rune('a' + i))
+		lines[i] = Line{
+			Item:    domain.OrderLineItem{ProductID: id, Quantity: quantities[i]},
+			Product: domain.Product{ID: id, Price: prices[i]},
+		}
+	}
+	return lines
+}
+
+func promotionsFor(campaigns ...*domain.Campaign) []Promotion {
+	var promotions []Promotion
+	for _, c := range campaigns {
+		if c != nil {
+			promotions = append(promotions, Promotion{Campaign: *c, Code: c.ID})
+		}
+	}
+	return promotions
+}
+
+func TestPricingService_PriceOrder_Rounding(t *testing.T) {
+	tests := []struct {
+		name             string
+		taxRatePercent   float64
+		prices           []domain.Money
+		quantities       []int
+		campaign         *domain.Campaign
+		expectedDiscount domain.Money
+		expectedTax      domain.Money
+		expectedFinal    domain.Money
+	}{
+		{
+			name:       "No campaign, no tax",
+			prices:     []domain.Money{1299, 349},
+			quantities: []int{3, 2},
+			// 38.97 + 6.98
+			expectedFinal: 4595,
+		},
+		{
+			name:       "Percentage rounds half up once on the order total",
+			prices:     []domain.Money{1299, 349},
+			quantities: []int{3, 2},
+			campaign:   &domain.Campaign{Type: domain.CampaignTypePercentage, PercentOff: 10},
+			// 10% of 45.95 = 4.595 -> 4.60
+			expectedDiscount: 460,
+			expectedFinal:    4135,
+		},
+		{
+			name:       "Percentage on many small lines is not rounded per line",
+			prices:     []domain.Money{5, 5, 5},
+			quantities: []int{1, 1, 1},
+			campaign:   &domain.Campaign{Type: domain.CampaignTypePercentage, PercentOff: 10},
+			// 10% of 0.15 = 0.015 -> 0.02 (rounding each line would give 0.03)
+			expectedDiscount: 2,
+			expectedFinal:    13,
+		},
+		{
+			name:           "Tax applies to the discounted total and rounds half up",
+			taxRatePercent: 8.25,
+			prices:         []domain.Money{1000},
+			quantities:     []int{1},
+			campaign:       &domain.Campaign{Type: domain.CampaignTypeFixedAmount, AmountOff: 200},
+			// 8.25% of 8.00 = 0.66
+			expectedDiscount: 200,
+			expectedTax:      66,
+			expectedFinal:    866,
+		},
+		{
+			name:           "Tax half cent rounds up",
+			taxRatePercent: 5,
+			prices:         []domain.Money{10},
+			quantities:     []int{1},
+			// 5% of 0.10 = 0.005 -> 0.01
+			expectedTax:   1,
+			expectedFinal: 11,
+		},
+		{
+			name:             "Fixed amount is capped at the total",
+			prices:           []domain.Money{300},
+			quantities:       []int{1},
+			campaign:         &domain.Campaign{Type: domain.CampaignTypeFixedAmount, AmountOff: 500},
+			expectedDiscount: 300,
+			expectedFinal:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewService(Config{TaxRatePercent: tt.taxRatePercent}).PriceOrder(pricingLines(tt.prices, tt.quantities), promotionsFor(tt.campaign))
+
+			if result.Discount != tt.expectedDiscount {
+				t.Errorf("Expected discount %s, got %s", tt.expectedDiscount, result.Discount)
+			}
+			if result.Tax != tt.expectedTax {
+				t.Errorf("Expected tax %s, got %s", tt.expectedTax, result.Tax)
+			}
+			if result.FinalPrice != tt.expectedFinal {
+				t.Errorf("Expected final price %s, got %s", tt.expectedFinal, result.FinalPrice)
+			}
+
+			var subtotals, lineDiscounts domain.Money
+			for _, item := range result.Items {
+				subtotals += item.Subtotal
+				lineDiscounts += item.Discount
+			}
+			if subtotals != result.Total {
+				t.Errorf("Line subtotals %s do not add up to total %s", subtotals, result.Total)
+			}
+			if lineDiscounts != result.Discount {
+				t.Errorf("Line discounts %s do not add up to discount %s", lineDiscounts, result.Discount)
+			}
+		})
+	}
+}
+
+func TestPricingService_PriceOrder_AllocationIsDeterministic(t *testing.T) {
+	// 1.00 off split over three equal lines: 0.34, 0.33, 0.33 (ties go to the earlier line).
+	campaign := &domain.Campaign{Type: domain.CampaignTypeFixedAmount, AmountOff: 100}
+	result := NewService(Config{}).PriceOrder(pricingLines([]domain.Money{200, 200, 200}, []int{1, 1, 1}), promotionsFor(campaign))
+
+	expected := []domain.Money{34, 33, 33}
+	for i, item := range result.Items {
+		if item.Discount != expected[i] {
+			t.Errorf("Line %d: expected discount %s, got %s", i, expected[i], item.Discount)
+		}
+	}
+}
+
+func TestPricingService_PriceOrder_Stacking(t *testing.T) {
+	percent10 := &domain.Campaign{ID: "p10", Type: domain.CampaignTypePercentage, PercentOff: 10, Stacking: domain.CampaignStackingStackable}
+	percent20 := &domain.Campaign{ID: "p20", Type: domain.CampaignTypePercentage, PercentOff: 20, Stacking: domain.CampaignStackingStackable}
+	fixed5 := &domain.Campaign{ID: "f5", Type: domain.CampaignTypeFixedAmount, AmountOff: 500, Stacking: domain.CampaignStackingStackable}
+	exclusive25 := &domain.Campaign{ID: "x25", Type: domain.CampaignTypePercentage, PercentOff: 25}
+	exclusive40 := &domain.Campaign{ID: "x40", Type: domain.CampaignTypePercentage, PercentOff: 40}
+	freeB := &domain.Campaign{ID: "free-b", Type: domain.CampaignTypeFreeItem, FreeProductID: "b", Stacking: domain.CampaignStackingStackable}
+	freeZ := &domain.Campaign{ID: "free-z", Type: domain.CampaignTypeFreeItem, FreeProductID: "z"}
+
+	tests := []struct {
+		name             string
+		cfg              Config
+		campaigns        []*domain.Campaign
+		expectedDiscount domain.Money
+		expectedApplied  []string
+		expectedDropped  map[string]string // Campaign ID -> reason
+	}{
+		{
+			name:      "Stackable promotions combine, fixed amounts before percentages",
+			campaigns: []*domain.Campaign{percent10, fixed5},
+			// 5.00 off 100.00, then 10% of 95.00
+			expectedDiscount: 1450,
+			expectedApplied:  []string{"f5", "p10"},
+		},
+		{
+			name:      "Item-based promotions apply first",
+			campaigns: []*domain.Campaign{percent10, freeB},
+			// b (20.00) free, then 10% of 80.00
+			expectedDiscount: 2800,
+			expectedApplied:  []string{"free-b", "p10"},
+		},
+		{
+			name:             "Exclusive wins when it beats the combination",
+			campaigns:        []*domain.Campaign{percent10, fixed5, exclusive25},
+			expectedDiscount: 2500,
+			expectedApplied:  []string{"x25"},
+			expectedDropped:  map[string]string{"p10": domain.PromotionDroppedNotCombinable, "f5": domain.PromotionDroppedNotCombinable},
+		},
+		{
+			name:      "Combination wins when it beats the exclusive",
+			campaigns: []*domain.Campaign{exclusive25, percent20, fixed5, percent10},
+			// 5.00, then 20% of 95.00 = 19.00, then 10% of 76.00 = 7.60
+			expectedDiscount: 3160,
+			expectedApplied:  []string{"f5", "p20", "p10"},
+			expectedDropped:  map[string]string{"x25": domain.PromotionDroppedNotCombinable},
+		},
+		{
+			name:             "Only the best exclusive applies",
+			campaigns:        []*domain.Campaign{exclusive25, exclusive40},
+			expectedDiscount: 4000,
+			expectedApplied:  []string{"x40"},
+			expectedDropped:  map[string]string{"x25": domain.PromotionDroppedNotCombinable},
+		},
+		{
+			name:      "Stack limit keeps the biggest promotions",
+			cfg:       Config{MaxStackedPromotions: 2},
+			campaigns: []*domain.Campaign{percent10, fixed5, percent20},
+			// 20.00 and 10.00 on their own beat 5.00; percentages apply in the order offered
+			expectedDiscount: 2800,
+			expectedApplied:  []string{"p10", "p20"},
+			expectedDropped:  map[string]string{"f5": domain.PromotionDroppedStackLimit},
+		},
+		{
+			name:      "Combined discount is capped, trimming the last promotion",
+			cfg:       Config{MaxStackedDiscountPercent: 20},
+			campaigns: []*domain.Campaign{percent10, percent20},
+			// 10.00 + 18.00 = 28.00 is trimmed to 20% of 100.00, all of it from p20
+			expectedDiscount: 2000,
+			expectedApplied:  []string{"p10", "p20"},
+		},
+		{
+			name:             "Cap drops promotions with nothing left under it",
+			cfg:              Config{MaxStackedDiscountPercent: 5},
+			campaigns:        []*domain.Campaign{fixed5, percent10},
+			expectedDiscount: 500,
+			expectedApplied:  []string{"f5"},
+			expectedDropped:  map[string]string{"p10": domain.PromotionDroppedStackLimit},
+		},
+		{
+			name:             "Promotions without a qualifying item and duplicates are dropped",
+			campaigns:        []*domain.Campaign{percent10, freeZ, percent10},
+			expectedDiscount: 1000,
+			expectedApplied:  []string{"p10"},
+			expectedDropped:  map[string]string{"free-z": domain.PromotionDroppedNoDiscount},
+		},
+		{
+			name:             "Tie between exclusive and combination goes to the exclusive",
+			campaigns:        []*domain.Campaign{percent10, {ID: "x10", Type: domain.CampaignTypePercentage, PercentOff: 10}},
+			expectedDiscount: 1000,
+			expectedApplied:  []string{"x10"},
+			expectedDropped:  map[string]string{"p10": domain.PromotionDroppedNotCombinable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a: 4 x 20.00, b: 1 x 20.00
+			lines := pricingLines([]domain.Money{2000, 2000}, []int{4, 1})
+			promotions := promotionsFor(tt.campaigns...)
+			result := NewService(tt.cfg).PriceOrder(lines, promotions)
+
+			if result.Discount != tt.expectedDiscount {
+				t.Errorf("Expected discount %s, got %s", tt.expectedDiscount, result.Discount)
+			}
+			var applied []string
+			var appliedTotal domain.Money
+			for _, p := range result.Applied {
+				applied = append(applied, p.CampaignID)
+				appliedTotal += p.Discount
+			}
+			if strings.Join(applied, ",") != strings.Join(tt.expectedApplied, ",") {
+				t.Errorf("Expected applied %v, got %v", tt.expectedApplied, applied)
+			}
+			if appliedTotal != result.Discount {
+				t.Errorf("Applied promotions add up to %s, expected %s", appliedTotal, result.Discount)
+			}
+
+			dropped := make(map[string]string)
+			for _, d := range result.Dropped {
+				if _, ok := dropped[d.CampaignID]; ok && d.Reason != domain.PromotionDroppedDuplicate {
+					t.Errorf("Campaign %s dropped twice", d.CampaignID)
+				}
+				if d.Reason != domain.PromotionDroppedDuplicate {
+					dropped[d.CampaignID] = d.Reason
+				}
+				if d.Message == "" {
+					t.Errorf("Dropped campaign %s has no explanation", d.CampaignID)
+				}
+			}
+			if len(dropped) != len(tt.expectedDropped) {
+				t.Errorf("Expected dropped %v, got %v", tt.expectedDropped, dropped)
+			}
+			for id, reason := range tt.expectedDropped {
+				if dropped[id] != reason {
+					t.Errorf("Expected %s dropped with %s, got %q", id, reason, dropped[id])
+				}
+			}
+			if len(result.Applied)+len(result.Dropped) != len(promotions) {
+				t.Errorf("Every promotion must be either applied or dropped")
+			}
+
+			// Same input, same answer.
+			again := NewService(tt.cfg).PriceOrder(lines, promotions)
+			for i := range result.Items {
+				if again.Items[i].Discount != result.Items[i].Discount {
+					t.Errorf("Line %d discount is not deterministic", i)
+				}
+			}
+		})
+	}
+}
+
+func TestPricingService_PriceOrder_ConditionsAndTargets(t *testing.T) {
+	// Burger 10.00 x2, fries 5.00 x1, cola 2.50 x2: subtotal 30.00
+	lines := []Line{
+		{Item: domain.OrderLineItem{ProductID: "burger", Quantity: 2}, Product: domain.Product{ID: "burger", Price: 1000, Category: "Burgers"}},
+		{Item: domain.OrderLineItem{ProductID: "fries", Quantity: 1}, Product: domain.Product{ID: "fries", Price: 500, Category: "Sides"}},
+		{Item: domain.OrderLineItem{ProductID: "cola", Quantity: 2}, Product: domain.Product{ID: "cola", Price: 250, Category: "Drinks"}},
+	}
+	burgers := domain.ProductFilter{Categories: []string{"burgers"}}
+
+	tests := []struct {
+		name              string
+		campaign          domain.Campaign
+		expectApplicable  bool
+		expectedLineDisc  []domain.Money
+		expectMessagePart string
+	}{
+		{
+			name:             "Minimum subtotal met",
+			campaign:         domain.Campaign{ID: "min", Type: domain.CampaignTypeFixedAmount, AmountOff: 300, Conditions: &domain.CampaignConditions{MinSubtotal: 3000}},
+			expectApplicable: true,
+			expectedLineDisc: []domain.Money{200, 50, 50},
+		},
+		{
+			name:              "Minimum subtotal not met",
+			campaign:          domain.Campaign{ID: "min", Type: domain.CampaignTypeFixedAmount, AmountOff: 300, Conditions: &domain.CampaignConditions{MinSubtotal: 3001}},
+			expectMessagePart: "30.01",
+		},
+		{
+			name:             "Required category present, case-insensitive",
+			campaign:         domain.Campaign{ID: "cat", Type: domain.CampaignTypePercentage, PercentOff: 10, Conditions: &domain.CampaignConditions{ProductFilter: burgers}},
+			expectApplicable: true,
+			expectedLineDisc: []domain.Money{200, 50, 50},
+		},
+		{
+			name:              "Required product missing",
+			campaign:          domain.Campaign{ID: "prod", Type: domain.CampaignTypePercentage, PercentOff: 10, Conditions: &domain.CampaignConditions{ProductFilter: domain.ProductFilter{ProductIDs: []string{"nuggets"}}}},
+			expectMessagePart: "nuggets",
+		},
+		{
+			name:              "Minimum quantity of matching products not met",
+			campaign:          domain.Campaign{ID: "qty", Type: domain.CampaignTypePercentage, PercentOff: 10, Conditions: &domain.CampaignConditions{ProductFilter: burgers, MinQuantity: 3}},
+			expectMessagePart: "at least 3",
+		},
+		{
+			name:             "Percentage targets matching lines only",
+			campaign:         domain.Campaign{ID: "target", Type: domain.CampaignTypePercentage, PercentOff: 25, Target: &domain.ProductFilter{Categories: []string{"Burgers", "Drinks"}}},
+			expectApplicable: true,
+			expectedLineDisc: []domain.Money{500, 0, 125},
+		},
+		{
+			name:             "Fixed amount is capped at the targeted lines",
+			campaign:         domain.Campaign{ID: "target", Type: domain.CampaignTypeFixedAmount, AmountOff: 1000, Target: &domain.ProductFilter{ProductIDs: []string{"fries"}}},
+			expectApplicable: true,
+			expectedLineDisc: []domain.Money{0, 500, 0},
+		},
+		{
+			name:              "Target with nothing in the cart",
+			campaign:          domain.Campaign{ID: "target", Type: domain.CampaignTypePercentage, PercentOff: 25, Target: &domain.ProductFilter{Categories: []string{"Desserts"}}},
+			expectMessagePart: "Desserts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applicable, message := CheckConditions(tt.campaign, lines)
+			if applicable != tt.expectApplicable || !strings.Contains(message, tt.expectMessagePart) {
+				t.Errorf("Expected applicable=%t with a message mentioning %q, got %t %q", tt.expectApplicable, tt.expectMessagePart, applicable, message)
+			}
+
+			result := NewService(Config{}).PriceOrder(lines, []Promotion{{Campaign: tt.campaign}})
+			if !tt.expectApplicable {
+				if result.Discount != 0 || len(result.Dropped) != 1 || result.Dropped[0].Reason != domain.PromotionDroppedNotApplicable {
+					t.Errorf("Expected the promotion to be dropped as NOT_APPLICABLE, got discount %s, dropped %+v", result.Discount, result.Dropped)
+				}
+				return
+			}
+			for i, item := range result.Items {
+				if item.Discount != tt.expectedLineDisc[i] {
+					t.Errorf("Line %s: expected discount %s, got %s", item.ProductID, tt.expectedLineDisc[i], item.Discount)
+				}
+			}
+		})
+	}
+}
+
+func TestPricingService_PriceOrder_Modifiers(t *testing.T) {
+	// Burger 10.00 with extra cheese (+1.00) and bacon (+1.50) x2, cola 2.50 small (-0.50), water 0.30 small
+	lines := []Line{
+		{
+			Item: domain.OrderLineItem{ProductID: "burger", Quantity: 2, Modifiers: []domain.SelectedModifier{
+				{GroupID: "toppings", OptionID: "cheese", PriceDelta: 100},
+				{GroupID: "toppings", OptionID: "bacon", PriceDelta: 150},
+			}},
+			Product: domain.Product{ID: "burger", Price: 1000},
+		},
+		{
+			Item:    domain.OrderLineItem{ProductID: "cola", Quantity: 1, Modifiers: []domain.SelectedModifier{{GroupID: "size", OptionID: "small", PriceDelta: -50}}},
+			Product: domain.Product{ID: "cola", Price: 250},
+		},
+		{
+			Item:    domain.OrderLineItem{ProductID: "water", Quantity: 1, Modifiers: []domain.SelectedModifier{{GroupID: "size", OptionID: "small", PriceDelta: -50}}},
+			Product: domain.Product{ID: "water", Price: 30},
+		},
+	}
+	service := NewService(Config{})
+
+	result := service.PriceOrder(lines, promotionsFor(&domain.Campaign{ID: "min", Type: domain.CampaignTypeFixedAmount, AmountOff: 100,
+		Conditions: &domain.CampaignConditions{MinSubtotal: 2700}}))
+	expectedUnits := []domain.Money{1250, 200, 0}
+	for i, item := range result.Items {
+		if item.UnitPrice != expectedUnits[i] {
+			t.Errorf("Line %d: expected unit price %s, got %s", i, expectedUnits[i], item.UnitPrice)
+		}
+	}
+	if result.Total != 2700 || result.Discount != 100 {
+		t.Errorf("Expected a total of 27.00 meeting the campaign's minimum, got %s with discount %s", result.Total, result.Discount)
+	}
+}
+
+func TestWithinMaxTotal(t *testing.T) {
+	tests := []struct {
+		name       string
+		prices     []domain.Money
+		quantities []int
+		expected   bool
+	}{
+		{"Exactly the maximum", []domain.Money{MaxTotal / 2, 1}, []int{2, 0}, true},
+		{"One unit over the maximum", []domain.Money{MaxTotal, 1}, []int{1, 1}, false},
+		{"Quantity that would overflow", []domain.Money{1000}, []int{1 << 62}, false},
+		{"Price that would overflow", []domain.Money{1 << 62}, []int{4}, false},
+		{"Free lines add nothing", []domain.Money{0}, []int{1 << 62}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WithinMaxTotal(pricingLines(tt.prices, tt.quantities)); got != tt.expected {
+				t.Errorf("Expected %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package pricing
+
+import (
+	"fmt"
+	"kart-challenge/internal/domain"
+	"sort"
+)
+
+// candidate is a promotion that discounts the order on its own.
+type candidate struct {
+	Promotion
+	offered    int          // Position in the promotions passed to PriceOrder
+	standalone domain.Money // Discount it would give if it were the only promotion
+}
+
+// stackedPromotion is a stackable promotion's share of the combined discount.
+type stackedPromotion struct {
+	candidate
+	lines  []domain.Money
+	amount domain.Money
+	capped bool
+}
+
+// resolvePromotions decides which promotions apply and returns the per-line discounts they add up to.
+// Each campaign counts once. The order gets either its best exclusive promotion or the stackable
+// promotions combined, whichever discounts more; on a tie the exclusive one wins because it uses
+// fewer coupons. When there are more stackable promotions than may be combined, the biggest ones
+// (then the earliest offered) are kept.
+func (s *PricingService) resolvePromotions(lines []Line, items []domain.OrderLineItem, promotions []Promotion) ([]domain.Money, []domain.AppliedPromotion, []domain.DroppedPromotion) {
+	products := make([]domain.Product, len(lines))
+	for i, line := range lines {
+		products[i] = line.Product
+	}
+	discounts := make([]domain.Money, len(items))
+	drops := make([]*domain.DroppedPromotion, len(promotions))
+	drop := func(c candidate, reason, message string) {
+		drops[c.offered] = &domain.DroppedPromotion{CampaignID: c.Campaign.ID, Code: c.Code, Reason: reason, Message: message}
+	}
+
+	var exclusive, stackable []candidate
+	seen := make(map[string]bool)
+	for i, p := range promotions {
+		c := candidate{Promotion: p, offered: i}
+		if seen[p.Campaign.ID] {
+			drop(c, domain.PromotionDroppedDuplicate, fmt.Sprintf("campaign '%s' is already applied through another code", p.Campaign.ID))
+			continue
+		}
+		seen[p.Campaign.ID] = true
+		if ok, message := CheckConditions(p.Campaign, lines); !ok {
+			drop(c, domain.PromotionDroppedNotApplicable, message)
+			continue
+		}
+
+		c.standalone = sum(campaignLineDiscounts(p.Campaign, items, products))
+		switch {
+		case c.standalone == 0:
+			drop(c, domain.PromotionDroppedNoDiscount, "nothing in the order qualifies for it")
+		case p.Campaign.Stacking == domain.CampaignStackingStackable:
+			stackable = append(stackable, c)
+		default:
+			exclusive = append(exclusive, c)
+		}
+	}
+
+	sort.SliceStable(stackable, func(i, j int) bool { return stackable[i].standalone > stackable[j].standalone })
+	if s.maxStacked > 0 && len(stackable) > s.maxStacked {
+		for _, c := range stackable[s.maxStacked:] {
+			drop(c, domain.PromotionDroppedStackLimit, fmt.Sprintf("at most %d promotions can be combined and the others give bigger discounts", s.maxStacked))
+		}
+		stackable = stackable[:s.maxStacked]
+	}
+	stack := s.applyStack(items, products, stackable)
+	var stackTotal domain.Money
+	for _, p := range stack {
+		stackTotal += p.amount
+	}
+
+	var best *candidate
+	for i := range exclusive {
+		if best == nil || exclusive[i].standalone > best.standalone {
+			best = &exclusive[i]
+		}
+	}
+
+	var applied []domain.AppliedPromotion
+	if best != nil && best.standalone >= stackTotal {
+		copy(discounts, campaignLineDiscounts(best.Campaign, items, products))
+		applied = append(applied, appliedPromotion(best.Promotion, best.standalone, false))
+		message := fmt.Sprintf("it cannot be combined with '%s', which gives a bigger discount", best.Campaign.ID)
+		for _, c := range exclusive {
+			if c.offered != best.offered {
+				drop(c, domain.PromotionDroppedNotCombinable, message)
+			}
+		}
+		for _, p := range stack {
+			drop(p.candidate, domain.PromotionDroppedNotCombinable, message)
+		}
+	} else {
+		for _, c := range exclusive {
+			drop(c, domain.PromotionDroppedNotCombinable, "it cannot be combined with other promotions and the combined promotions give a bigger discount")
+		}
+		for _, p := range stack {
+			if p.amount == 0 {
+				reason, message := domain.PromotionDroppedNoDiscount, "nothing is left to discount after the other promotions"
+				if p.capped {
+					reason, message = domain.PromotionDroppedStackLimit, "the combined discount had already reached its cap"
+				}
+				drop(p.candidate, reason, message)
+				continue
+			}
+			for i, discount := range p.lines {
+				discounts[i] += discount
+			}
+			applied = append(applied, appliedPromotion(p.Promotion, p.amount, p.capped))
+		}
+	}
+
+	var dropped []domain.DroppedPromotion
+	for _, d := range drops {
+		if d != nil {
+			dropped = append(dropped, *d)
+		}
+	}
+	return discounts, applied, dropped
+}
+
+// applyStack applies stackable promotions one after another, each to what the previous ones left of
+// every line, then trims the combined discount to the configured cap starting from the last one applied.
+func (s *PricingService) applyStack(items []domain.OrderLineItem, products []domain.Product, stackable []candidate) []stackedPromotion {
+	stack := make([]stackedPromotion, len(stackable))
+	for i, c := range stackable {
+		stack[i] = stackedPromotion{candidate: c}
+	}
+	sort.SliceStable(stack, func(i, j int) bool {
+		ri, rj := applicationRank(stack[i].Campaign.Type), applicationRank(stack[j].Campaign.Type)
+		if ri != rj {
+			return ri < rj
+		}
+		return stack[i].offered < stack[j].offered
+	})
+
+	remaining := make([]domain.OrderLineItem, len(items))
+	copy(remaining, items)
+	var total, combined domain.Money
+	for _, item := range items {
+		total += item.Subtotal
+	}
+	for i := range stack {
+		stack[i].lines = campaignLineDiscounts(stack[i].Campaign, remaining, products)
+		for line, discount := range stack[i].lines {
+			remaining[line].Subtotal -= discount
+		}
+		stack[i].amount = sum(stack[i].lines)
+		combined += stack[i].amount
+	}
+
+	if s.maxStackedDiscountBasisPts <= 0 {
+		return stack
+	}
+	limit := domain.Money(mulDivRoundHalfUp(int64(total), s.maxStackedDiscountBasisPts, 10000))
+	for i := len(stack) - 1; i >= 0 && combined > limit; i-- {
+		cut := min(combined-limit, stack[i].amount)
+		if cut == 0 {
+			continue
+		}
+		lines := make([]domain.Money, len(items))
+		allocate(lines, stack[i].lines, stack[i].amount-cut)
+		stack[i].lines = lines
+		stack[i].amount -= cut
+		stack[i].capped = true
+		combined -= cut
+	}
+	return stack
+}
+
+// applicationRank orders stacked promotions: item-based first, then fixed amounts, then percentages,
+// so a percentage is taken of the price the customer would otherwise pay.
+func applicationRank(campaignType string) int {
+	switch campaignType {
+	case domain.CampaignTypeFreeItem, domain.CampaignTypeBuyXGetY:
+		return 0
+	case domain.CampaignTypeFixedAmount:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func appliedPromotion(p Promotion, amount domain.Money, capped bool) domain.AppliedPromotion {
+	return domain.AppliedPromotion{CampaignID: p.Campaign.ID, CampaignName: p.Campaign.Name, Code: p.Code, Discount: amount, Capped: capped}
+}
+
+func sum(amounts []domain.Money) domain.Money {
+	var total domain.Money
+	for _, amount := range amounts {
+		total += amount
+	}
+	return total
+}
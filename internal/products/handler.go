@@ -1,7 +1,9 @@
 package products
 
 import (
+	"errors"
 	"kart-challenge/internal/domain" // Corrected import path
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -33,3 +35,36 @@ func (h *Handler) GetProductByID(c *fiber.Ctx) error {
 	}
 	return c.Status(fiber.StatusOK).JSON(product)
 }
+
+// SetAvailability handles PUT /admin/products/:id/availability, e.g. to mark a product sold out.
+func (h *Handler) SetAvailability(c *fiber.Ctx) error {
+	req := new(domain.SetAvailabilityRequest)
+	if err := c.BodyParser(req); err != nil {
+		log.Printf("Error parsing product availability request: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: domain.ErrInvalidRequestPayload.Error(),
+			Code:    fiber.StatusBadRequest,
+		})
+	}
+
+	product, err := h.Service.SetAvailability(c.Params("id"), *req)
+	switch {
+	case errors.Is(err, domain.ErrProductNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(domain.ErrorResponse{
+			Message: domain.ErrProductNotFound.Error(),
+			Code:    fiber.StatusNotFound,
+		})
+	case errors.Is(err, domain.ErrInvalidStock), errors.Is(err, domain.ErrConflictingStock):
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		})
+	case err != nil:
+		log.Printf("Error setting availability of product %s: %v", c.Params("id"), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+			Message: domain.ErrInternalServerError.Error(),
+			Code:    fiber.StatusInternalServerError,
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(product)
+}
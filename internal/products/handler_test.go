@@ -0,0 +1,74 @@
+package products
+
+import (
+	"encoding/json"
+	"io"
+	"kart-challenge/internal/domain"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHandler_SetAvailability(t *testing.T) {
+	repo := &inMemoryProductRepository{
+		products: map[string]domain.Product{
+			"p1": {ID: "p1", Name: "Burger", Price: 1000, Available: true},
+			"p2": {ID: "p2", Name: "Fries", Price: 500},
+		},
+		stock: make(map[string]int),
+	}
+	app := fiber.New()
+	app.Put("/products/:id/availability", NewHandler(NewService(repo)).SetAvailability)
+
+	tests := []struct {
+		name      string
+		id        string
+		body      string
+		status    int
+		available bool
+		stock     int // -1 for untracked
+	}{
+		{"Stock only keeps an available product available", "p1", `{"stock": 10}`, fiber.StatusOK, true, 10},
+		{"Stock only keeps a sold out product sold out", "p2", `{"stock": 10}`, fiber.StatusOK, false, 10},
+		{"Availability only keeps the stock", "p1", `{"available": false}`, fiber.StatusOK, false, 10},
+		{"Both at once", "p2", `{"available": true, "stock": 4}`, fiber.StatusOK, true, 4},
+		{"Untracking keeps the availability", "p2", `{"untrack_stock": true}`, fiber.StatusOK, true, -1},
+		{"Negative stock", "p1", `{"stock": -1}`, fiber.StatusBadRequest, false, 10},
+		{"Unknown product", "p9", `{"stock": 1}`, fiber.StatusNotFound, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodPut, "/products/"+tt.id+"/availability", strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Expected %d, got %d: %s", tt.status, resp.StatusCode, body)
+			}
+			if tt.status == fiber.StatusNotFound {
+				return
+			}
+
+			var product domain.Product
+			if tt.status == fiber.StatusOK {
+				if err := json.Unmarshal(body, &product); err != nil {
+					t.Fatalf("Invalid response %s: %v", body, err)
+				}
+			} else {
+				product, _ = repo.GetByID(tt.id)
+			}
+			stock := -1
+			if product.Stock != nil {
+				stock = *product.Stock
+			}
+			if product.Available != tt.available || stock != tt.stock {
+				t.Errorf("Expected available=%t with stock %d, got available=%t with stock %d", tt.available, tt.stock, product.Available, stock)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package products
 
 import (
+	"fmt"
 	"kart-challenge/internal/domain"
 	"log"
 	"sync"
@@ -10,11 +11,21 @@ import (
 type ProductRepository interface {
 	GetAll() []domain.Product
 	GetByID(id string) (domain.Product, bool)
+	// SetAvailability marks a product available or sold out and sets its stock count. A nil available
+	// leaves the flag as it is; a nil stock leaves the count as it is, unless untrack is set, which
+	// stops tracking it.
+	SetAvailability(id string, available *bool, stock *int, untrack bool) (domain.Product, error)
+	// ReserveStock takes the quantities (product ID -> units) out of stock, all or nothing. It fails
+	// with domain.ErrProductUnavailable if any product is sold out or short of stock.
+	ReserveStock(quantities map[string]int) error
+	// ReleaseStock puts reserved quantities back into stock.
+	ReleaseStock(quantities map[string]int)
 }
 
 // inMemoryProductRepository is an in-memory implementation of ProductRepository.
 type inMemoryProductRepository struct {
 	products map[string]domain.Product
+	stock    map[string]int // Units left, for products whose stock is tracked
 	mu       sync.RWMutex
 }
 
@@ -22,6 +33,7 @@ type inMemoryProductRepository struct {
 func NewInMemoryProductRepository() ProductRepository {
 	r := &inMemoryProductRepository{
 		products: make(map[string]domain.Product),
+		stock:    make(map[string]int),
 	}
 	r.loadMockProducts() // Load some initial mock products
 	return r
@@ -32,15 +44,32 @@ func (r *inMemoryProductRepository) loadMockProducts() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	burgerToppings := domain.ModifierGroup{ID: "toppings", Name: "Toppings", MaxSelections: 3, Options: []domain.ModifierOption{
+		{ID: "no-onions", Name: "No onions"},
+		{ID: "extra-cheese", Name: "Extra cheese", PriceDelta: 100},
+		{ID: "bacon", Name: "Bacon", PriceDelta: 150},
+	}}
+	drinkSize := domain.ModifierGroup{ID: "size", Name: "Size", Required: true, MaxSelections: 1, Options: []domain.ModifierOption{
+		{ID: "small", Name: "Small", PriceDelta: -50},
+		{ID: "regular", Name: "Regular"},
+		{ID: "large", Name: "Large", PriceDelta: 100},
+	}}
+	nuggetSauces := domain.ModifierGroup{ID: "sauces", Name: "Dipping sauces", MaxSelections: 2, Options: []domain.ModifierOption{
+		{ID: "bbq", Name: "BBQ"},
+		{ID: "sweet-sour", Name: "Sweet & sour"},
+		{ID: "honey-mustard", Name: "Honey mustard", PriceDelta: 50},
+	}}
+
 	products := []domain.Product{
-		{ID: "prod1", Name: "Burger Classic", Price: 12.99, Category: "Burgers"},
-		{ID: "prod2", Name: "Fries Large", Price: 3.49, Category: "Sides"},
-		{ID: "prod3", Name: "Coca-Cola", Price: 2.50, Category: "Drinks"},
-		{ID: "prod4", Name: "Veggie Burger", Price: 11.50, Category: "Burgers"},
-		{ID: "prod5", Name: "Chicken Nuggets", Price: 6.00, Category: "Sides"},
+		{ID: "prod1", Name: "Burger Classic", Price: 1299, Category: "Burgers", ModifierGroups: []domain.ModifierGroup{burgerToppings}},
+		{ID: "prod2", Name: "Fries Large", Price: 349, Category: "Sides"},
+		{ID: "prod3", Name: "Coca-Cola", Price: 250, Category: "Drinks", ModifierGroups: []domain.ModifierGroup{drinkSize}},
+		{ID: "prod4", Name: "Veggie Burger", Price: 1150, Category: "Burgers", ModifierGroups: []domain.ModifierGroup{burgerToppings}},
+		{ID: "prod5", Name: "Chicken Nuggets", Price: 600, Category: "Sides", ModifierGroups: []domain.ModifierGroup{nuggetSauces}},
 	}
 
 	for _, p := range products {
+		p.Available = true
 		r.products[p.ID] = p
 	}
 	log.Printf("Loaded %d mock products into repository.", len(r.products))
@@ -53,7 +82,7 @@ func (r *inMemoryProductRepository) GetAll() []domain.Product {
 
 	allProducts := make([]domain.Product, 0, len(r.products))
 	for _, p := range r.products {
-		allProducts = append(allProducts, p)
+		allProducts = append(allProducts, r.withStock(p))
 	}
 	return allProducts
 }
@@ -63,5 +92,70 @@ func (r *inMemoryProductRepository) GetByID(id string) (domain.Product, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	product, found := r.products[id]
-	return product, found
+	return r.withStock(product), found
+}
+
+// SetAvailability updates a product's availability flag and stock count.
+func (r *inMemoryProductRepository) SetAvailability(id string, available *bool, stock *int, untrack bool) (domain.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	product, found := r.products[id]
+	if !found {
+		return domain.Product{}, domain.ErrProductNotFound
+	}
+	if available != nil {
+		product.Available = *available
+		r.products[id] = product
+	}
+	switch {
+	case stock != nil:
+		r.stock[id] = *stock
+	case untrack:
+		delete(r.stock, id)
+	}
+	return r.withStock(product), nil
+}
+
+// ReserveStock checks every product before taking anything, so a failed reservation changes nothing.
+func (r *inMemoryProductRepository) ReserveStock(quantities map[string]int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, quantity := range quantities {
+		product, found := r.products[id]
+		if !found {
+			return domain.ErrProductNotFound
+		}
+		if product = r.withStock(product); !product.InStock(quantity) {
+			if !product.Available {
+				return fmt.Errorf("%w: '%s' is sold out", domain.ErrProductUnavailable, product.Name)
+			}
+			return fmt.Errorf("%w: only %d of '%s' left", domain.ErrProductUnavailable, *product.Stock, product.Name)
+		}
+	}
+	for id, quantity := range quantities {
+		if left, tracked := r.stock[id]; tracked {
+			r.stock[id] = left - quantity
+		}
+	}
+	return nil
+}
+
+// ReleaseStock adds the quantities back for products whose stock is tracked.
+func (r *inMemoryProductRepository) ReleaseStock(quantities map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, quantity := range quantities {
+		if left, tracked := r.stock[id]; tracked {
+			r.stock[id] = left + quantity
+		}
+	}
+}
+
+// withStock fills in the stock count of a product; callers hold the lock. Every product gets its
+// own copy, so callers cannot change the stock through it.
+func (r *inMemoryProductRepository) withStock(product domain.Product) domain.Product {
+	if left, tracked := r.stock[product.ID]; tracked {
+		product.Stock = &left
+	}
+	return product
 }
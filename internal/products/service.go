@@ -1,13 +1,21 @@
 package products
 
 import (
+	"fmt"
 	"kart-challenge/internal/domain" // Corrected import path
+	"log"
 )
 
 // Service defines the interface for product business logic.
 type Service interface {
 	GetAllProducts() []domain.Product
 	GetProductByID(id string) (domain.Product, bool)
+	// SetAvailability marks a product available or sold out, e.g. when the kitchen runs out of it.
+	SetAvailability(id string, req domain.SetAvailabilityRequest) (domain.Product, error)
+	// ReserveStock takes ordered quantities (product ID -> units) out of stock, all or nothing.
+	ReserveStock(quantities map[string]int) error
+	// ReleaseStock puts the quantities of an order that will not be served back into stock.
+	ReleaseStock(quantities map[string]int)
 }
 
 // ProductService implements the Service interface.
@@ -36,3 +44,34 @@ func (s *ProductService) GetAllProducts() []domain.Product {
 func (s *ProductService) GetProductByID(id string) (domain.Product, bool) {
 	return s.repo.GetByID(id)
 }
+
+// SetAvailability validates and applies an availability change.
+func (s *ProductService) SetAvailability(id string, req domain.SetAvailabilityRequest) (domain.Product, error) {
+	if req.Stock != nil && *req.Stock < 0 {
+		return domain.Product{}, domain.ErrInvalidStock
+	}
+	if req.Stock != nil && req.UntrackStock {
+		return domain.Product{}, domain.ErrConflictingStock
+	}
+	product, err := s.repo.SetAvailability(id, req.Available, req.Stock, req.UntrackStock)
+	if err != nil {
+		return domain.Product{}, err
+	}
+
+	stock := "untracked"
+	if product.Stock != nil {
+		stock = fmt.Sprintf("%d left", *product.Stock)
+	}
+	log.Printf("Product %s: available=%t, stock %s", id, product.Available, stock)
+	return product, nil
+}
+
+// ReserveStock takes ordered quantities out of stock.
+func (s *ProductService) ReserveStock(quantities map[string]int) error {
+	return s.repo.ReserveStock(quantities)
+}
+
+// ReleaseStock puts quantities back into stock.
+func (s *ProductService) ReleaseStock(quantities map[string]int) {
+	s.repo.ReleaseStock(quantities)
+}
@@ -1,7 +1,9 @@
 package products
 
 import (
+	"errors"
 	"kart-challenge/internal/domain"
+	"strings"
 	"testing"
 )
 
@@ -23,10 +25,29 @@ func (m *mockProductRepository) GetByID(id string) (domain.Product, bool) {
 	return product, found
 }
 
+func (m *mockProductRepository) SetAvailability(id string, available *bool, stock *int, untrack bool) (domain.Product, error) {
+	product, found := m.products[id]
+	if !found {
+		return domain.Product{}, domain.ErrProductNotFound
+	}
+	if available != nil {
+		product.Available = *available
+	}
+	if stock != nil || untrack {
+		product.Stock = stock
+	}
+	m.products[id] = product
+	return product, nil
+}
+
+func (m *mockProductRepository) ReserveStock(quantities map[string]int) error { return nil }
+
+func (m *mockProductRepository) ReleaseStock(quantities map[string]int) {}
+
 func TestProductService_GetAllProducts(t *testing.T) {
 	mockProducts := map[string]domain.Product{
-		"p1": {ID: "p1", Name: "Test Product 1", Price: 10.0},
-		"p2": {ID: "p2", Name: "Test Product 2", Price: 20.0},
+		"p1": {ID: "p1", Name: "Test Product 1", Price: 1000},
+		"p2": {ID: "p2", Name: "Test Product 2", Price: 2000},
 	}
 	mockRepo := &mockProductRepository{products: mockProducts}
 	service := NewService(mockRepo)
@@ -53,8 +74,8 @@ func TestProductService_GetAllProducts(t *testing.T) {
 
 func TestProductService_GetProductByID(t *testing.T) {
 	mockProducts := map[string]domain.Product{
-		"p1": {ID: "p1", Name: "Test Product 1", Price: 10.0},
-		"p2": {ID: "p2", Name: "Test Product 2", Price: 20.0},
+		"p1": {ID: "p1", Name: "Test Product 1", Price: 1000},
+		"p2": {ID: "p2", Name: "Test Product 2", Price: 2000},
 	}
 	mockRepo := &mockProductRepository{products: mockProducts}
 	service := NewService(mockRepo)
@@ -81,3 +102,77 @@ func TestProductService_GetProductByID(t *testing.T) {
 		})
 	}
 }
+
+func TestProductService_Availability(t *testing.T) {
+	repo := &inMemoryProductRepository{
+		products: map[string]domain.Product{
+			"p1": {ID: "p1", Name: "Burger", Price: 1000, Available: true},
+			"p2": {ID: "p2", Name: "Fries", Price: 500, Available: true},
+		},
+		stock: make(map[string]int),
+	}
+	service := NewService(repo)
+	stock := func(n int) *int { return &n }
+	yes, no := new(bool), new(bool)
+	*yes = true
+
+	if _, err := service.SetAvailability("p1", domain.SetAvailabilityRequest{Available: yes, Stock: stock(3)}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := service.ReserveStock(map[string]int{"p1": 2, "p2": 10}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p, _ := service.GetProductByID("p1"); p.Stock == nil || *p.Stock != 1 {
+		t.Fatalf("Expected 1 burger left, got %v", p.Stock)
+	}
+
+	// A reservation that cannot be met in full takes nothing.
+	err := service.ReserveStock(map[string]int{"p1": 2, "p2": 1})
+	if !errors.Is(err, domain.ErrProductUnavailable) || !strings.Contains(err.Error(), "only 1 of 'Burger' left") {
+		t.Errorf("Expected the burger to be short, got %v", err)
+	}
+	if p, _ := service.GetProductByID("p1"); *p.Stock != 1 {
+		t.Errorf("Expected the failed reservation to leave the stock alone, got %d", *p.Stock)
+	}
+
+	service.ReleaseStock(map[string]int{"p1": 2, "p2": 1})
+	if p, _ := service.GetProductByID("p1"); *p.Stock != 3 {
+		t.Errorf("Expected 3 burgers after the release, got %d", *p.Stock)
+	}
+	if p, _ := service.GetProductByID("p2"); p.Stock != nil {
+		t.Errorf("Expected the fries not to be tracked, got %d", *p.Stock)
+	}
+
+	// The kitchen 86es the fries.
+	product, err := service.SetAvailability("p2", domain.SetAvailabilityRequest{Available: no})
+	if err != nil || product.Available {
+		t.Fatalf("Expected the fries to be sold out, got %+v (%v)", product, err)
+	}
+	if err := service.ReserveStock(map[string]int{"p2": 1}); !errors.Is(err, domain.ErrProductUnavailable) {
+		t.Errorf("Expected sold out fries to be unavailable, got %v", err)
+	}
+
+	// Toggling availability leaves the stock count alone; only untracking drops it.
+	if product, err := service.SetAvailability("p1", domain.SetAvailabilityRequest{Available: no}); err != nil || product.Stock == nil || *product.Stock != 3 {
+		t.Fatalf("Expected the burger count to be kept, got %+v (%v)", product, err)
+	}
+	if product, err := service.SetAvailability("p1", domain.SetAvailabilityRequest{Available: yes}); err != nil || !product.Available || product.Stock == nil || *product.Stock != 3 {
+		t.Fatalf("Expected 3 available burgers, got %+v (%v)", product, err)
+	}
+	if _, err := service.SetAvailability("p1", domain.SetAvailabilityRequest{Available: yes, Stock: stock(5), UntrackStock: true}); !errors.Is(err, domain.ErrConflictingStock) {
+		t.Errorf("Expected setting and untracking the stock at once to be rejected, got %v", err)
+	}
+	if product, err := service.SetAvailability("p1", domain.SetAvailabilityRequest{Available: yes, UntrackStock: true}); err != nil || product.Stock != nil {
+		t.Fatalf("Expected the burgers not to be tracked any more, got %+v (%v)", product, err)
+	}
+	if err := service.ReserveStock(map[string]int{"p1": 10}); err != nil {
+		t.Errorf("Expected untracked burgers to be unlimited, got %v", err)
+	}
+
+	if _, err := service.SetAvailability("p1", domain.SetAvailabilityRequest{Available: yes, Stock: stock(-1)}); !errors.Is(err, domain.ErrInvalidStock) {
+		t.Errorf("Expected a negative stock to be rejected, got %v", err)
+	}
+	if _, err := service.SetAvailability("p9", domain.SetAvailabilityRequest{Available: yes}); !errors.Is(err, domain.ErrProductNotFound) {
+		t.Errorf("Expected an unknown product, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package promos
+
+import (
+	"encoding/json"
+	"fmt"
+	"kart-challenge/internal/domain"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCampaigns is used when no campaign file is configured: every file-validated code is worth 10% off.
+var DefaultCampaigns = []domain.Campaign{
+	{ID: "default-10-percent", Name: "10% off", Type: domain.CampaignTypePercentage, PercentOff: 10, Default: true},
+}
+
+// LoadCampaignsFromFile reads and validates a JSON array of campaigns.
+func LoadCampaignsFromFile(path string) ([]domain.Campaign, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read campaign file '%s': %w", path, err)
+	}
+	var campaigns []domain.Campaign
+	if err := json.Unmarshal(raw, &campaigns); err != nil {
+		return nil, fmt.Errorf("failed to parse campaign file '%s': %w", path, err)
+	}
+	if err := ValidateCampaigns(campaigns); err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+// ValidateCampaigns validates every campaign and checks that no two share an ID, since redemptions,
+// usage reports and order discounts refer to campaigns by ID.
+func ValidateCampaigns(campaigns []domain.Campaign) error {
+	seen := make(map[string]bool, len(campaigns))
+	for _, c := range campaigns {
+		if err := ValidateCampaign(c); err != nil {
+			return err
+		}
+		if seen[c.ID] {
+			return fmt.Errorf("%w: campaign id '%s' is used more than once", domain.ErrInvalidCampaign, c.ID)
+		}
+		seen[c.ID] = true
+	}
+	return nil
+}
+
+// ValidateCampaign checks that a campaign has the fields its type needs.
+func ValidateCampaign(c domain.Campaign) error {
+	if c.ID == "" {
+		return fmt.Errorf("%w: campaign id is required", domain.ErrInvalidCampaign)
+	}
+	switch c.Type {
+	case domain.CampaignTypePercentage:
+		if c.PercentOff <= 0 || c.PercentOff > 100 {
+			return fmt.Errorf("%w: campaign '%s' percent_off must be in (0, 100]", domain.ErrInvalidCampaign, c.ID)
+		}
+	case domain.CampaignTypeFixedAmount:
+		if c.AmountOff <= 0 {
+			return fmt.Errorf("%w: campaign '%s' amount_off must be positive", domain.ErrInvalidCampaign, c.ID)
+		}
+	case domain.CampaignTypeFreeItem:
+		if c.FreeProductID == "" {
+			return fmt.Errorf("%w: campaign '%s' free_product_id is required", domain.ErrInvalidCampaign, c.ID)
+		}
+	case domain.CampaignTypeBuyXGetY:
+		if c.BuyQuantity <= 0 || c.GetQuantity <= 0 {
+			return fmt.Errorf("%w: campaign '%s' buy_quantity and get_quantity must be positive", domain.ErrInvalidCampaign, c.ID)
+		}
+	default:
+		return fmt.Errorf("%w: campaign '%s' has unknown type '%s'", domain.ErrInvalidCampaign, c.ID, c.Type)
+	}
+	if c.Schedule != nil {
+		if _, err := parseSchedule(*c.Schedule); err != nil {
+			return fmt.Errorf("%w: campaign '%s' schedule: %v", domain.ErrInvalidCampaign, c.ID, err)
+		}
+	}
+	if c.MaxUses < 0 || c.MaxUsesPerCustomer < 0 {
+		return fmt.Errorf("%w: campaign '%s' usage limits must not be negative", domain.ErrInvalidCampaign, c.ID)
+	}
+	if c.Conditions != nil && (c.Conditions.MinSubtotal < 0 || c.Conditions.MinQuantity < 0) {
+		return fmt.Errorf("%w: campaign '%s' conditions must not be negative", domain.ErrInvalidCampaign, c.ID)
+	}
+	if c.Target != nil && len(c.Target.ProductIDs) == 0 && len(c.Target.Categories) == 0 {
+		return fmt.Errorf("%w: campaign '%s' target needs product_ids or categories", domain.ErrInvalidCampaign, c.ID)
+	}
+	switch c.Stacking {
+	case "", domain.CampaignStackingExclusive, domain.CampaignStackingStackable:
+	default:
+		return fmt.Errorf("%w: campaign '%s' stacking must be '%s' or '%s'", domain.ErrInvalidCampaign, c.ID, domain.CampaignStackingExclusive, domain.CampaignStackingStackable)
+	}
+	matchesCodes := len(c.Codes) > 0 || c.CodePrefix != "" || c.Default
+	if c.Automatic {
+		if matchesCodes {
+			return fmt.Errorf("%w: automatic campaign '%s' cannot also match codes", domain.ErrInvalidCampaign, c.ID)
+		}
+		if c.MaxUses > 0 || c.MaxUsesPerCustomer > 0 || c.SingleUse {
+			return fmt.Errorf("%w: automatic campaign '%s' cannot have usage limits, they are counted per code", domain.ErrInvalidCampaign, c.ID)
+		}
+	} else if !matchesCodes {
+		return fmt.Errorf("%w: campaign '%s' matches no codes (set codes, code_prefix, default or automatic)", domain.ErrInvalidCampaign, c.ID)
+	}
+	return nil
+}
+
+// DiscountPreview describes what a campaign is worth, leaving out the codes it matches.
+func DiscountPreview(c domain.Campaign) *domain.PromoDiscountPreview {
+	return &domain.PromoDiscountPreview{
+		CampaignID:    c.ID,
+		CampaignName:  c.Name,
+		Type:          c.Type,
+		PercentOff:    c.PercentOff,
+		AmountOff:     c.AmountOff,
+		FreeProductID: c.FreeProductID,
+		ProductID:     c.ProductID,
+		BuyQuantity:   c.BuyQuantity,
+		GetQuantity:   c.GetQuantity,
+	}
+}
+
+// campaignCatalog resolves promo codes to campaigns. It is built once and read-only afterwards.
+type campaignCatalog struct {
+	byCode     map[string]domain.Campaign
+	byPrefix   []domain.Campaign // Longest prefix first
+	defaultCmp *domain.Campaign
+	automatic  []domain.Campaign
+	schedules  map[string]*campaignSchedule // Keyed by campaign ID, only for scheduled campaigns
+}
+
+func newCampaignCatalog(campaigns []domain.Campaign) *campaignCatalog {
+	catalog := &campaignCatalog{byCode: make(map[string]domain.Campaign), schedules: make(map[string]*campaignSchedule)}
+	for _, c := range campaigns {
+		if c.Schedule != nil {
+			schedule, err := parseSchedule(*c.Schedule)
+			if err != nil {
+				log.Printf("ERROR: Campaign '%s' has an invalid schedule and will never be active: %v", c.ID, err)
+				schedule = &campaignSchedule{invalid: err}
+			}
+			catalog.schedules[c.ID] = schedule
+		}
+		if c.Automatic {
+			catalog.automatic = append(catalog.automatic, c)
+			continue
+		}
+		for _, code := range c.Codes {
+			catalog.byCode[code] = c
+		}
+		if c.CodePrefix != "" {
+			catalog.byPrefix = append(catalog.byPrefix, c)
+		}
+		if c.Default && catalog.defaultCmp == nil {
+			c := c
+			catalog.defaultCmp = &c
+		}
+	}
+	sort.SliceStable(catalog.byPrefix, func(i, j int) bool {
+		return len(catalog.byPrefix[i].CodePrefix) > len(catalog.byPrefix[j].CodePrefix)
+	})
+	return catalog
+}
+
+func (c *campaignCatalog) resolve(code string) (domain.Campaign, bool) {
+	if campaign, ok := c.byCode[code]; ok {
+		return campaign, true
+	}
+	for _, campaign := range c.byPrefix {
+		if strings.HasPrefix(code, campaign.CodePrefix) {
+			return campaign, true
+		}
+	}
+	if c.defaultCmp != nil {
+		return *c.defaultCmp, true
+	}
+	return domain.Campaign{}, false
+}
+
+// activeAt reports whether a campaign's schedule allows redemptions at now. It returns an empty
+// reason when it does, otherwise the reason and a message explaining why.
+func (c *campaignCatalog) activeAt(campaign domain.Campaign, now time.Time) (string, string) {
+	schedule, ok := c.schedules[campaign.ID]
+	if !ok {
+		return "", ""
+	}
+	return schedule.activeAt(now)
+}
@@ -1,19 +1,58 @@
 package promos
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"kart-challenge/internal/domain"
+	"kart-challenge/internal/pricing"
+	"kart-challenge/internal/products"
+	"kart-challenge/pkg/middleware"
+	"kart-challenge/pkg/sse"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+const (
+	// loadStatusStreamInterval is how often the load status stream pushes a new snapshot.
+	loadStatusStreamInterval = time.Second
+	// defaultDiffLimit caps each list in a dataset diff unless the caller asks for more.
+	defaultDiffLimit = 1000
+	// maxBatchValidateCodes caps a JSON batch validation request, and is the chunk size used to
+	// validate an NDJSON stream.
+	maxBatchValidateCodes = 1000
+	ndjsonContentType     = "application/x-ndjson"
+)
+
+// Behaviours for validation requests that arrive before the promo code dataset has loaded.
+const (
+	NotLoadedReject     = "reject"     // 503 with Retry-After
+	NotLoadedOptimistic = "optimistic" // Accept and flag the code for re-check once loaded
+)
+
+// NotLoadedPolicy decides how validation requests are answered while the dataset is still loading.
+type NotLoadedPolicy struct {
+	Mode       string
+	RetryAfter time.Duration
+}
+
 type Handler struct {
-	Service Service
+	Service   Service
+	Products  products.Service // Resolves cart items sent for validation
+	NotLoaded NotLoadedPolicy
 }
 
-func NewHandler(service Service) *Handler {
+func NewHandler(service Service, productService products.Service, notLoaded NotLoadedPolicy) *Handler {
 	return &Handler{
-		Service: service,
+		Service:   service,
+		Products:  productService,
+		NotLoaded: notLoaded,
 	}
 }
 
@@ -28,18 +67,267 @@ func (h *Handler) ValidatePromoCode(c *fiber.Ctx) error {
 	}
 
 	if req.PromoteCode == "" {
-		c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
 			Message: "Promo code cannot be empty",
 		})
 	}
 
-	isValid, message := h.Service.ValidatePromoCode((req.PromoteCode))
+	if !h.Service.IsReady() {
+		return h.validateWhileLoading(c, req.PromoteCode)
+	}
+
+	var result domain.PromoValidationResult
+	if len(req.Items) > 0 {
+		lines, err := h.cartLines(req.Items)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			})
+		}
+		result = h.Service.ValidatePromoCodeForCart(req.PromoteCode, req.CustomerID, lines)
+	} else {
+		result = h.Service.ValidatePromoCode(req.PromoteCode, req.CustomerID)
+	}
+	if IsFailedGuess(result.Reason) {
+		middleware.ReportFailedAttempts(c, 1)
+	}
+	return c.Status(fiber.StatusOK).JSON(validationResponse(req.PromoteCode, result))
+}
 
-	return c.Status(fiber.StatusOK).JSON(domain.ValidatePromoCodeResponse{
-		Valid:     isValid,
-		Message:   message,
-		PromoCode: req.PromoteCode,
+// BatchValidatePromoCodes handles POST /promo_code/validate:batch.
+// A JSON body {"promo_codes": [...]} of up to maxBatchValidateCodes codes is answered with a JSON
+// object. An NDJSON body (Content-Type application/x-ndjson) with one {"promote_code": "..."} object
+// per line has no count limit of its own, but the whole body must fit within the server's body limit
+// (Fiber's default is 4 MB, roughly 100,000 codes). It is validated in chunks and answered with one
+// NDJSON result per line, in input order. Every invalid code counts as a failed guess.
+func (h *Handler) BatchValidatePromoCodes(c *fiber.Ctx) error {
+	if !h.Service.IsReady() && h.NotLoaded.Mode != NotLoadedOptimistic {
+		return h.rejectWhileLoading(c)
+	}
+	if strings.HasPrefix(string(c.Request().Header.ContentType()), ndjsonContentType) {
+		return h.streamBatchValidation(c)
+	}
+
+	req := new(domain.BatchValidatePromoCodesRequest)
+	if err := c.BodyParser(req); err != nil {
+		log.Printf("Error parsing batch promo code validation request: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: domain.ErrInvalidRequestPayload.Error() + ": Ensure 'promo_codes' is an array of strings.",
+		})
+	}
+	if len(req.PromoCodes) == 0 || len(req.PromoCodes) > maxBatchValidateCodes {
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: fmt.Sprintf("Between 1 and %d promo codes are required per request; use NDJSON for larger batches.", maxBatchValidateCodes),
+		})
+	}
+
+	reqs := make([]domain.ValidatePromoteCodeRequest, len(req.PromoCodes))
+	for i, code := range req.PromoCodes {
+		reqs[i] = domain.ValidatePromoteCodeRequest{PromoteCode: code, CustomerID: req.CustomerID}
+	}
+	results, err := h.validateBatch(c, reqs)
+	if err != nil {
+		log.Printf("Error validating promo code batch: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+			Message: domain.ErrInternalServerError.Error(),
+		})
+	}
+
+	response := domain.BatchValidatePromoCodesResponse{Results: results}
+	for _, result := range results {
+		if result.Valid {
+			response.Valid++
+		} else {
+			response.Invalid++
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// streamBatchValidation validates an NDJSON request body and answers with NDJSON. Every line is
+// parsed and validated before anything is written, so a malformed line or a lookup failure gets a
+// proper status code and failed guesses are reported to the brute-force middleware. The results are
+// then encoded and flushed to the client one chunk at a time rather than as a single buffer.
+func (h *Handler) streamBatchValidation(c *fiber.Ctx) error {
+	var reqs []domain.ValidatePromoteCodeRequest
+	body := c.Body()
+	for line := 1; len(body) > 0; line++ {
+		// Lines are cut from the body directly, so their length is only bounded by the body limit.
+		var raw []byte
+		raw, body, _ = bytes.Cut(body, []byte("\n"))
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		var req domain.ValidatePromoteCodeRequest
+		if err := json.Unmarshal(raw, &req); err != nil || req.PromoteCode == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+				Message: fmt.Sprintf("%s: line %d must be an object with a non-empty 'promote_code'.", domain.ErrInvalidRequestPayload, line),
+			})
+		}
+		reqs = append(reqs, req)
+	}
+
+	var chunks [][]domain.ValidatePromoCodeResponse
+	for start := 0; start < len(reqs); start += maxBatchValidateCodes {
+		results, err := h.validateBatch(c, reqs[start:min(start+maxBatchValidateCodes, len(reqs))])
+		if err != nil {
+			log.Printf("Error validating promo code stream: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+				Message: domain.ErrInternalServerError.Error(),
+			})
+		}
+		chunks = append(chunks, results)
+	}
+
+	c.Set(fiber.HeaderContentType, ndjsonContentType)
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		for _, results := range chunks {
+			for _, result := range results {
+				if err := encoder.Encode(result); err != nil {
+					log.Printf("Promo code validation stream closed: %v", err)
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				log.Printf("Promo code validation stream closed: %v", err)
+				return
+			}
+		}
 	})
+	return nil
+}
+
+// validateBatch validates a chunk of codes, or accepts them optimistically while the dataset is loading.
+// Invalid codes are reported to the brute-force middleware as failed guesses.
+func (h *Handler) validateBatch(c *fiber.Ctx, reqs []domain.ValidatePromoteCodeRequest) ([]domain.ValidatePromoCodeResponse, error) {
+	responses := make([]domain.ValidatePromoCodeResponse, len(reqs))
+	if !h.Service.IsReady() {
+		for i, req := range reqs {
+			h.Service.MarkForRecheck(req.PromoteCode)
+			responses[i] = optimisticResponse(req.PromoteCode)
+		}
+		return responses, nil
+	}
+
+	results, err := h.Service.ValidatePromoCodes(c.UserContext(), reqs)
+	if err != nil {
+		return nil, err
+	}
+	failed := 0
+	for i, result := range results {
+		responses[i] = validationResponse(reqs[i].PromoteCode, result)
+		if IsFailedGuess(result.Reason) {
+			failed++
+		}
+	}
+	middleware.ReportFailedAttempts(c, failed)
+	return responses, nil
+}
+
+// cartLines resolves the products of the cart items sent with a validation request. The cart is held
+// to the same quantity and total caps as an order, so the discount preview cannot overflow.
+func (h *Handler) cartLines(items []domain.OrderLineItem) ([]pricing.Line, error) {
+	lines := make([]pricing.Line, 0, len(items))
+	for _, item := range items {
+		product, found := h.Products.GetProductByID(item.ProductID)
+		if !found {
+			return nil, fmt.Errorf("%w: '%s'", domain.ErrProductNotFound, item.ProductID)
+		}
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: '%s'", domain.ErrInvalidQuantity, item.ProductID)
+		}
+		if item.Quantity > pricing.MaxLineQuantity {
+			return nil, fmt.Errorf("quantity for '%s' must not exceed %d", item.ProductID, pricing.MaxLineQuantity)
+		}
+		lines = append(lines, pricing.Line{Item: domain.OrderLineItem{ProductID: item.ProductID, Quantity: item.Quantity}, Product: product})
+	}
+	if !pricing.WithinMaxTotal(lines) {
+		return nil, fmt.Errorf("%w: the cart total must not exceed %s", domain.ErrOrderTotalTooLarge, pricing.MaxTotal)
+	}
+	return lines, nil
+}
+
+// IsFailedGuess reports whether a validation reason reveals that a code does not exist, which is what
+// an attacker enumerating codes learns from. Codes that exist but cannot be used right now do not count.
+func IsFailedGuess(reason string) bool {
+	switch reason {
+	case domain.PromoReasonTooShort, domain.PromoReasonTooLong, domain.PromoReasonNotEnoughSources:
+		return true
+	}
+	return false
+}
+
+// validationResponse converts a validation result into its API form.
+func validationResponse(code string, result domain.PromoValidationResult) domain.ValidatePromoCodeResponse {
+	response := domain.ValidatePromoCodeResponse{
+		Valid:      result.Valid,
+		Reason:     result.Reason,
+		Message:    result.Message,
+		Applicable: result.Applicable,
+		PromoCode:  code,
+	}
+	if result.Campaign != nil {
+		response.Discount = DiscountPreview(*result.Campaign)
+	}
+	return response
+}
+
+// optimisticResponse accepts a code that arrived before the dataset finished loading.
+func optimisticResponse(code string) domain.ValidatePromoCodeResponse {
+	return domain.ValidatePromoCodeResponse{
+		PromoCode:       code,
+		Valid:           true,
+		Reason:          domain.PromoReasonNotLoaded,
+		Message:         "Promo codes are still loading; the code was accepted and will be re-checked.",
+		RecheckRequired: true,
+	}
+}
+
+// validateWhileLoading answers a validation request according to the not-loaded policy.
+func (h *Handler) validateWhileLoading(c *fiber.Ctx, code string) error {
+	if h.NotLoaded.Mode == NotLoadedOptimistic {
+		h.Service.MarkForRecheck(code)
+		return c.Status(fiber.StatusOK).JSON(optimisticResponse(code))
+	}
+	return h.rejectWhileLoading(c)
+}
+
+// rejectWhileLoading answers 503 with a Retry-After hint while the dataset is loading.
+func (h *Handler) rejectWhileLoading(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(h.NotLoaded.RetryAfter.Seconds())))
+	return c.Status(fiber.StatusServiceUnavailable).JSON(domain.ErrorResponse{
+		Message: domain.ErrPromoCodesNotLoaded.Error(),
+		Code:    fiber.StatusServiceUnavailable,
+	})
+}
+
+// GetPromoCodeUsage handles GET /promo_code/:code/usage and reports the overall remaining uses of a code.
+// It is public, so it never reveals how often a particular customer has used the code.
+func (h *Handler) GetPromoCodeUsage(c *fiber.Ctx) error {
+	return h.promoCodeUsage(c, "")
+}
+
+// GetCustomerPromoCodeUsage handles GET /admin/promo_code/:code/usage?customer_id=... and adds the
+// uses and remaining uses of that customer.
+func (h *Handler) GetCustomerPromoCodeUsage(c *fiber.Ctx) error {
+	return h.promoCodeUsage(c, c.Query("customer_id"))
+}
+
+func (h *Handler) promoCodeUsage(c *fiber.Ctx, customerID string) error {
+	usage, err := h.Service.GetPromoCodeUsage(c.Params("code"), customerID)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidPromoCode) {
+			return c.Status(fiber.StatusNotFound).JSON(domain.ErrorResponse{Message: err.Error()})
+		}
+		log.Printf("Error reading promo code usage: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+			Message: domain.ErrInternalServerError.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(usage)
 }
 
 func (h *Handler) GetPromoCodeCountsHandlers(c *fiber.Ctx) error {
@@ -47,3 +335,87 @@ func (h *Handler) GetPromoCodeCountsHandlers(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(counts)
 }
+
+// GetLoadStatus handles GET /admin/promo_code/status and returns the current load progress.
+func (h *Handler) GetLoadStatus(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.Service.GetLoadStatus())
+}
+
+// GetRechecks handles GET /admin/promo_code/rechecks and lists the codes accepted while the dataset
+// was loading, with the result of their re-check once it has run.
+func (h *Handler) GetRechecks(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.Service.GetRechecks())
+}
+
+// StreamLoadStatus handles GET /admin/promo_code/status/stream.
+// It pushes a load status snapshot as a server-sent event every second while a load is running.
+// The stream ends after the first snapshot that shows no load in progress, or when the client
+// disconnects.
+func (h *Handler) StreamLoadStatus(c *fiber.Ctx) error {
+	sse.SetHeaders(c)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(loadStatusStreamInterval)
+		defer ticker.Stop()
+
+		for {
+			status := h.Service.GetLoadStatus()
+			if err := sse.Write(w, sse.Event{Event: "status", Data: status}); err != nil {
+				log.Printf("Promo load status stream closed: %v", err)
+				return
+			}
+			if status.State != domain.PromoLoadStateRunning {
+				return
+			}
+			<-ticker.C
+		}
+	})
+	return nil
+}
+
+// ListDatasetVersions handles GET /admin/promo_code/versions.
+func (h *Handler) ListDatasetVersions(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.Service.ListDatasetVersions())
+}
+
+// DiffDatasetVersions handles GET /admin/promo_code/versions/diff?from=1&to=2&limit=100.
+func (h *Handler) DiffDatasetVersions(c *fiber.Ctx) error {
+	from, to := c.QueryInt("from"), c.QueryInt("to")
+	if from <= 0 || to <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: "Query parameters 'from' and 'to' must be positive version IDs.",
+		})
+	}
+
+	diff, err := h.Service.DiffDatasetVersions(from, to, c.QueryInt("limit", defaultDiffLimit))
+	if err != nil {
+		return datasetVersionError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(diff)
+}
+
+// LookupCodeInVersion handles GET /admin/promo_code/versions/:version/codes/:code.
+func (h *Handler) LookupCodeInVersion(c *fiber.Ctx) error {
+	version, err := c.ParamsInt("version")
+	if err != nil || version <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(domain.ErrorResponse{
+			Message: "Version must be a positive integer.",
+		})
+	}
+
+	lookup, err := h.Service.LookupCodeInVersion(version, c.Params("code"))
+	if err != nil {
+		return datasetVersionError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(lookup)
+}
+
+func datasetVersionError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, domain.ErrDatasetVersionNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(domain.ErrorResponse{Message: err.Error()})
+	}
+	log.Printf("Error reading promo dataset versions: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(domain.ErrorResponse{
+		Message: domain.ErrInternalServerError.Error(),
+	})
+}
@@ -0,0 +1,300 @@
+package promos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"kart-challenge/internal/domain"
+	"kart-challenge/internal/products"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestHandler returns a handler over a fresh service that has not loaded any promo codes.
+func newTestHandler(t *testing.T, notLoaded NotLoadedPolicy, campaigns ...domain.Campaign) (*Handler, *PromoCodeService) {
+	t.Helper()
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production", Campaigns: campaigns})
+	t.Cleanup(func() { service.Close() })
+	return NewHandler(service, products.NewInMemoryProductService(), notLoaded), service.(*PromoCodeService)
+}
+
+// send posts body to the handler's validation routes and returns the status, headers and body.
+func send(t *testing.T, handler *Handler, path, contentType, body string) (int, map[string][]string, string) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/promo_code/validate", handler.ValidatePromoCode)
+	app.Post("/promo_code/validate\\:batch", handler.BatchValidatePromoCodes)
+
+	req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, contentType)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	payload, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header, string(payload)
+}
+
+func TestHandler_ValidatePromoCode_NotLoaded(t *testing.T) {
+	t.Run("Reject answers 503 with Retry-After", func(t *testing.T) {
+		handler, service := newTestHandler(t, NotLoadedPolicy{Mode: NotLoadedReject, RetryAfter: 30 * time.Second})
+
+		for _, path := range []string{"/promo_code/validate", "/promo_code/validate:batch"} {
+			status, header, body := send(t, handler, path, fiber.MIMEApplicationJSON, `{"promote_code":"HAPPYHRS","promo_codes":["HAPPYHRS"]}`)
+			if status != fiber.StatusServiceUnavailable {
+				t.Errorf("%s: expected 503 while loading, got %d: %s", path, status, body)
+			}
+			if got := header[fiber.HeaderRetryAfter]; !slices.Equal(got, []string{"30"}) {
+				t.Errorf("%s: expected Retry-After 30, got %v", path, got)
+			}
+		}
+		if pending := service.GetRechecks().Pending; len(pending) != 0 {
+			t.Errorf("Expected rejected codes not to be queued for re-check, got %v", pending)
+		}
+	})
+
+	t.Run("Optimistic accepts and queues a re-check", func(t *testing.T) {
+		handler, service := newTestHandler(t, NotLoadedPolicy{Mode: NotLoadedOptimistic, RetryAfter: 30 * time.Second})
+
+		status, _, body := send(t, handler, "/promo_code/validate", fiber.MIMEApplicationJSON, `{"promote_code":"HAPPYHRS"}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected 200 while loading, got %d: %s", status, body)
+		}
+		var response domain.ValidatePromoCodeResponse
+		if err := json.Unmarshal([]byte(body), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !response.Valid || !response.RecheckRequired || response.Reason != domain.PromoReasonNotLoaded || response.PromoCode != "HAPPYHRS" {
+			t.Errorf("Expected an optimistic acceptance, got %+v", response)
+		}
+
+		status, _, body = send(t, handler, "/promo_code/validate:batch", fiber.MIMEApplicationJSON, `{"promo_codes":["FIFTYOFF"]}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected 200 for a batch while loading, got %d: %s", status, body)
+		}
+		var batch domain.BatchValidatePromoCodesResponse
+		if err := json.Unmarshal([]byte(body), &batch); err != nil {
+			t.Fatalf("Failed to decode batch response: %v", err)
+		}
+		if batch.Valid != 1 || len(batch.Results) != 1 || !batch.Results[0].RecheckRequired {
+			t.Errorf("Expected the batch code to be accepted optimistically, got %+v", batch)
+		}
+
+		if pending := service.GetRechecks().Pending; !slices.Equal(pending, []string{"FIFTYOFF", "HAPPYHRS"}) {
+			t.Errorf("Expected both codes queued for re-check, got %v", pending)
+		}
+	})
+}
+
+// loadCounts makes the service ready with the given source counts.
+func loadCounts(service *PromoCodeService, counts map[string]int) {
+	repo := service.repo.(*inMemoryPromoCodeRepository)
+	repo.mu.Lock()
+	for code, count := range counts {
+		repo.promoCodeCounts[code] = count
+	}
+	repo.mu.Unlock()
+	service.ready.Store(true)
+}
+
+func TestHandler_BatchValidatePromoCodes(t *testing.T) {
+	handler, service := newTestHandler(t, NotLoadedPolicy{Mode: NotLoadedReject})
+	loadCounts(service, map[string]int{"HAPPYHRS": 2, "FIFTYOFF": 3, "SINGLEFILE": 1})
+
+	t.Run("JSON results follow the request order", func(t *testing.T) {
+		status, _, body := send(t, handler, "/promo_code/validate:batch", fiber.MIMEApplicationJSON,
+			`{"promo_codes":["FIFTYOFF","SHORT","SINGLEFILE","HAPPYHRS"]}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", status, body)
+		}
+		var response domain.BatchValidatePromoCodesResponse
+		if err := json.Unmarshal([]byte(body), &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		expected := []struct {
+			code   string
+			reason string
+		}{
+			{"FIFTYOFF", domain.PromoReasonValid},
+			{"SHORT", domain.PromoReasonTooShort},
+			{"SINGLEFILE", domain.PromoReasonNotEnoughSources},
+			{"HAPPYHRS", domain.PromoReasonValid},
+		}
+		if len(response.Results) != len(expected) || response.Valid != 2 || response.Invalid != 2 {
+			t.Fatalf("Expected 2 valid and 2 invalid results, got %+v", response)
+		}
+		for i, want := range expected {
+			if got := response.Results[i]; got.PromoCode != want.code || got.Reason != want.reason {
+				t.Errorf("Result %d: expected %s with %s, got %s with %s", i, want.code, want.reason, got.PromoCode, got.Reason)
+			}
+		}
+	})
+
+	t.Run("JSON batches are capped", func(t *testing.T) {
+		codes := make([]string, maxBatchValidateCodes+1)
+		for i := range codes {
+			codes[i] = "HAPPYHRS"
+		}
+		payload, _ := json.Marshal(domain.BatchValidatePromoCodesRequest{PromoCodes: codes})
+		if status, _, body := send(t, handler, "/promo_code/validate:batch", fiber.MIMEApplicationJSON, string(payload)); status != fiber.StatusBadRequest {
+			t.Errorf("Expected 400 for %d codes, got %d: %s", len(codes), status, body)
+		}
+
+		payload, _ = json.Marshal(domain.BatchValidatePromoCodesRequest{PromoCodes: codes[:maxBatchValidateCodes]})
+		if status, _, body := send(t, handler, "/promo_code/validate:batch", fiber.MIMEApplicationJSON, string(payload)); status != fiber.StatusOK {
+			t.Errorf("Expected 200 for %d codes, got %d", maxBatchValidateCodes, status)
+		} else if n := strings.Count(body, `"promo_code":"HAPPYHRS"`); n != maxBatchValidateCodes {
+			t.Errorf("Expected %d results, got %d", maxBatchValidateCodes, n)
+		}
+	})
+
+	t.Run("NDJSON answers one line per code across chunks", func(t *testing.T) {
+		// More codes than one chunk, so the answer spans several validation rounds.
+		var input strings.Builder
+		total := maxBatchValidateCodes + 5
+		for i := 0; i < total; i++ {
+			code := "HAPPYHRS"
+			if i%2 == 1 {
+				code = "SINGLEFILE"
+			}
+			fmt.Fprintf(&input, "{\"promote_code\":%q}\n", code)
+			if i == 10 {
+				input.WriteString("\n") // Blank lines are skipped
+			}
+		}
+		status, header, body := send(t, handler, "/promo_code/validate:batch", ndjsonContentType, input.String())
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", status, body)
+		}
+		if got := header[fiber.HeaderContentType]; !slices.Equal(got, []string{ndjsonContentType}) {
+			t.Errorf("Expected an NDJSON response, got %v", got)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+		if len(lines) != total {
+			t.Fatalf("Expected %d result lines, got %d", total, len(lines))
+		}
+		for i, line := range lines {
+			var result domain.ValidatePromoCodeResponse
+			if err := json.Unmarshal([]byte(line), &result); err != nil {
+				t.Fatalf("Line %d is not JSON: %v", i+1, err)
+			}
+			wantCode, wantValid := "HAPPYHRS", true
+			if i%2 == 1 {
+				wantCode, wantValid = "SINGLEFILE", false
+			}
+			if result.PromoCode != wantCode || result.Valid != wantValid {
+				t.Fatalf("Line %d: expected %s (valid %t), got %+v", i+1, wantCode, wantValid, result)
+			}
+		}
+	})
+
+	t.Run("NDJSON rejects a malformed line", func(t *testing.T) {
+		input := "{\"promote_code\":\"HAPPYHRS\"}\n{\"promote_code\":\n{\"promote_code\":\"FIFTYOFF\"}\n"
+		status, _, body := send(t, handler, "/promo_code/validate:batch", ndjsonContentType, input)
+		if status != fiber.StatusBadRequest || !strings.Contains(body, "line 2") {
+			t.Errorf("Expected 400 naming line 2, got %d: %s", status, body)
+		}
+
+		status, _, body = send(t, handler, "/promo_code/validate:batch", ndjsonContentType, "{\"promote_code\":\"\"}\n")
+		if status != fiber.StatusBadRequest || !strings.Contains(body, "line 1") {
+			t.Errorf("Expected 400 for an empty code, got %d: %s", status, body)
+		}
+	})
+}
+
+func TestHandler_StreamLoadStatus(t *testing.T) {
+	handler, _ := newTestHandler(t, NotLoadedPolicy{Mode: NotLoadedReject})
+	app := fiber.New()
+	app.Get("/status/stream", handler.StreamLoadStatus)
+
+	// No load is running, so the stream sends one snapshot and ends instead of ticking forever.
+	done := make(chan string, 1)
+	go func() {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/status/stream", nil), -1)
+		if err != nil {
+			t.Errorf("Request failed: %v", err)
+			done <- ""
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+
+	select {
+	case body := <-done:
+		if n := strings.Count(body, "event: status\n"); n != 1 {
+			t.Errorf("Expected exactly one status event, got %d in %q", n, body)
+		}
+		if !strings.Contains(body, `"state":"idle"`) {
+			t.Errorf("Expected the idle state in the snapshot, got %q", body)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the stream to end while no load is running")
+	}
+}
+
+func TestHandler_GetPromoCodeUsage(t *testing.T) {
+	handler, service := newTestHandler(t, NotLoadedPolicy{Mode: NotLoadedReject},
+		domain.Campaign{ID: "per-customer", Type: domain.CampaignTypePercentage, PercentOff: 10, MaxUsesPerCustomer: 1, Codes: []string{"WELCOME01"}})
+	if _, err := service.ReserveRedemption("WELCOME01", "alice"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	app := fiber.New()
+	app.Get("/promo_code/:code/usage", handler.GetPromoCodeUsage)
+	app.Get("/admin/promo_code/:code/usage", handler.GetCustomerPromoCodeUsage)
+
+	usageOf := func(path string) domain.PromoCodeUsage {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil || resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %v (err %v)", path, resp, err)
+		}
+		var usage domain.PromoCodeUsage
+		if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+			t.Fatalf("Failed to decode usage: %v", err)
+		}
+		return usage
+	}
+
+	if usage := usageOf("/promo_code/WELCOME01/usage?customer_id=alice"); usage.Used != 1 || usage.CustomerID != "" || usage.CustomerUsed != 0 || usage.CustomerRemaining != nil {
+		t.Errorf("Expected the public route to leave out the customer's usage, got %+v", usage)
+	}
+	if usage := usageOf("/admin/promo_code/WELCOME01/usage?customer_id=alice"); usage.CustomerID != "alice" || usage.CustomerUsed != 1 || usage.CustomerRemaining == nil || *usage.CustomerRemaining != 0 {
+		t.Errorf("Expected the admin route to report alice's usage, got %+v", usage)
+	}
+}
+
+func TestHandler_ValidatePromoCode_CartCaps(t *testing.T) {
+	handler, service := newTestHandler(t, NotLoadedPolicy{Mode: NotLoadedReject})
+	service.ready.Store(true)
+
+	manyLines := make([]string, 80)
+	for i := range manyLines {
+		manyLines[i] = `{"product_id":"prod1","quantity":10000}`
+	}
+	tests := []struct {
+		name    string
+		items   string
+		status  int
+		message string
+	}{
+		{"Ordinary cart", `[{"product_id":"prod1","quantity":2}]`, fiber.StatusOK, ""},
+		{"Quantity over the line cap", `[{"product_id":"prod1","quantity":4611686018427387904}]`, fiber.StatusBadRequest, "must not exceed 10000"},
+		{"Total over the cap", "[" + strings.Join(manyLines, ",") + "]", fiber.StatusBadRequest, "cart total must not exceed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := send(t, handler, "/promo_code/validate", fiber.MIMEApplicationJSON, `{"promote_code":"HAPPYHRS","items":`+tt.items+`}`)
+			if status != tt.status || !strings.Contains(body, tt.message) {
+				t.Errorf("Expected %d mentioning %q, got %d: %s", tt.status, tt.message, status, body)
+			}
+		})
+	}
+}
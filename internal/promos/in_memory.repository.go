@@ -1,6 +1,7 @@
 package promos
 
 import (
+	"context"
 	"sync"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
@@ -25,6 +26,19 @@ func (r *inMemoryPromoCodeRepository) GetCount(code string) (int, bool) {
 	return count, exists
 }
 
+func (r *inMemoryPromoCodeRepository) GetCounts(ctx context.Context, codes []string) (map[string]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[string]int, len(codes))
+	for _, code := range codes {
+		if count, exists := r.promoCodeCounts[code]; exists {
+			counts[code] = count
+		}
+	}
+	return counts, nil
+}
+
 func (r *inMemoryPromoCodeRepository) IncrementCount(code string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -53,7 +67,10 @@ func (r *inMemoryPromoCodeRepository) GetAllCounts() map[string]int {
 
 // BulkIncrement increments counts for multiple promo codes atomically.
 // It acquires a single write lock for the entire batch.
-func (r *inMemoryPromoCodeRepository) BulkIncrement(codes map[string]int) error {
+func (r *inMemoryPromoCodeRepository) BulkIncrement(ctx context.Context, codes map[string]int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for code, count := range codes {
@@ -0,0 +1,203 @@
+package promos
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"kart-challenge/internal/domain"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const maxSignatureSize = 4096 // Detached signatures are 64 bytes; anything much larger is not a signature
+
+// SourceIntegrity declares how a single coupon source is verified before its codes are trusted.
+// Either field may be set; when both are, both must pass.
+type SourceIntegrity struct {
+	SHA256       string // Expected hex SHA-256 of the compressed (.gz) file
+	SignatureURL string // Location of a detached Ed25519ph signature over the compressed file
+}
+
+// IntegrityConfig holds the verification settings for all coupon sources.
+type IntegrityConfig struct {
+	Sources       map[string]SourceIntegrity // Keyed by source file name, e.g. "couponbase1.gz"
+	PublicKey     ed25519.PublicKey          // Verifies detached signatures
+	QuarantineDir string                     // Where downloads that fail verification are kept for inspection
+}
+
+// sourceDigests hashes a compressed source as it is read.
+// SHA-256 is the recorded fingerprint; SHA-512 is the prehash for Ed25519ph signatures.
+type sourceDigests struct {
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
+
+func newSourceDigests() *sourceDigests {
+	return &sourceDigests{sha256: sha256.New(), sha512: sha512.New()}
+}
+
+func (d *sourceDigests) Write(p []byte) (int, error) {
+	d.sha256.Write(p)
+	d.sha512.Write(p)
+	return len(p), nil
+}
+
+// SHA256 returns the hex encoded SHA-256 of everything written so far.
+func (d *sourceDigests) SHA256() string {
+	return hex.EncodeToString(d.sha256.Sum(nil))
+}
+
+// spoolToTempFile copies a compressed source to a temporary file, hashing it on the way.
+func spoolToTempFile(r io.Reader, digests *sourceDigests, fileIndex int) (string, error) {
+	rawFile, err := os.CreateTemp("", fmt.Sprintf("couponbase%d-*.gz.tmp", fileIndex+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for download: %w", err)
+	}
+	defer rawFile.Close()
+
+	if _, err := io.Copy(io.MultiWriter(rawFile, digests), r); err != nil {
+		os.Remove(rawFile.Name())
+		return "", fmt.Errorf("failed to download GZ file: %w", err)
+	}
+	return rawFile.Name(), nil
+}
+
+// verifySource checks the digests of a downloaded source against its declared checksum and signature.
+// It returns the verification method that passed.
+func (s *PromoCodeService) verifySource(ctx context.Context, url string, expected SourceIntegrity, digests *sourceDigests) (string, error) {
+	method := domain.PromoVerificationNone
+
+	if expected.SHA256 != "" {
+		actual := digests.SHA256()
+		if !strings.EqualFold(actual, expected.SHA256) {
+			return "", fmt.Errorf("%w: SHA-256 mismatch (expected %s, got %s)", domain.ErrSourceVerificationFailed, strings.ToLower(expected.SHA256), actual)
+		}
+		method = domain.PromoVerificationSHA256
+	}
+
+	if expected.SignatureURL != "" {
+		if len(s.integrity.PublicKey) != ed25519.PublicKeySize {
+			return "", fmt.Errorf("%w: signature declared but no valid public key is configured", domain.ErrSourceVerificationFailed)
+		}
+		sig, err := s.fetchSignature(ctx, expected.SignatureURL)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", domain.ErrSourceVerificationFailed, err)
+		}
+		opts := &ed25519.Options{Hash: crypto.SHA512}
+		if err := ed25519.VerifyWithOptions(s.integrity.PublicKey, digests.sha512.Sum(nil), sig, opts); err != nil {
+			return "", fmt.Errorf("%w: invalid signature for %s", domain.ErrSourceVerificationFailed, url)
+		}
+		method = domain.PromoVerificationSignature
+	}
+
+	return method, nil
+}
+
+// fetchSignature reads a detached signature, preferring a local copy in development like the sources themselves.
+// Signatures may be stored raw (64 bytes) or base64 encoded.
+func (s *PromoCodeService) fetchSignature(ctx context.Context, location string) ([]byte, error) {
+	var reader io.ReadCloser
+
+	if s.environment == "development" {
+		localPath := filepath.Join(s.localCouponDirPath, filepath.Base(location))
+		if file, err := os.Open(localPath); err == nil {
+			reader = file
+		}
+	}
+	if reader == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build signature request: %w", err)
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch signature: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("signature server returned status code %d", resp.StatusCode)
+		}
+		reader = resp.Body
+	}
+	defer reader.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(reader, maxSignatureSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	if len(raw) == ed25519.SignatureSize {
+		return raw, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signature is neither raw nor base64 encoded Ed25519")
+	}
+	return sig, nil
+}
+
+// quarantineSource moves a download that failed verification into the quarantine directory,
+// alongside a note describing why it was rejected.
+func (s *PromoCodeService) quarantineSource(fileIndex int, url, rawPath string, digests *sourceDigests, cause error) {
+	dir := s.integrity.QuarantineDir
+	if dir == "" {
+		log.Printf("WARN: No quarantine directory configured, discarding unverified file %d (%s)", fileIndex+1, url)
+		return
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		log.Printf("ERROR: Failed to create quarantine directory '%s': %v", dir, err)
+		return
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	target := filepath.Join(dir, fmt.Sprintf("%s.%s.quarantined", filepath.Base(url), stamp))
+	if err := moveFile(rawPath, target); err != nil {
+		log.Printf("ERROR: Failed to quarantine file %d (%s): %v", fileIndex+1, url, err)
+		return
+	}
+
+	note := fmt.Sprintf("source: %s\nsha256: %s\nquarantined_at: %s\nreason: %v\n", url, digests.SHA256(), stamp, cause)
+	if err := os.WriteFile(target+".txt", []byte(note), 0o640); err != nil {
+		log.Printf("WARN: Failed to write quarantine note for %s: %v", target, err)
+	}
+	s.tracker.SetQuarantined(fileIndex, target)
+	log.Printf("WARN: File %d (%s) failed verification and was quarantined at %s", fileIndex+1, url, target)
+}
+
+// moveFile renames src to dst, falling back to copy and delete when they are on different filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,269 @@
+package promos
+
+import (
+	"io"
+	"kart-challenge/internal/domain"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadTracker records the progress of a promo code load so it can be inspected while it runs.
+// Counters are updated atomically from the file workers; phase changes take the mutex.
+type LoadTracker struct {
+	mu          sync.RWMutex
+	state       string
+	startedAt   time.Time
+	finishedAt  time.Time
+	uniqueCodes int
+	err         string
+	sources     []*sourceProgress
+}
+
+type sourceProgress struct {
+	source         string
+	origin         string
+	phase          string
+	phaseStartedAt time.Time
+	startedAt      time.Time
+	err            string
+	codesFound     int
+	sha256         string
+	verification   string
+	quarantinePath string
+
+	bytesProcessed    atomic.Int64
+	bytesTotal        atomic.Int64
+	bytesDecompressed atomic.Int64
+	linesScanned      atomic.Int64
+	updatedAt         atomic.Int64 // unix nanos
+}
+
+// NewLoadTracker creates a tracker in the idle state.
+func NewLoadTracker() *LoadTracker {
+	return &LoadTracker{state: domain.PromoLoadStateIdle}
+}
+
+// Start resets the tracker for a new load over the given sources.
+func (t *LoadTracker) Start(sources []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.state = domain.PromoLoadStateRunning
+	t.startedAt = now
+	t.finishedAt = time.Time{}
+	t.uniqueCodes = 0
+	t.err = ""
+	t.sources = make([]*sourceProgress, len(sources))
+	for i, src := range sources {
+		sp := &sourceProgress{source: src, phase: domain.PromoLoadPhasePending}
+		sp.updatedAt.Store(now.UnixNano())
+		t.sources[i] = sp
+	}
+}
+
+// Finish marks the load as completed, or failed when err is non-nil.
+func (t *LoadTracker) Finish(uniqueCodes int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.finishedAt = time.Now()
+	t.uniqueCodes = uniqueCodes
+	if err != nil {
+		t.state = domain.PromoLoadStateFailed
+		t.err = err.Error()
+		return
+	}
+	t.state = domain.PromoLoadStateCompleted
+}
+
+// SetOrigin records whether a source is being read from local disk or downloaded.
+func (t *LoadTracker) SetOrigin(index int, origin string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sp := t.source(index); sp != nil {
+		sp.origin = origin
+	}
+}
+
+// SetPhase moves a source into a new phase and resets the per-phase byte counters.
+// total is the number of bytes the phase is expected to process, or 0 if unknown.
+func (t *LoadTracker) SetPhase(index int, phase string, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sp := t.source(index)
+	if sp == nil {
+		return
+	}
+	now := time.Now()
+	if sp.startedAt.IsZero() {
+		sp.startedAt = now
+	}
+	sp.phase = phase
+	sp.phaseStartedAt = now
+	sp.bytesProcessed.Store(0)
+	sp.bytesTotal.Store(total)
+	sp.updatedAt.Store(now.UnixNano())
+}
+
+// SetCodesFound records the number of distinct candidate codes a source produced.
+func (t *LoadTracker) SetCodesFound(index int, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sp := t.source(index); sp != nil {
+		sp.codesFound = n
+	}
+}
+
+// SetDigest records the SHA-256 of a source and how (if at all) it was verified.
+func (t *LoadTracker) SetDigest(index int, sha256 string, verification string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sp := t.source(index); sp != nil {
+		sp.sha256 = sha256
+		sp.verification = verification
+	}
+}
+
+// SetQuarantined records where a source that failed verification was moved to.
+func (t *LoadTracker) SetQuarantined(index int, path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sp := t.source(index); sp != nil {
+		sp.quarantinePath = path
+	}
+}
+
+// FailSource marks a single source as failed without ending the overall load.
+func (t *LoadTracker) FailSource(index int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sp := t.source(index)
+	if sp == nil {
+		return
+	}
+	sp.phase = domain.PromoLoadPhaseFailed
+	sp.err = err.Error()
+	sp.updatedAt.Store(time.Now().UnixNano())
+}
+
+// AddBytes adds to the bytes processed in the current phase of a source.
+func (t *LoadTracker) AddBytes(index int, n int64) {
+	if sp := t.sourceRLocked(index); sp != nil {
+		sp.bytesProcessed.Add(n)
+		sp.updatedAt.Store(time.Now().UnixNano())
+	}
+}
+
+// AddDecompressed adds to the total decompressed bytes written for a source.
+func (t *LoadTracker) AddDecompressed(index int, n int64) {
+	if sp := t.sourceRLocked(index); sp != nil {
+		sp.bytesDecompressed.Add(n)
+	}
+}
+
+// AddLines adds to the number of lines scanned for a source.
+func (t *LoadTracker) AddLines(index int, n int64) {
+	if sp := t.sourceRLocked(index); sp != nil {
+		sp.linesScanned.Add(n)
+	}
+}
+
+// Reader wraps r so every read is counted against the current phase of a source.
+func (t *LoadTracker) Reader(index int, r io.Reader) io.Reader {
+	return &progressReader{r: r, onRead: func(n int) { t.AddBytes(index, int64(n)) }}
+}
+
+// Snapshot returns the current load status, including throughput and ETA for each source.
+func (t *LoadTracker) Snapshot() domain.PromoLoadStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	status := domain.PromoLoadStatus{
+		State:       t.state,
+		UniqueCodes: t.uniqueCodes,
+		Error:       t.err,
+		Sources:     make([]domain.PromoSourceProgress, 0, len(t.sources)),
+	}
+	if !t.startedAt.IsZero() {
+		startedAt := t.startedAt
+		status.StartedAt = &startedAt
+	}
+	if !t.finishedAt.IsZero() {
+		finishedAt := t.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+
+	now := time.Now()
+	for _, sp := range t.sources {
+		progress := domain.PromoSourceProgress{
+			Source:            sp.source,
+			Origin:            sp.origin,
+			Phase:             sp.phase,
+			BytesProcessed:    sp.bytesProcessed.Load(),
+			BytesTotal:        sp.bytesTotal.Load(),
+			BytesDecompressed: sp.bytesDecompressed.Load(),
+			LinesScanned:      sp.linesScanned.Load(),
+			CodesFound:        sp.codesFound,
+			SHA256:            sp.sha256,
+			Verification:      sp.verification,
+			QuarantinePath:    sp.quarantinePath,
+			Error:             sp.err,
+		}
+		if !sp.startedAt.IsZero() {
+			startedAt := sp.startedAt
+			progress.StartedAt = &startedAt
+		}
+		updatedAt := time.Unix(0, sp.updatedAt.Load())
+		progress.UpdatedAt = &updatedAt
+
+		if elapsed := now.Sub(sp.phaseStartedAt).Seconds(); !sp.phaseStartedAt.IsZero() && elapsed > 0 {
+			progress.ThroughputBps = float64(progress.BytesProcessed) / elapsed
+			remaining := progress.BytesTotal - progress.BytesProcessed
+			if progress.BytesTotal > 0 && remaining >= 0 && progress.ThroughputBps > 0 && isActivePhase(sp.phase) {
+				eta := float64(remaining) / progress.ThroughputBps
+				progress.ETASeconds = &eta
+			}
+		}
+		status.Sources = append(status.Sources, progress)
+	}
+	return status
+}
+
+func (t *LoadTracker) source(index int) *sourceProgress {
+	if index < 0 || index >= len(t.sources) {
+		return nil
+	}
+	return t.sources[index]
+}
+
+func (t *LoadTracker) sourceRLocked(index int) *sourceProgress {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.source(index)
+}
+
+func isActivePhase(phase string) bool {
+	switch phase {
+	case domain.PromoLoadPhaseDownload, domain.PromoLoadPhaseDecompress, domain.PromoLoadPhaseScan:
+		return true
+	}
+	return false
+}
+
+// progressReader reports the number of bytes read through it.
+type progressReader struct {
+	r      io.Reader
+	onRead func(n int)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.onRead(n)
+	}
+	return n, err
+}
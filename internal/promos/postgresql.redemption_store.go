@@ -0,0 +1,176 @@
+package promos
+
+import (
+	"database/sql"
+	"fmt"
+	"kart-challenge/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq" // PostgreSQL array support
+)
+
+// DefaultReservationTimeout is how long a reservation holds a use when no timeout is configured.
+const DefaultReservationTimeout = 5 * time.Minute
+
+// postgresRedemptionStore keeps redemptions in PostgreSQL, so usage limits hold across restarts and
+// between server instances. Reservations of a code are serialised with a transaction-scoped advisory
+// lock on the code, so the limit check and the insert act as one step.
+//
+// A reservation that is neither committed nor released within the reservation timeout, e.g. because
+// the server crashed in between, stops counting against the limits and can no longer be committed.
+// Expired reservations of a code are deleted the next time the code is reserved.
+type postgresRedemptionStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewPostgresRedemptionStore creates a redemption store on an open database and ensures its table
+// exists. A reservationTimeout that is not positive means DefaultReservationTimeout.
+func NewPostgresRedemptionStore(db *sql.DB, reservationTimeout time.Duration) (RedemptionStore, error) {
+	if reservationTimeout <= 0 {
+		reservationTimeout = DefaultReservationTimeout
+	}
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS promo_redemptions (
+		reservation_id TEXT PRIMARY KEY,
+		code TEXT NOT NULL,
+		customer_id TEXT NOT NULL DEFAULT '',
+		order_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS promo_redemptions_code_idx ON promo_redemptions (code, customer_id);
+	CREATE INDEX IF NOT EXISTS promo_redemptions_order_idx ON promo_redemptions (order_id) WHERE order_id <> '';`
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return nil, fmt.Errorf("failed to create promo_redemptions table: %w", err)
+	}
+	return &postgresRedemptionStore{db: db, timeout: reservationTimeout}, nil
+}
+
+// heldSQL matches the rows that count as used: redemptions and unexpired reservations. The timeout in
+// seconds is the query argument numbered n.
+func heldSQL(n int) string {
+	return fmt.Sprintf("(order_id <> '' OR created_at > now() - make_interval(secs => $%d))", n)
+}
+
+func (r *postgresRedemptionStore) Reserve(code, customerID string, limits RedemptionLimits) (string, error) {
+	if limits.MaxUsesPerCustomer > 0 && customerID == "" {
+		return "", domain.ErrCustomerIDRequired
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('promo_redemptions'), hashtext($1))", code); err != nil {
+		return "", fmt.Errorf("failed to lock promo code redemptions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM promo_redemptions WHERE code = $1 AND NOT "+heldSQL(2), code, r.timeout.Seconds()); err != nil {
+		return "", fmt.Errorf("failed to delete expired promo code reservations: %w", err)
+	}
+	usage, err := usageOf(tx, code, customerID, r.timeout)
+	if err != nil {
+		return "", err
+	}
+	if limits.MaxUses > 0 && usage.Used >= limits.MaxUses {
+		return "", domain.ErrPromoCodeExhausted
+	}
+	if limits.MaxUsesPerCustomer > 0 && usage.CustomerUsed >= limits.MaxUsesPerCustomer {
+		return "", domain.ErrPromoCodeCustomerLimit
+	}
+
+	id := uuid.New().String()
+	if _, err := tx.Exec("INSERT INTO promo_redemptions (reservation_id, code, customer_id) VALUES ($1, $2, $3)", id, code, customerID); err != nil {
+		return "", fmt.Errorf("failed to reserve promo code: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return id, nil
+}
+
+func (r *postgresRedemptionStore) Commit(reservationID, orderID string) error {
+	result, err := r.db.Exec("UPDATE promo_redemptions SET order_id = $2 WHERE reservation_id = $1 AND order_id = '' AND "+heldSQL(3),
+		reservationID, orderID, r.timeout.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to commit promo code redemption: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("%w: %s", domain.ErrReservationNotFound, reservationID)
+	}
+	return nil
+}
+
+func (r *postgresRedemptionStore) Release(reservationID string) error {
+	result, err := r.db.Exec("DELETE FROM promo_redemptions WHERE reservation_id = $1 AND order_id = ''", reservationID)
+	if err != nil {
+		return fmt.Errorf("failed to release promo code reservation: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("%w: %s", domain.ErrReservationNotFound, reservationID)
+	}
+	return nil
+}
+
+func (r *postgresRedemptionStore) Revoke(orderID string) (int, error) {
+	if orderID == "" {
+		return 0, nil
+	}
+	result, err := r.db.Exec("DELETE FROM promo_redemptions WHERE order_id = $1", orderID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke promo code redemptions: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count revoked promo code redemptions: %w", err)
+	}
+	return int(rows), nil
+}
+
+func (r *postgresRedemptionStore) Usage(code, customerID string) (RedemptionUsage, error) {
+	return usageOf(r.db, code, customerID, r.timeout)
+}
+
+func (r *postgresRedemptionStore) UsageMany(codes []string, customerID string) (map[string]RedemptionUsage, error) {
+	rows, err := r.db.Query(`
+	SELECT code, count(*), count(*) FILTER (WHERE $2 <> '' AND customer_id = $2)
+	FROM promo_redemptions WHERE code = ANY($1) AND `+heldSQL(3)+`
+	GROUP BY code`, pq.Array(codes), customerID, r.timeout.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read promo code usage: %w", err)
+	}
+	defer rows.Close()
+
+	usages := make(map[string]RedemptionUsage)
+	for rows.Next() {
+		var code string
+		var usage RedemptionUsage
+		if err := rows.Scan(&code, &usage.Used, &usage.CustomerUsed); err != nil {
+			return nil, fmt.Errorf("failed to read promo code usage: %w", err)
+		}
+		usages[code] = usage
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read promo code usage: %w", err)
+	}
+	return usages, nil
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// usageOf counts the uses of code overall and by customerID, leaving out expired reservations.
+func usageOf(q queryRower, code, customerID string, timeout time.Duration) (RedemptionUsage, error) {
+	var usage RedemptionUsage
+	err := q.QueryRow(`
+	SELECT count(*), count(*) FILTER (WHERE $2 <> '' AND customer_id = $2)
+	FROM promo_redemptions WHERE code = $1 AND `+heldSQL(3), code, customerID, timeout.Seconds()).Scan(&usage.Used, &usage.CustomerUsed)
+	if err != nil {
+		return RedemptionUsage{}, fmt.Errorf("failed to read promo code usage: %w", err)
+	}
+	return usage, nil
+}
@@ -1,13 +1,14 @@
 package promos
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver and array support
 )
 
 // PostgresPromoCodeRepository implements PromoCodeRepository for PostgreSQL.
@@ -63,15 +64,39 @@ func (r *PostgresPromoCodeRepository) GetCount(code string) (int, bool) {
 	return count, true
 }
 
+// GetCounts fetches the counts of many codes in a single query.
+func (r *PostgresPromoCodeRepository) GetCounts(ctx context.Context, codes []string) (map[string]int, error) {
+	counts := make(map[string]int, len(codes))
+	if len(codes) == 0 {
+		return counts, nil
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT code, count FROM promo_codes WHERE code = ANY($1)", pq.Array(codes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get promo code counts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var code string
+		var count int
+		if err := rows.Scan(&code, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan promo code row: %w", err)
+		}
+		counts[code] = count
+	}
+	return counts, rows.Err()
+}
+
 // BulkIncrement performs batch upserts to the database.
 // This is the core for efficient initial loading of derived data.
-func (r *PostgresPromoCodeRepository) BulkIncrement(codes map[string]int) error {
+func (r *PostgresPromoCodeRepository) BulkIncrement(ctx context.Context, codes map[string]int) error {
 	if len(codes) == 0 {
 		return nil // Nothing to update
 	}
 
 	// Use a transaction for atomicity and performance
-	tx, err := r.db.Begin()
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction for bulk increment: %w", err)
 	}
@@ -107,7 +132,7 @@ func (r *PostgresPromoCodeRepository) BulkIncrement(codes map[string]int) error
 				ON CONFLICT (code) DO UPDATE SET count = promo_codes.count + EXCLUDED.count;
 			`, strings.Join(valueStrings, ","))
 
-			_, err := tx.Exec(stmt, valueArgs...)
+			_, err := tx.ExecContext(ctx, stmt, valueArgs...)
 			if err != nil {
 				return fmt.Errorf("failed to execute batch upsert %d: %w", currentBatch, err)
 			}
@@ -129,7 +154,7 @@ func (r *PostgresPromoCodeRepository) BulkIncrement(codes map[string]int) error
 			ON CONFLICT (code) DO UPDATE SET count = promo_codes.count + EXCLUDED.count;
 		`, strings.Join(valueStrings, ","))
 
-		_, err := tx.Exec(stmt, valueArgs...)
+		_, err := tx.ExecContext(ctx, stmt, valueArgs...)
 		if err != nil {
 			return fmt.Errorf("failed to execute final batch upsert %d: %w", currentBatch, err)
 		}
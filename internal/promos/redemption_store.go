@@ -0,0 +1,177 @@
+package promos
+
+import (
+	"fmt"
+	"kart-challenge/internal/domain"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// RedemptionLimits are the usage limits that apply to a single promo code. Zero means unlimited.
+type RedemptionLimits struct {
+	MaxUses            int
+	MaxUsesPerCustomer int
+}
+
+// limitsFor returns the redemption limits a campaign puts on each of its codes.
+func limitsFor(c domain.Campaign) RedemptionLimits {
+	limits := RedemptionLimits{MaxUses: c.MaxUses, MaxUsesPerCustomer: c.MaxUsesPerCustomer}
+	if c.SingleUse {
+		limits.MaxUses = 1
+	}
+	return limits
+}
+
+// RedemptionUsage counts the uses of a code. Held reservations count as used until they are released.
+type RedemptionUsage struct {
+	Used         int
+	CustomerUsed int
+}
+
+// RedemptionStore records promo code redemptions. Reserve checks the limits and holds a use in one
+// atomic step, so concurrent orders can never redeem a code more often than its limits allow.
+type RedemptionStore interface {
+	// Reserve holds one use of code for customerID and returns the reservation ID.
+	Reserve(code, customerID string, limits RedemptionLimits) (string, error)
+	// Commit turns a reservation into a redemption by the given order.
+	Commit(reservationID, orderID string) error
+	// Release gives a held use back.
+	Release(reservationID string) error
+	// Revoke gives back every use committed by orderID, e.g. when the order is cancelled, and returns
+	// how many there were. Revoking an order without redemptions is not an error.
+	Revoke(orderID string) (int, error)
+	// Usage returns how often code has been used overall and by customerID.
+	Usage(code, customerID string) (RedemptionUsage, error)
+	// UsageMany returns the usage of each of codes, as Usage would, in one step. Codes that were never
+	// used are left out.
+	UsageMany(codes []string, customerID string) (map[string]RedemptionUsage, error)
+}
+
+type redemption struct {
+	code       string
+	customerID string
+	orderID    string // Empty while the use is only reserved
+}
+
+// inMemoryRedemptionStore keeps redemptions in process memory; they are lost on restart.
+type inMemoryRedemptionStore struct {
+	redemptions map[string]redemption // Keyed by reservation ID
+	used        map[string]int        // Keyed by code
+	usedBy      map[string]int        // Keyed by code and customer ID
+	mu          sync.Mutex
+}
+
+// NewInMemoryRedemptionStore creates an empty in-memory redemption store.
+func NewInMemoryRedemptionStore() RedemptionStore {
+	return &inMemoryRedemptionStore{
+		redemptions: make(map[string]redemption),
+		used:        make(map[string]int),
+		usedBy:      make(map[string]int),
+	}
+}
+
+func customerKey(code, customerID string) string {
+	return code + "\x00" + customerID
+}
+
+func (r *inMemoryRedemptionStore) Reserve(code, customerID string, limits RedemptionLimits) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limits.MaxUses > 0 && r.used[code] >= limits.MaxUses {
+		return "", domain.ErrPromoCodeExhausted
+	}
+	if limits.MaxUsesPerCustomer > 0 {
+		if customerID == "" {
+			return "", domain.ErrCustomerIDRequired
+		}
+		if r.usedBy[customerKey(code, customerID)] >= limits.MaxUsesPerCustomer {
+			return "", domain.ErrPromoCodeCustomerLimit
+		}
+	}
+
+	id := uuid.New().String()
+	r.redemptions[id] = redemption{code: code, customerID: customerID}
+	r.used[code]++
+	if customerID != "" {
+		r.usedBy[customerKey(code, customerID)]++
+	}
+	return id, nil
+}
+
+func (r *inMemoryRedemptionStore) Commit(reservationID, orderID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	held, ok := r.redemptions[reservationID]
+	if !ok || held.orderID != "" {
+		return fmt.Errorf("%w: %s", domain.ErrReservationNotFound, reservationID)
+	}
+	held.orderID = orderID
+	r.redemptions[reservationID] = held
+	return nil
+}
+
+func (r *inMemoryRedemptionStore) Release(reservationID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	held, ok := r.redemptions[reservationID]
+	if !ok || held.orderID != "" {
+		return fmt.Errorf("%w: %s", domain.ErrReservationNotFound, reservationID)
+	}
+	delete(r.redemptions, reservationID)
+	r.used[held.code]--
+	if held.customerID != "" {
+		r.usedBy[customerKey(held.code, held.customerID)]--
+	}
+	return nil
+}
+
+func (r *inMemoryRedemptionStore) Revoke(orderID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	revoked := 0
+	for id, held := range r.redemptions {
+		if orderID == "" || held.orderID != orderID {
+			continue
+		}
+		delete(r.redemptions, id)
+		r.used[held.code]--
+		if held.customerID != "" {
+			r.usedBy[customerKey(held.code, held.customerID)]--
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (r *inMemoryRedemptionStore) Usage(code, customerID string) (RedemptionUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage := RedemptionUsage{Used: r.used[code]}
+	if customerID != "" {
+		usage.CustomerUsed = r.usedBy[customerKey(code, customerID)]
+	}
+	return usage, nil
+}
+
+func (r *inMemoryRedemptionStore) UsageMany(codes []string, customerID string) (map[string]RedemptionUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usages := make(map[string]RedemptionUsage)
+	for _, code := range codes {
+		usage := RedemptionUsage{Used: r.used[code]}
+		if customerID != "" {
+			usage.CustomerUsed = r.usedBy[customerKey(code, customerID)]
+		}
+		if usage.Used > 0 {
+			usages[code] = usage
+		}
+	}
+	return usages, nil
+}
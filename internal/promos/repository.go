@@ -1,13 +1,16 @@
 package promos
 
 import (
+	"context"
+
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
 type PromoCodeRepository interface {
 	GetCount(code string) (int, bool)
+	GetCounts(ctx context.Context, codes []string) (map[string]int, error) // Only codes that exist are returned
 	IncrementCount(code string)
 	Reset() error
 	GetAllCounts() map[string]int
-	BulkIncrement(codes map[string]int) error
+	BulkIncrement(ctx context.Context, codes map[string]int) error
 }
@@ -0,0 +1,123 @@
+package promos
+
+import (
+	"fmt"
+	"kart-challenge/internal/domain"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// campaignSchedule is a parsed domain.CampaignSchedule.
+type campaignSchedule struct {
+	startsAt *time.Time
+	endsAt   *time.Time
+	location *time.Location
+	windows  []weeklyWindow
+	invalid  error // Set when the definition could not be parsed; the campaign is then never active
+}
+
+// weeklyWindow is a recurring window in minutes since local midnight. A window whose end is not after
+// its start runs past midnight into the next day.
+type weeklyWindow struct {
+	days  [7]bool // Indexed by time.Weekday
+	start int
+	end   int
+	label string
+}
+
+// parseSchedule validates a campaign schedule and resolves its time zone.
+func parseSchedule(s domain.CampaignSchedule) (*campaignSchedule, error) {
+	location := time.UTC
+	if s.TimeZone != "" {
+		loc, err := time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone '%s': %w", s.TimeZone, err)
+		}
+		location = loc
+	}
+	if s.StartsAt != nil && s.EndsAt != nil && !s.EndsAt.After(*s.StartsAt) {
+		return nil, fmt.Errorf("ends_at must be after starts_at")
+	}
+
+	parsed := &campaignSchedule{startsAt: s.StartsAt, endsAt: s.EndsAt, location: location}
+	for _, w := range s.Windows {
+		window := weeklyWindow{label: fmt.Sprintf("%s %s-%s", strings.Join(w.Days, ","), w.Start, w.End)}
+		if len(w.Days) == 0 {
+			window.label = fmt.Sprintf("daily %s-%s", w.Start, w.End)
+			for day := range window.days {
+				window.days[day] = true
+			}
+		}
+		for _, name := range w.Days {
+			day, ok := weekdays[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown day '%s' (use mon, tue, wed, thu, fri, sat or sun)", name)
+			}
+			window.days[day] = true
+		}
+
+		var err error
+		if window.start, err = parseClock(w.Start); err != nil {
+			return nil, err
+		}
+		if window.end, err = parseClock(w.End); err != nil {
+			return nil, err
+		}
+		if window.start == window.end {
+			return nil, fmt.Errorf("window %s is empty", window.label)
+		}
+		parsed.windows = append(parsed.windows, window)
+	}
+	return parsed, nil
+}
+
+// parseClock parses "HH:MM" (00:00 to 24:00) into minutes since midnight.
+func parseClock(value string) (int, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes); err != nil || len(value) != 5 {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", value)
+	}
+	total := hours*60 + minutes
+	if hours < 0 || minutes < 0 || minutes > 59 || total > 24*60 {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", value)
+	}
+	return total, nil
+}
+
+// activeAt reports whether the schedule allows redemptions at now. It returns an empty reason when it
+// does, otherwise one of the domain.PromoReason values and a message explaining why.
+func (s *campaignSchedule) activeAt(now time.Time) (string, string) {
+	if s.invalid != nil {
+		return domain.PromoReasonOutsideWindow, "its schedule is invalid"
+	}
+	if s.startsAt != nil && now.Before(*s.startsAt) {
+		return domain.PromoReasonNotStarted, fmt.Sprintf("it starts at %s", s.startsAt.In(s.location).Format(time.RFC3339))
+	}
+	if s.endsAt != nil && !now.Before(*s.endsAt) {
+		return domain.PromoReasonExpired, fmt.Sprintf("it ended at %s", s.endsAt.In(s.location).Format(time.RFC3339))
+	}
+	if len(s.windows) == 0 {
+		return "", ""
+	}
+
+	local := now.In(s.location)
+	minute := local.Hour()*60 + local.Minute()
+	today, yesterday := local.Weekday(), (local.Weekday()+6)%7
+	labels := make([]string, 0, len(s.windows))
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return "", ""
+			}
+		} else if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return "", ""
+		}
+		labels = append(labels, w.label)
+	}
+	return domain.PromoReasonOutsideWindow, fmt.Sprintf("it is only active %s (%s)", strings.Join(labels, "; "), s.location)
+}
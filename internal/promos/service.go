@@ -4,13 +4,19 @@ import (
 	"bufio"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"kart-challenge/internal/domain"
+	"kart-challenge/internal/pricing"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/allegro/bigcache/v3"
@@ -19,27 +25,83 @@ import (
 const (
 	promoCodeMinLength = 8
 	promoCodeMaxLength = 10
+	minSourcesForValid = 2 // A code must appear in at least this many files to be valid
 	bigCacheName       = "promoCodeValidationCache"
 
 	aggregationBatchSize = 100000 // Process 100,000 unique codes at a time for aggregation
+	maxPendingRechecks   = 10000  // Cap on codes accepted optimistically while the dataset was loading
+	maxRecheckResults    = 10000  // Re-check results kept for GetRechecks; the oldest are dropped first
+	scanProgressInterval = 65536  // Report scan progress to the load tracker every N lines
 )
 
 type Service interface {
-	LoadPromoCodesFromURLs(urls []string) error
-	ValidatePromoCode(code string) (bool, string)
+	LoadPromoCodesFromURLs(ctx context.Context, urls []string) error
+	ValidatePromoCode(code, customerID string) domain.PromoValidationResult
+	ValidatePromoCodeForCart(code, customerID string, lines []pricing.Line) domain.PromoValidationResult
+	ValidatePromoCodes(ctx context.Context, reqs []domain.ValidatePromoteCodeRequest) ([]domain.PromoValidationResult, error)
+	ResolveCampaign(code string) (domain.Campaign, bool)
+	ActiveAutomaticCampaigns() []domain.Campaign
+	ReserveRedemption(code, customerID string) (string, error)
+	CommitRedemption(reservationID, orderID string) error
+	ReleaseRedemption(reservationID string) error
+	RevokeRedemptions(orderID string) (int, error)
+	GetPromoCodeUsage(code, customerID string) (domain.PromoCodeUsage, error)
 	GetPromoCodeCounts() map[string]int
+	GetLoadStatus() domain.PromoLoadStatus
+	IsReady() bool
+	MarkForRecheck(code string)
+	GetRechecks() domain.PromoRechecks
+	ListDatasetVersions() []domain.PromoDatasetVersion
+	DiffDatasetVersions(from, to int, limit int) (domain.PromoDatasetDiff, error)
+	LookupCodeInVersion(version int, code string) (domain.PromoCodeVersionLookup, error)
 	Close() error //closing resources like BigCache
 }
 
+// Config holds the settings a PromoCodeService is built with.
+type Config struct {
+	MaxDecompressedFileSizeMB int
+	Environment               string // "development" or "production"
+	LocalCouponDirPath        string // Path to local .gz coupon files
+	Integrity                 IntegrityConfig
+	MaxDatasetVersions        int // Number of past loads kept for diffing
+	Campaigns                 []domain.Campaign
+	Now                       func() time.Time // Clock for campaign schedules; defaults to time.Now
+	Redemptions               RedemptionStore  // Defaults to an in-memory store
+}
+
 type PromoCodeService struct {
 	repo                    PromoCodeRepository
 	bigCache                *bigcache.BigCache
 	maxDecompressedFileSize int64
 	environment             string // "development" or "production"
 	localCouponDirPath      string // Path to local .gz coupon files
+	integrity               IntegrityConfig
+	tracker                 *LoadTracker
+	versions                VersionStore
+	campaigns               *campaignCatalog
+	redemptions             RedemptionStore
+	now                     func() time.Time
+	ready                   atomic.Bool // Set once a load has completed successfully
+
+	recheckMu       sync.Mutex
+	pendingRechecks map[string]struct{}
+	recheckResults  []domain.PromoRecheck
 }
 
-func NewService(maxDecompressedFileSizeMB int, env string, localCouponDirPath string) Service {
+// fileResult carries the codes found in a single source back to the aggregator.
+type fileResult struct {
+	fileIndex int
+	codes     map[string]bool
+}
+
+func NewService(cfg Config) Service {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.Redemptions == nil {
+		cfg.Redemptions = NewInMemoryRedemptionStore()
+	}
+
 	cacheConfig := bigcache.DefaultConfig(1 * time.Hour)
 	cacheConfig.CleanWindow = 10 * time.Minute
 	cacheConfig.Verbose = true
@@ -56,19 +118,42 @@ func NewService(maxDecompressedFileSizeMB int, env string, localCouponDirPath st
 	return &PromoCodeService{
 		repo:                    NewInMemoryPromoCodeRepository(), // Use the in-memory repository
 		bigCache:                bc,
-		maxDecompressedFileSize: int64(maxDecompressedFileSizeMB) * 1024 * 1024,
-		environment:             env,
-		localCouponDirPath:      localCouponDirPath,
+		maxDecompressedFileSize: int64(cfg.MaxDecompressedFileSizeMB) * 1024 * 1024,
+		environment:             cfg.Environment,
+		localCouponDirPath:      cfg.LocalCouponDirPath,
+		integrity:               cfg.Integrity,
+		tracker:                 NewLoadTracker(),
+		versions:                NewInMemoryVersionStore(cfg.MaxDatasetVersions),
+		campaigns:               newCampaignCatalog(cfg.Campaigns),
+		redemptions:             cfg.Redemptions,
+		now:                     cfg.Now,
+		pendingRechecks:         make(map[string]struct{}),
 	}
 }
 
-func (s *PromoCodeService) LoadPromoCodesFromURLs(urls []string) error {
+// LoadPromoCodesFromURLs downloads, decompresses and scans every source concurrently and aggregates
+// the results into the repository. Cancelling ctx stops in-flight downloads and scans; the call
+// returns only after every worker has exited and cleaned up its temporary files.
+func (s *PromoCodeService) LoadPromoCodesFromURLs(ctx context.Context, urls []string) error {
 	log.Println("Starting to load promo codes from URLs...")
 
-	s.repo.Reset()
+	// The repository is emptied below, so validation is not trustworthy until this load succeeds.
+	s.ready.Store(false)
+	if err := s.repo.Reset(); err != nil {
+		return fmt.Errorf("failed to reset promo code repository: %w", err)
+	}
+	s.tracker.Start(urls)
 	var wg sync.WaitGroup
 
-	codesCh := make(chan map[string]bool, len(urls))
+	// Workers are cancelled and awaited on every return path, so none outlive this call.
+	// A source that fails verification cancels the whole load with the verification error as cause.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer func() {
+		cancel(nil)
+		wg.Wait()
+	}()
+
+	codesCh := make(chan fileResult, len(urls))
 	errCh := make(chan error, len(urls))
 
 	for i, url := range urls {
@@ -76,11 +161,17 @@ func (s *PromoCodeService) LoadPromoCodesFromURLs(urls []string) error {
 		go func(fileIndex int, u string) {
 			defer wg.Done()
 			log.Printf("Processing file %d: %s", fileIndex+1, u)
-			fileFoundCodes, err := s.processSinglePromoFile(fileIndex, u)
+			fileFoundCodes, err := s.processSinglePromoFile(ctx, fileIndex, u)
 			if err != nil {
-				errCh <- fmt.Errorf("error processing file %d (%s): %w", fileIndex+1, u, err)
+				s.tracker.FailSource(fileIndex, err)
+				err = fmt.Errorf("error processing file %d (%s): %w", fileIndex+1, u, err)
+				if errors.Is(err, domain.ErrSourceVerificationFailed) {
+					cancel(err)
+				}
+				errCh <- err
+				return
 			}
-			codesCh <- fileFoundCodes
+			codesCh <- fileResult{fileIndex: fileIndex, codes: fileFoundCodes}
 		}(i, url)
 	}
 
@@ -114,27 +205,39 @@ func (s *PromoCodeService) LoadPromoCodesFromURLs(urls []string) error {
 	currentBatch := make(map[string]int)
 	processedCount := 0
 
-	for fileFoundCodes := range codesCh { // This loop runs after individual files are processed concurrently
-		for code := range fileFoundCodes {
+	for result := range codesCh { // This loop runs after individual files are processed concurrently
+		if ctx.Err() != nil {
+			return s.abortLoad(context.Cause(ctx))
+		}
+		s.tracker.SetPhase(result.fileIndex, domain.PromoLoadPhaseAggregate, 0)
+		for code := range result.codes {
 			currentBatch[code]++
 			processedCount++
 
 			// If current batch size reaches the limit, perform bulk increment
 			if len(currentBatch) >= aggregationBatchSize {
 				log.Printf("Aggregating %d unique codes into repository (processed so far: %d)...", len(currentBatch), processedCount)
-				if err := s.repo.BulkIncrement(currentBatch); err != nil {
-					return fmt.Errorf("failed to perform bulk increment on repository: %w", err)
+				if err := s.repo.BulkIncrement(ctx, currentBatch); err != nil {
+					err = fmt.Errorf("failed to perform bulk increment on repository: %w", err)
+					s.tracker.Finish(0, err)
+					return err
 				}
 				currentBatch = make(map[string]int) // Reset batch
 			}
 		}
+		s.tracker.SetPhase(result.fileIndex, domain.PromoLoadPhaseDone, 0)
+	}
+	if ctx.Err() != nil {
+		return s.abortLoad(context.Cause(ctx))
 	}
 
 	// Perform final bulk increment for any remaining codes in the batch
 	if len(currentBatch) > 0 {
 		log.Printf("Performing final aggregation of %d unique codes into repository (total processed: %d)...", len(currentBatch), processedCount)
-		if err := s.repo.BulkIncrement(currentBatch); err != nil {
-			return fmt.Errorf("failed to perform final bulk increment on repository: %w", err)
+		if err := s.repo.BulkIncrement(ctx, currentBatch); err != nil {
+			err = fmt.Errorf("failed to perform final bulk increment on repository: %w", err)
+			s.tracker.Finish(0, err)
+			return err
 		}
 	}
 	log.Println("Batched aggregation complete.")
@@ -144,23 +247,115 @@ func (s *PromoCodeService) LoadPromoCodesFromURLs(urls []string) error {
 		if allErrors == nil {
 			allErrors = err
 		} else {
-			allErrors = fmt.Errorf("%v; %w", allErrors, err)
+			allErrors = fmt.Errorf("%w; %w", allErrors, err)
 		}
 	}
-	log.Printf("Finished loading promo codes. Total unique codes found: %d", len(s.repo.GetAllCounts()))
+	counts := s.repo.GetAllCounts()
+	s.tracker.Finish(len(counts), allErrors)
+	log.Printf("Finished loading promo codes. Total unique codes found: %d", len(counts))
+	if allErrors == nil {
+		s.recordVersion(counts)
+		s.ready.Store(true)
+		s.recheckPending()
+	}
 	return allErrors
 }
 
-func (s *PromoCodeService) processSinglePromoFile(fileIndex int, url string) (map[string]bool, error) {
+// recheckPending validates the codes that were accepted optimistically while the dataset was loading.
+// The results are kept for GetRechecks so the affected orders can be followed up.
+func (s *PromoCodeService) recheckPending() {
+	s.recheckMu.Lock()
+	pending := s.pendingRechecks
+	s.pendingRechecks = make(map[string]struct{})
+	s.recheckMu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	codes := make([]string, 0, len(pending))
+	for code := range pending {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	checkedAt := s.now()
+	results := make([]domain.PromoRecheck, 0, len(codes))
+	invalid := 0
+	for _, code := range codes {
+		result := s.ValidatePromoCode(code, "")
+		if !result.Valid {
+			invalid++
+			log.Printf("WARN: Promo code '%s' was accepted while loading but failed re-check: %s (%s)", code, result.Message, result.Reason)
+		}
+		results = append(results, domain.PromoRecheck{
+			Code:      code,
+			Valid:     result.Valid,
+			Reason:    result.Reason,
+			Message:   result.Message,
+			CheckedAt: checkedAt,
+		})
+	}
+	log.Printf("Re-checked %d promo codes accepted while loading, %d turned out invalid", len(pending), invalid)
+
+	s.recheckMu.Lock()
+	defer s.recheckMu.Unlock()
+	s.recheckResults = append(s.recheckResults, results...)
+	if excess := len(s.recheckResults) - maxRecheckResults; excess > 0 {
+		s.recheckResults = slices.Clone(s.recheckResults[excess:])
+	}
+}
+
+// recordVersion stores a successful load, fingerprinted by the digests of its sources.
+func (s *PromoCodeService) recordVersion(counts map[string]int) {
+	status := s.tracker.Snapshot()
+	version := domain.PromoDatasetVersion{
+		LoadedAt:    s.now(),
+		UniqueCodes: len(counts),
+		Sources:     make([]domain.PromoSourceFingerprint, 0, len(status.Sources)),
+	}
+	for _, count := range counts {
+		if isValidCount(count) {
+			version.ValidCodes++
+		}
+	}
+	for _, src := range status.Sources {
+		version.Sources = append(version.Sources, domain.PromoSourceFingerprint{
+			Source:       src.Source,
+			SHA256:       src.SHA256,
+			Verification: src.Verification,
+			LinesScanned: src.LinesScanned,
+			CodesFound:   src.CodesFound,
+		})
+	}
+	version = s.versions.Record(version, counts)
+	log.Printf("Recorded promo dataset version %d (%d unique codes, %d valid)", version.ID, version.UniqueCodes, version.ValidCodes)
+}
+
+// abortLoad records a cancelled or aborted load. Partially aggregated counts are discarded so
+// validation never runs against a half-loaded dataset.
+func (s *PromoCodeService) abortLoad(cause error) error {
+	err := fmt.Errorf("promo code load aborted: %w", cause)
+	log.Printf("WARN: %v", err)
+	if resetErr := s.repo.Reset(); resetErr != nil {
+		log.Printf("ERROR: Failed to reset promo code repository after aborted load: %v", resetErr)
+	}
+	s.tracker.Finish(0, err)
+	return err
+}
+
+func (s *PromoCodeService) processSinglePromoFile(ctx context.Context, fileIndex int, url string) (map[string]bool, error) {
 	var reader io.ReadCloser
 	var source string
+	var sourceSize int64
+
+	s.tracker.SetPhase(fileIndex, domain.PromoLoadPhaseDownload, 0)
 
 	// Determine if we should load from local disk or remote URL
 	if s.environment == "development" {
 		fileName := filepath.Base(url) // e.g., "couponbase1.gz"
 		localPath := filepath.Join(s.localCouponDirPath, fileName)
 
-		if _, err := os.Stat(localPath); err == nil {
+		if info, err := os.Stat(localPath); err == nil {
 			log.Printf("INFO: Loading file %d from local path: %s", fileIndex+1, localPath)
 			file, err := os.Open(localPath)
 			if err != nil {
@@ -168,6 +363,7 @@ func (s *PromoCodeService) processSinglePromoFile(fileIndex int, url string) (ma
 			}
 			reader = file
 			source = "local"
+			sourceSize = info.Size()
 		} else {
 			log.Printf("WARN: Local file '%s' not found for file %d. Attempting remote download.", localPath, fileIndex+1)
 			goto remoteDownload // Jump to remote download if local file not found
@@ -183,7 +379,11 @@ remoteDownload:
 		log.Printf("INFO: Downloading file %d from remote URL: %s", fileIndex+1, url)
 		// Increased timeout for potentially large files and network conditions
 		client := &http.Client{Timeout: 5 * time.Minute}
-		resp, err := client.Get(url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build request for GZ file: %w", err)
+		}
+		resp, err := client.Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch GZ file: %w", err)
 		}
@@ -194,8 +394,43 @@ remoteDownload:
 		}
 		reader = resp.Body
 		source = "remote"
+		sourceSize = max(resp.ContentLength, 0) // -1 when the server does not send Content-Length
 	}
 	defer reader.Close() // Ensure the source reader (local file or http.Response.Body) is closed
+	s.tracker.SetOrigin(fileIndex, source)
+
+	// Sources that declare a checksum or signature are spooled to disk and verified before any of
+	// their content is decompressed. Other sources are hashed on the fly so the digest is still recorded.
+	digests := newSourceDigests()
+	var compressed io.Reader = io.TeeReader(reader, digests)
+	verified := false
+	if expected, ok := s.integrity.Sources[filepath.Base(url)]; ok {
+		s.tracker.SetPhase(fileIndex, domain.PromoLoadPhaseDownload, sourceSize)
+		rawPath, err := spoolToTempFile(&contextReader{ctx: ctx, r: s.tracker.Reader(fileIndex, reader)}, digests, fileIndex)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(rawPath) // No-op once the file has been quarantined
+
+		method, err := s.verifySource(ctx, url, expected, digests)
+		if err != nil {
+			s.quarantineSource(fileIndex, url, rawPath, digests, err)
+			return nil, err
+		}
+		s.tracker.SetDigest(fileIndex, digests.SHA256(), method)
+		verified = true
+
+		rawFile, err := os.Open(rawPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reopen verified GZ file: %w", err)
+		}
+		defer rawFile.Close()
+		if info, err := rawFile.Stat(); err == nil {
+			sourceSize = info.Size()
+		}
+		compressed = rawFile
+	}
+	s.tracker.SetPhase(fileIndex, domain.PromoLoadPhaseDecompress, sourceSize)
 
 	tempFile, err := os.CreateTemp("", fmt.Sprintf("couponbase%d-*.tmp", fileIndex+1))
 	if err != nil {
@@ -205,7 +440,7 @@ remoteDownload:
 	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 
-	gzipReader, err := gzip.NewReader(reader)
+	gzipReader, err := gzip.NewReader(&contextReader{ctx: ctx, r: s.tracker.Reader(fileIndex, compressed)})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
 	}
@@ -213,9 +448,10 @@ remoteDownload:
 
 	// limitedReader to prevent writing excessively large decompressed files to disk
 	limitedReader := io.LimitReader(gzipReader, s.maxDecompressedFileSize)
+	decompressedReader := &progressReader{r: limitedReader, onRead: func(n int) { s.tracker.AddDecompressed(fileIndex, int64(n)) }}
 
 	log.Printf("Starting decompression from %s source for file %d (%s)...", source, fileIndex+1, url)
-	bytesWritten, err := io.Copy(tempFile, limitedReader)
+	bytesWritten, err := io.Copy(tempFile, decompressedReader)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to decompress to temporary file: %w", err)
@@ -226,19 +462,34 @@ remoteDownload:
 	if bytesWritten >= s.maxDecompressedFileSize {
 		return nil, fmt.Errorf("decompressed file size exceeded limit of %dMB, possible malicious file", s.maxDecompressedFileSize/1024/1024)
 	}
+	if !verified {
+		s.tracker.SetDigest(fileIndex, digests.SHA256(), domain.PromoVerificationNone)
+	}
 	fileForScan, err := os.Open(tempFile.Name())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open temporary decompressed file: %w", err)
 	}
 	defer fileForScan.Close()
 
+	s.tracker.SetPhase(fileIndex, domain.PromoLoadPhaseScan, bytesWritten)
 	scanner := bufio.NewScanner(fileForScan)
 	fileFoundCodes := make(map[string]bool)
 
 	// promoCodeRegex := regexp.MustCompile(`[A-Za-z0-9]{8,10}`)
 
+	var pendingLines, pendingBytes int64
 	for scanner.Scan() {
 		line := scanner.Text()
+		pendingLines++
+		pendingBytes += int64(len(line)) + 1 // +1 for the newline stripped by the scanner
+		if pendingLines == scanProgressInterval {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			s.tracker.AddLines(fileIndex, pendingLines)
+			s.tracker.AddBytes(fileIndex, pendingBytes)
+			pendingLines, pendingBytes = 0, 0
+		}
 		// matches := promoCodeRegex.FindAllString(line, -1)
 		// for _, code := range matches {
 		// 	if len(code) >= promoCodeMinLength && len(code) <= promoCodeMaxLength {
@@ -250,9 +501,13 @@ remoteDownload:
 		}
 	}
 
+	s.tracker.AddLines(fileIndex, pendingLines)
+	s.tracker.AddBytes(fileIndex, pendingBytes)
+
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error reading decompressed file: %w", err)
 	}
+	s.tracker.SetCodesFound(fileIndex, len(fileFoundCodes))
 
 	// for code := range fileFoundCodes {
 	// 	s.repo.IncrementCount(code)
@@ -261,22 +516,323 @@ remoteDownload:
 	return fileFoundCodes, nil
 }
 
-func (s *PromoCodeService) ValidatePromoCode(code string) (bool, string) {
-	if len(code) < promoCodeMinLength || len(code) > promoCodeMaxLength {
-		return false, fmt.Sprintf("Promo code must be between %d and %d characters long.", promoCodeMinLength, promoCodeMaxLength)
+// ValidatePromoCode checks a code against the loaded dataset, then against the schedule and usage
+// limits of its campaign. Per-customer limits are only checked when customerID is set.
+func (s *PromoCodeService) ValidatePromoCode(code, customerID string) domain.PromoValidationResult {
+	if result, ok := s.precheck(code); !ok {
+		return result
 	}
 	count, exists := s.repo.GetCount(code)
-	if !exists || count < 2 {
-		return false, "Promo code not found in at least two files."
+	return s.validateCount(code, customerID, count, exists, s.redemptions.Usage)
+}
+
+// ValidatePromoCodeForCart validates a code like ValidatePromoCode, then checks the conditions of its
+// campaign against a cart. A valid code the cart does not qualify for stays valid, with reason
+// PromoReasonNotApplicable and a message saying what the cart is missing.
+func (s *PromoCodeService) ValidatePromoCodeForCart(code, customerID string, lines []pricing.Line) domain.PromoValidationResult {
+	result := s.ValidatePromoCode(code, customerID)
+	if !result.Valid || result.Campaign == nil {
+		return result
 	}
+	applicable, message := pricing.CheckConditions(*result.Campaign, lines)
+	result.Applicable = &applicable
+	if !applicable {
+		result.Reason = domain.PromoReasonNotApplicable
+		result.Message = message
+	}
+	return result
+}
 
-	return true, "Promo code is valid."
+// ValidatePromoCodes validates many codes with a single repository lookup. Results are in request order.
+func (s *PromoCodeService) ValidatePromoCodes(ctx context.Context, reqs []domain.ValidatePromoteCodeRequest) ([]domain.PromoValidationResult, error) {
+	results := make([]domain.PromoValidationResult, len(reqs))
+	checked := make([]bool, len(reqs))
+	lookup := make([]string, 0, len(reqs))
+	for i, req := range reqs {
+		if result, ok := s.precheck(req.PromoteCode); !ok {
+			results[i], checked[i] = result, true
+			continue
+		}
+		lookup = append(lookup, req.PromoteCode)
+	}
+
+	counts, err := s.repo.GetCounts(ctx, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up promo codes: %w", err)
+	}
+	usage := s.batchUsage(reqs, checked, counts)
+	for i, req := range reqs {
+		if !checked[i] {
+			count, exists := counts[req.PromoteCode]
+			results[i] = s.validateCount(req.PromoteCode, req.CustomerID, count, exists, usage)
+		}
+	}
+	return results, nil
+}
+
+// usageLookup returns how often a code has been used overall and by a customer.
+type usageLookup func(code, customerID string) (RedemptionUsage, error)
+
+// batchUsage reads the usage of every code in a batch that has usage limits, with one store lookup
+// per customer instead of one per code.
+func (s *PromoCodeService) batchUsage(reqs []domain.ValidatePromoteCodeRequest, checked []bool, counts map[string]int) usageLookup {
+	limited := make(map[string][]string) // Customer ID -> codes with usage limits
+	for i, req := range reqs {
+		if checked[i] || !isValidCount(counts[req.PromoteCode]) {
+			continue
+		}
+		if campaign, ok := s.campaigns.resolve(req.PromoteCode); ok && limitsFor(campaign) != (RedemptionLimits{}) {
+			limited[req.CustomerID] = append(limited[req.CustomerID], req.PromoteCode)
+		}
+	}
+
+	usages := make(map[string]map[string]RedemptionUsage, len(limited))
+	failures := make(map[string]error)
+	for customerID, codes := range limited {
+		usages[customerID], failures[customerID] = s.redemptions.UsageMany(codes, customerID)
+	}
+	return func(code, customerID string) (RedemptionUsage, error) {
+		if err := failures[customerID]; err != nil {
+			return RedemptionUsage{}, err
+		}
+		return usages[customerID][code], nil
+	}
 }
+
+// precheck rejects codes that can be judged without a repository lookup. ok is false when result is final.
+func (s *PromoCodeService) precheck(code string) (result domain.PromoValidationResult, ok bool) {
+	lengthMessage := fmt.Sprintf("Promo code must be between %d and %d characters long.", promoCodeMinLength, promoCodeMaxLength)
+	if len(code) < promoCodeMinLength {
+		return domain.PromoValidationResult{Reason: domain.PromoReasonTooShort, Message: lengthMessage}, false
+	}
+	if len(code) > promoCodeMaxLength {
+		return domain.PromoValidationResult{Reason: domain.PromoReasonTooLong, Message: lengthMessage}, false
+	}
+	if !s.IsReady() {
+		return domain.PromoValidationResult{Reason: domain.PromoReasonNotLoaded, Message: domain.ErrPromoCodesNotLoaded.Error()}, false
+	}
+	return domain.PromoValidationResult{}, true
+}
+
+// validateCount finishes validating a code given its source count: campaign schedule and usage limits,
+// with the usage read through usage.
+func (s *PromoCodeService) validateCount(code, customerID string, count int, exists bool, usage usageLookup) domain.PromoValidationResult {
+	if !exists || !isValidCount(count) {
+		return domain.PromoValidationResult{Reason: domain.PromoReasonNotEnoughSources, Message: "Promo code not found in at least two files."}
+	}
+
+	campaign, ok := s.campaigns.resolve(code)
+	if !ok {
+		return domain.PromoValidationResult{Valid: true, Reason: domain.PromoReasonValid, Message: "Promo code is valid."}
+	}
+	if reason, why := s.campaigns.activeAt(campaign, s.now()); reason != "" {
+		return domain.PromoValidationResult{
+			Reason:   reason,
+			Message:  fmt.Sprintf("Promo code is valid but cannot be used now: campaign '%s' %s.", campaign.ID, why),
+			Campaign: &campaign,
+		}
+	}
+
+	limits := limitsFor(campaign)
+	used, err := usage(code, customerID)
+	if err != nil {
+		// Reserving a use when the order is placed enforces the limits again, so validation stays available.
+		log.Printf("ERROR: Failed to read usage of promo code '%s', skipping the usage limit check: %v", code, err)
+		return domain.PromoValidationResult{Valid: true, Reason: domain.PromoReasonValid, Message: "Promo code is valid.", Campaign: &campaign}
+	}
+	if limits.MaxUses > 0 && used.Used >= limits.MaxUses {
+		return domain.PromoValidationResult{Reason: domain.PromoReasonExhausted, Message: "Promo code has reached its usage limit.", Campaign: &campaign}
+	}
+	if customerID != "" && limits.MaxUsesPerCustomer > 0 && used.CustomerUsed >= limits.MaxUsesPerCustomer {
+		return domain.PromoValidationResult{Reason: domain.PromoReasonCustomerLimitReached, Message: "Promo code has already been used the maximum number of times by this customer.", Campaign: &campaign}
+	}
+
+	return domain.PromoValidationResult{Valid: true, Reason: domain.PromoReasonValid, Message: "Promo code is valid.", Campaign: &campaign}
+}
+
+// ResolveCampaign returns the campaign a promo code belongs to. It does not validate the code itself.
+func (s *PromoCodeService) ResolveCampaign(code string) (domain.Campaign, bool) {
+	return s.campaigns.resolve(code)
+}
+
+// ActiveAutomaticCampaigns returns the campaigns that apply to every order without a code and whose
+// schedule allows them right now.
+func (s *PromoCodeService) ActiveAutomaticCampaigns() []domain.Campaign {
+	now := s.now()
+	var active []domain.Campaign
+	for _, campaign := range s.campaigns.automatic {
+		if reason, _ := s.campaigns.activeAt(campaign, now); reason == "" {
+			active = append(active, campaign)
+		}
+	}
+	return active
+}
+
+// ReserveRedemption holds one use of a code for an order that is being created, enforcing the usage
+// limits of the code's campaign. The reservation must be committed or released once the order is
+// saved or abandoned.
+func (s *PromoCodeService) ReserveRedemption(code, customerID string) (string, error) {
+	campaign, ok := s.campaigns.resolve(code)
+	if !ok {
+		return "", fmt.Errorf("%w: no campaign matches '%s'", domain.ErrInvalidPromoCode, code)
+	}
+	return s.redemptions.Reserve(code, customerID, limitsFor(campaign))
+}
+
+// CommitRedemption records a reserved use as redeemed by orderID.
+func (s *PromoCodeService) CommitRedemption(reservationID, orderID string) error {
+	return s.redemptions.Commit(reservationID, orderID)
+}
+
+// ReleaseRedemption gives a reserved use back, e.g. when the order could not be saved.
+func (s *PromoCodeService) ReleaseRedemption(reservationID string) error {
+	return s.redemptions.Release(reservationID)
+}
+
+// RevokeRedemptions gives back the uses redeemed by an order that was cancelled or fully refunded.
+func (s *PromoCodeService) RevokeRedemptions(orderID string) (int, error) {
+	return s.redemptions.Revoke(orderID)
+}
+
+// GetPromoCodeUsage reports the uses and remaining uses of a code, overall and for customerID if given.
+func (s *PromoCodeService) GetPromoCodeUsage(code, customerID string) (domain.PromoCodeUsage, error) {
+	campaign, ok := s.campaigns.resolve(code)
+	if !ok {
+		return domain.PromoCodeUsage{}, fmt.Errorf("%w: no campaign matches '%s'", domain.ErrInvalidPromoCode, code)
+	}
+	limits := limitsFor(campaign)
+	usage, err := s.redemptions.Usage(code, customerID)
+	if err != nil {
+		return domain.PromoCodeUsage{}, err
+	}
+
+	report := domain.PromoCodeUsage{
+		PromoCode:          code,
+		CampaignID:         campaign.ID,
+		Used:               usage.Used,
+		MaxUses:            limits.MaxUses,
+		Remaining:          remainingUses(limits.MaxUses, usage.Used),
+		CustomerID:         customerID,
+		MaxUsesPerCustomer: limits.MaxUsesPerCustomer,
+	}
+	if customerID != "" {
+		report.CustomerUsed = usage.CustomerUsed
+		report.CustomerRemaining = remainingUses(limits.MaxUsesPerCustomer, usage.CustomerUsed)
+	}
+	return report, nil
+}
+
+// remainingUses returns nil for an unlimited code, otherwise the uses left (never negative).
+func remainingUses(limit, used int) *int {
+	if limit <= 0 {
+		return nil
+	}
+	remaining := max(limit-used, 0)
+	return &remaining
+}
+
 func (s *PromoCodeService) GetPromoCodeCounts() map[string]int {
 	return s.repo.GetAllCounts()
 }
 
+// ListDatasetVersions returns the retained dataset versions, newest first.
+func (s *PromoCodeService) ListDatasetVersions() []domain.PromoDatasetVersion {
+	return s.versions.List()
+}
+
+// DiffDatasetVersions reports the codes added, removed and changed in validity between two versions.
+func (s *PromoCodeService) DiffDatasetVersions(from, to int, limit int) (domain.PromoDatasetDiff, error) {
+	fromCounts, ok := s.versions.Counts(from)
+	if !ok {
+		return domain.PromoDatasetDiff{}, fmt.Errorf("%w: %d", domain.ErrDatasetVersionNotFound, from)
+	}
+	toCounts, ok := s.versions.Counts(to)
+	if !ok {
+		return domain.PromoDatasetDiff{}, fmt.Errorf("%w: %d", domain.ErrDatasetVersionNotFound, to)
+	}
+
+	diff := diffCounts(fromCounts, toCounts, limit)
+	diff.From, diff.To = from, to
+	return diff, nil
+}
+
+// LookupCodeInVersion reports whether a code was valid in a past dataset version.
+func (s *PromoCodeService) LookupCodeInVersion(version int, code string) (domain.PromoCodeVersionLookup, error) {
+	counts, ok := s.versions.Counts(version)
+	if !ok {
+		return domain.PromoCodeVersionLookup{}, fmt.Errorf("%w: %d", domain.ErrDatasetVersionNotFound, version)
+	}
+	count, found := counts[code]
+	return domain.PromoCodeVersionLookup{
+		Version:   version,
+		PromoCode: code,
+		Found:     found,
+		Sources:   count,
+		Valid:     found && isValidCount(count) && len(code) >= promoCodeMinLength && len(code) <= promoCodeMaxLength,
+	}, nil
+}
+
+// IsReady reports whether a promo code dataset has been loaded and can be validated against.
+func (s *PromoCodeService) IsReady() bool {
+	return s.ready.Load()
+}
+
+// MarkForRecheck remembers a code that was accepted before the dataset finished loading,
+// so it is re-validated once the load completes.
+func (s *PromoCodeService) MarkForRecheck(code string) {
+	s.recheckMu.Lock()
+	defer s.recheckMu.Unlock()
+
+	if len(s.pendingRechecks) >= maxPendingRechecks {
+		log.Printf("WARN: Pending re-check list is full, promo code '%s' will not be re-checked", code)
+		return
+	}
+	s.pendingRechecks[code] = struct{}{}
+}
+
+// GetRechecks returns the codes waiting to be re-checked, sorted, and the results of earlier re-checks.
+func (s *PromoCodeService) GetRechecks() domain.PromoRechecks {
+	s.recheckMu.Lock()
+	defer s.recheckMu.Unlock()
+
+	rechecks := domain.PromoRechecks{
+		Pending: make([]string, 0, len(s.pendingRechecks)),
+		Results: slices.Clone(s.recheckResults),
+	}
+	for code := range s.pendingRechecks {
+		rechecks.Pending = append(rechecks.Pending, code)
+	}
+	sort.Strings(rechecks.Pending)
+	if rechecks.Results == nil {
+		rechecks.Results = []domain.PromoRecheck{}
+	}
+	return rechecks
+}
+
+// GetLoadStatus returns a snapshot of the current or most recent promo code load.
+func (s *PromoCodeService) GetLoadStatus() domain.PromoLoadStatus {
+	return s.tracker.Snapshot()
+}
+
 func (s *PromoCodeService) Close() error {
 	log.Println("Closing PromoCodeService BigCache...")
 	return s.bigCache.Close()
 }
+
+// contextReader fails reads once its context is done, so long copies stop promptly on cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// isValidCount reports whether a code seen in count files meets the validity threshold.
+func isValidCount(count int) bool {
+	return count >= minSourcesForValid
+}
@@ -1,11 +1,47 @@
 package promos
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"kart-challenge/internal/domain"
+	"kart-challenge/internal/pricing"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// writeCouponFile writes a gzipped coupon file with one code per line into dir.
+func writeCouponFile(t *testing.T, dir, name string, codes ...string) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("failed to create coupon file: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write([]byte(strings.Join(codes, "\n") + "\n")); err != nil {
+		t.Fatalf("failed to write coupon file: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+}
+
 func TestPromoCodeService_ValidatePromoCode(t *testing.T) {
-	service := NewService(1, "production", "") // Use small size, production env (no local files needed for validate)
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production"}) // Use small size, production env (no local files needed for validate)
 	defer service.Close()
 
 	// Manually set counts for testing validation logic
@@ -17,25 +53,805 @@ func TestPromoCodeService_ValidatePromoCode(t *testing.T) {
 	inMemRepo.promoCodeCounts["SHORT"] = 5            // Too short
 	inMemRepo.mu.Unlock()
 
+	if result := service.ValidatePromoCode("VALIDCODE", ""); result.Valid || result.Reason != domain.PromoReasonNotLoaded {
+		t.Errorf("Expected NOT_LOADED before the dataset is ready, got %+v", result)
+	}
+	service.(*PromoCodeService).ready.Store(true)
+
 	tests := []struct {
-		code          string
-		expectedValid bool
-		expectedMsg   string
+		code           string
+		expectedValid  bool
+		expectedReason string
+		expectedMsg    string
 	}{
-		{"VALIDCODE", true, "Promo code is valid."},
-		{"SINGLEFILE", false, "Promo code not found in at least two files."},
-		{"TOOLONGCODE", false, "Promo code must be between 8 and 10 characters long."},
+		{"VALIDCODE", true, domain.PromoReasonValid, "Promo code is valid."},
+		{"SINGLEFILE", false, domain.PromoReasonNotEnoughSources, "Promo code not found in at least two files."},
+		{"TOOLONGCODE", false, domain.PromoReasonTooLong, "Promo code must be between 8 and 10 characters long."},
+		{"SHORT", false, domain.PromoReasonTooShort, "Promo code must be between 8 and 10 characters long."},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.code, func(t *testing.T) {
-			isValid, msg := service.ValidatePromoCode(tt.code)
-			if isValid != tt.expectedValid {
-				t.Errorf("For code '%s', expected valid %t, got %t", tt.code, tt.expectedValid, isValid)
+			result := service.ValidatePromoCode(tt.code, "")
+			if result.Valid != tt.expectedValid {
+				t.Errorf("For code '%s', expected valid %t, got %t", tt.code, tt.expectedValid, result.Valid)
+			}
+			if result.Reason != tt.expectedReason {
+				t.Errorf("For code '%s', expected reason %s, got %s", tt.code, tt.expectedReason, result.Reason)
+			}
+			if result.Message != tt.expectedMsg {
+				t.Errorf("For code '%s', expected message '%s', got '%s'", tt.code, tt.expectedMsg, result.Message)
+			}
+		})
+	}
+}
+
+func TestPromoCodeService_LoadPromoCodesFromURLs_TracksProgress(t *testing.T) {
+	dir := t.TempDir()
+	writeCouponFile(t, dir, "couponbase1.gz", "HAPPYHRS", "FIFTYOFF", "SHORT")
+	writeCouponFile(t, dir, "couponbase2.gz", "HAPPYHRS", "SUPER100")
+	writeCouponFile(t, dir, "couponbase3.gz", "FIFTYOFF")
+
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "development", LocalCouponDirPath: dir})
+	defer service.Close()
+
+	if state := service.GetLoadStatus().State; state != domain.PromoLoadStateIdle {
+		t.Fatalf("Expected idle state before loading, got %s", state)
+	}
+	if service.IsReady() {
+		t.Fatalf("Expected service not to be ready before loading")
+	}
+	// Accepted optimistically while loading, re-checked afterwards
+	service.MarkForRecheck("SUPER100")
+	service.MarkForRecheck("HAPPYHRS")
+	if rechecks := service.GetRechecks(); !slices.Equal(rechecks.Pending, []string{"HAPPYHRS", "SUPER100"}) || len(rechecks.Results) != 0 {
+		t.Fatalf("Expected both codes pending before the load, got %+v", rechecks)
+	}
+
+	urls := []string{
+		"https://example.invalid/couponbase1.gz",
+		"https://example.invalid/couponbase2.gz",
+		"https://example.invalid/couponbase3.gz",
+	}
+	if err := service.LoadPromoCodesFromURLs(context.Background(), urls); err != nil {
+		t.Fatalf("Unexpected load error: %v", err)
+	}
+
+	if !service.IsReady() {
+		t.Errorf("Expected service to be ready after a successful load")
+	}
+
+	status := service.GetLoadStatus()
+	if status.State != domain.PromoLoadStateCompleted {
+		t.Errorf("Expected state %s, got %s", domain.PromoLoadStateCompleted, status.State)
+	}
+	if status.UniqueCodes != 3 {
+		t.Errorf("Expected 3 unique codes, got %d", status.UniqueCodes)
+	}
+	if status.StartedAt == nil || status.FinishedAt == nil {
+		t.Errorf("Expected start and finish times to be recorded")
+	}
+	if len(status.Sources) != len(urls) {
+		t.Fatalf("Expected %d sources, got %d", len(urls), len(status.Sources))
+	}
+
+	expectedLines := []int64{3, 2, 1}
+	for i, src := range status.Sources {
+		if src.Phase != domain.PromoLoadPhaseDone {
+			t.Errorf("Source %d: expected phase %s, got %s", i, domain.PromoLoadPhaseDone, src.Phase)
+		}
+		if src.Origin != "local" {
+			t.Errorf("Source %d: expected local origin, got %q", i, src.Origin)
+		}
+		if src.LinesScanned != expectedLines[i] {
+			t.Errorf("Source %d: expected %d lines scanned, got %d", i, expectedLines[i], src.LinesScanned)
+		}
+		if src.BytesDecompressed == 0 {
+			t.Errorf("Source %d: expected decompressed bytes to be counted", i)
+		}
+	}
+
+	if result := service.ValidatePromoCode("HAPPYHRS", ""); !result.Valid {
+		t.Errorf("Expected HAPPYHRS to be valid after load")
+	}
+	if result := service.ValidatePromoCode("SUPER100", ""); result.Valid {
+		t.Errorf("Expected SUPER100 to be invalid after load")
+	}
+
+	rechecks := service.GetRechecks()
+	if len(rechecks.Pending) != 0 {
+		t.Errorf("Expected no pending re-checks after the load, got %v", rechecks.Pending)
+	}
+	if len(rechecks.Results) != 2 {
+		t.Fatalf("Expected 2 re-check results, got %+v", rechecks.Results)
+	}
+	if got := rechecks.Results[0]; got.Code != "HAPPYHRS" || !got.Valid || got.Reason != domain.PromoReasonValid {
+		t.Errorf("Expected HAPPYHRS to pass re-check, got %+v", got)
+	}
+	if got := rechecks.Results[1]; got.Code != "SUPER100" || got.Valid || got.Reason != domain.PromoReasonNotEnoughSources || got.CheckedAt.IsZero() {
+		t.Errorf("Expected SUPER100 to fail re-check with %s, got %+v", domain.PromoReasonNotEnoughSources, got)
+	}
+}
+
+func TestPromoCodeService_LoadPromoCodesFromURLs_Cancelled(t *testing.T) {
+	dir := t.TempDir()
+	writeCouponFile(t, dir, "couponbase1.gz", "HAPPYHRS")
+	writeCouponFile(t, dir, "couponbase2.gz", "HAPPYHRS")
+
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "development", LocalCouponDirPath: dir})
+	defer service.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := service.LoadPromoCodesFromURLs(ctx, []string{
+		"https://example.invalid/couponbase1.gz",
+		"https://example.invalid/couponbase2.gz",
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if state := service.GetLoadStatus().State; state != domain.PromoLoadStateFailed {
+		t.Errorf("Expected state %s after cancellation, got %s", domain.PromoLoadStateFailed, state)
+	}
+	if service.IsReady() {
+		t.Errorf("Expected service not to be ready after a cancelled load")
+	}
+	if counts := service.GetPromoCodeCounts(); len(counts) != 0 {
+		t.Errorf("Expected no codes to be kept after cancellation, got %d", len(counts))
+	}
+}
+
+func TestPromoCodeService_LoadPromoCodesFromURLs_VerifiesSources(t *testing.T) {
+	dir := t.TempDir()
+	writeCouponFile(t, dir, "couponbase1.gz", "HAPPYHRS")
+	writeCouponFile(t, dir, "couponbase2.gz", "HAPPYHRS")
+
+	raw1, err := os.ReadFile(filepath.Join(dir, "couponbase1.gz"))
+	if err != nil {
+		t.Fatalf("failed to read coupon file: %v", err)
+	}
+	raw2, err := os.ReadFile(filepath.Join(dir, "couponbase2.gz"))
+	if err != nil {
+		t.Fatalf("failed to read coupon file: %v", err)
+	}
+	sum1 := sha256.Sum256(raw1)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	prehash := sha512.Sum512(raw2)
+	sig, err := privateKey.Sign(nil, prehash[:], &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		t.Fatalf("failed to sign coupon file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "couponbase2.gz.sig"), []byte(base64.StdEncoding.EncodeToString(sig)), 0o600); err != nil {
+		t.Fatalf("failed to write signature: %v", err)
+	}
+
+	urls := []string{"https://example.invalid/couponbase1.gz", "https://example.invalid/couponbase2.gz"}
+
+	t.Run("valid checksum and signature", func(t *testing.T) {
+		service := NewService(Config{
+			MaxDecompressedFileSizeMB: 1,
+			Environment:               "development",
+			LocalCouponDirPath:        dir,
+			Integrity: IntegrityConfig{
+				Sources: map[string]SourceIntegrity{
+					"couponbase1.gz": {SHA256: hex.EncodeToString(sum1[:])},
+					"couponbase2.gz": {SignatureURL: "https://example.invalid/couponbase2.gz.sig"},
+				},
+				PublicKey: publicKey,
+			},
+		})
+		defer service.Close()
+
+		if err := service.LoadPromoCodesFromURLs(context.Background(), urls); err != nil {
+			t.Fatalf("Unexpected load error: %v", err)
+		}
+		status := service.GetLoadStatus()
+		if got := status.Sources[0].Verification; got != domain.PromoVerificationSHA256 {
+			t.Errorf("Expected source 1 verified by %s, got %s", domain.PromoVerificationSHA256, got)
+		}
+		if got := status.Sources[1].Verification; got != domain.PromoVerificationSignature {
+			t.Errorf("Expected source 2 verified by %s, got %s", domain.PromoVerificationSignature, got)
+		}
+		if got := status.Sources[0].SHA256; got != hex.EncodeToString(sum1[:]) {
+			t.Errorf("Expected recorded digest %x, got %s", sum1, got)
+		}
+		if result := service.ValidatePromoCode("HAPPYHRS", ""); !result.Valid {
+			t.Errorf("Expected HAPPYHRS to be valid after a verified load")
+		}
+	})
+
+	t.Run("checksum mismatch quarantines the download", func(t *testing.T) {
+		quarantineDir := t.TempDir()
+		service := NewService(Config{
+			MaxDecompressedFileSizeMB: 1,
+			Environment:               "development",
+			LocalCouponDirPath:        dir,
+			Integrity: IntegrityConfig{
+				Sources: map[string]SourceIntegrity{
+					"couponbase1.gz": {SHA256: strings.Repeat("0", 64)},
+				},
+				QuarantineDir: quarantineDir,
+			},
+		})
+		defer service.Close()
+
+		err := service.LoadPromoCodesFromURLs(context.Background(), urls)
+		if !errors.Is(err, domain.ErrSourceVerificationFailed) {
+			t.Fatalf("Expected verification error, got %v", err)
+		}
+		status := service.GetLoadStatus()
+		if status.State != domain.PromoLoadStateFailed {
+			t.Errorf("Expected load state %s, got %s", domain.PromoLoadStateFailed, status.State)
+		}
+		if status.Sources[0].QuarantinePath == "" {
+			t.Fatalf("Expected quarantine path to be recorded")
+		}
+		if _, err := os.Stat(status.Sources[0].QuarantinePath); err != nil {
+			t.Errorf("Expected quarantined file to exist: %v", err)
+		}
+		if result := service.ValidatePromoCode("HAPPYHRS", ""); result.Valid {
+			t.Errorf("Expected no codes to be served after a failed verification")
+		}
+	})
+
+	t.Run("signature from the wrong key is rejected", func(t *testing.T) {
+		otherKey, _, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		service := NewService(Config{
+			MaxDecompressedFileSizeMB: 1,
+			Environment:               "development",
+			LocalCouponDirPath:        dir,
+			Integrity: IntegrityConfig{
+				Sources: map[string]SourceIntegrity{
+					"couponbase2.gz": {SignatureURL: "https://example.invalid/couponbase2.gz.sig"},
+				},
+				PublicKey: otherKey,
+			},
+		})
+		defer service.Close()
+
+		if err := service.LoadPromoCodesFromURLs(context.Background(), urls); !errors.Is(err, domain.ErrSourceVerificationFailed) {
+			t.Fatalf("Expected verification error, got %v", err)
+		}
+	})
+}
+
+func TestPromoCodeService_DiffDatasetVersions(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "development", LocalCouponDirPath: dir, MaxDatasetVersions: 2,
+		Now: func() time.Time { return now }})
+	defer service.Close()
+
+	urls := []string{"https://example.invalid/couponbase1.gz", "https://example.invalid/couponbase2.gz"}
+	load := func(file1, file2 []string) {
+		t.Helper()
+		writeCouponFile(t, dir, "couponbase1.gz", file1...)
+		writeCouponFile(t, dir, "couponbase2.gz", file2...)
+		if err := service.LoadPromoCodesFromURLs(context.Background(), urls); err != nil {
+			t.Fatalf("Unexpected load error: %v", err)
+		}
+	}
+
+	load([]string{"KEEPVALID", "LOSEVALID", "REMOVEDME"}, []string{"KEEPVALID", "LOSEVALID"})
+	now = now.Add(time.Hour)
+	load([]string{"KEEPVALID", "LOSEVALID", "GAINVALID"}, []string{"KEEPVALID", "GAINVALID", "NEWSINGLE"})
+
+	versions := service.ListDatasetVersions()
+	if len(versions) != 2 || versions[0].ID != 2 || versions[1].ID != 1 {
+		t.Fatalf("Expected versions [2 1], got %+v", versions)
+	}
+	if !versions[0].LoadedAt.Equal(now) || !versions[1].LoadedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected versions stamped by the service clock, got %s and %s", versions[0].LoadedAt, versions[1].LoadedAt)
+	}
+	if versions[0].Sources[0].SHA256 == "" {
+		t.Errorf("Expected source fingerprints to be recorded")
+	}
+	if versions[0].ValidCodes != 2 {
+		t.Errorf("Expected 2 valid codes in version 2, got %d", versions[0].ValidCodes)
+	}
+
+	diff, err := service.DiffDatasetVersions(1, 2, 0)
+	if err != nil {
+		t.Fatalf("Unexpected diff error: %v", err)
+	}
+	if strings.Join(diff.Added, ",") != "GAINVALID,NEWSINGLE" {
+		t.Errorf("Unexpected added codes: %v", diff.Added)
+	}
+	if strings.Join(diff.Removed, ",") != "REMOVEDME" {
+		t.Errorf("Unexpected removed codes: %v", diff.Removed)
+	}
+	if len(diff.ValidityChanged) != 1 || diff.ValidityChanged[0] != (domain.PromoValidityChange{Code: "LOSEVALID", WasValid: true, IsValid: false}) {
+		t.Errorf("Unexpected validity changes: %+v", diff.ValidityChanged)
+	}
+
+	lookup, err := service.LookupCodeInVersion(1, "LOSEVALID")
+	if err != nil || !lookup.Valid {
+		t.Errorf("Expected LOSEVALID to be valid in version 1, got %+v (err %v)", lookup, err)
+	}
+
+	// A third load pushes version 1 out of the retained history.
+	load([]string{"KEEPVALID"}, []string{"KEEPVALID"})
+	if _, err := service.DiffDatasetVersions(1, 3, 0); !errors.Is(err, domain.ErrDatasetVersionNotFound) {
+		t.Errorf("Expected version 1 to be evicted, got %v", err)
+	}
+}
+
+func TestPromoCodeService_ResolveCampaign(t *testing.T) {
+	campaigns := []domain.Campaign{
+		{ID: "default", Type: domain.CampaignTypePercentage, PercentOff: 10, Default: true},
+		{ID: "happy", Type: domain.CampaignTypePercentage, PercentOff: 20, CodePrefix: "HAPPY"},
+		{ID: "happy-hours", Type: domain.CampaignTypeFixedAmount, AmountOff: 500, CodePrefix: "HAPPYHR"},
+		{ID: "fifty", Type: domain.CampaignTypePercentage, PercentOff: 50, Codes: []string{"FIFTYOFF", "HAPPYFIFTY"}},
+	}
+	for _, c := range campaigns {
+		if err := ValidateCampaign(c); err != nil {
+			t.Fatalf("Campaign %s should be valid: %v", c.ID, err)
+		}
+	}
+
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production", Campaigns: campaigns})
+	defer service.Close()
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"FIFTYOFF", "fifty"},       // Explicit list
+		{"HAPPYFIFTY", "fifty"},     // Explicit list beats prefix
+		{"HAPPYHRS", "happy-hours"}, // Longest prefix wins
+		{"HAPPYDAYS", "happy"},
+		{"RANDOM123", "default"},
+	}
+	for _, tt := range tests {
+		campaign, ok := service.ResolveCampaign(tt.code)
+		if !ok || campaign.ID != tt.expected {
+			t.Errorf("For code '%s', expected campaign %s, got %q (found %t)", tt.code, tt.expected, campaign.ID, ok)
+		}
+	}
+
+	if err := ValidateCampaign(domain.Campaign{ID: "broken", Type: domain.CampaignTypePercentage, PercentOff: 150, Default: true}); !errors.Is(err, domain.ErrInvalidCampaign) {
+		t.Errorf("Expected invalid campaign error, got %v", err)
+	}
+}
+
+func TestLoadCampaignsFromFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write campaign file: %v", err)
+		}
+		return path
+	}
+
+	campaigns, err := LoadCampaignsFromFile(write("ok.json", `[
+		{"id": "fifty", "type": "percentage", "percent_off": 50, "codes": ["FIFTYOFF"]},
+		{"id": "default", "type": "percentage", "percent_off": 10, "default": true}
+	]`))
+	if err != nil || len(campaigns) != 2 {
+		t.Fatalf("Expected 2 campaigns, got %d (err %v)", len(campaigns), err)
+	}
+
+	_, err = LoadCampaignsFromFile(write("duplicate.json", `[
+		{"id": "fifty", "type": "percentage", "percent_off": 50, "codes": ["FIFTYOFF"]},
+		{"id": "fifty", "type": "fixed_amount", "amount_off": 5, "code_prefix": "HAPPY"}
+	]`))
+	if !errors.Is(err, domain.ErrInvalidCampaign) || !strings.Contains(err.Error(), "'fifty'") {
+		t.Errorf("Expected a duplicate campaign id error, got %v", err)
+	}
+}
+
+func TestPromoCodeService_Redemptions(t *testing.T) {
+	campaigns := []domain.Campaign{
+		{ID: "limited", Type: domain.CampaignTypePercentage, PercentOff: 10, MaxUses: 10, Codes: []string{"LIMITED10"}},
+		{ID: "once", Type: domain.CampaignTypePercentage, PercentOff: 10, SingleUse: true, Codes: []string{"ONLYONCE1"}},
+		{ID: "per-customer", Type: domain.CampaignTypePercentage, PercentOff: 10, MaxUsesPerCustomer: 1, Codes: []string{"WELCOME01"}},
+		{ID: "unlimited", Type: domain.CampaignTypePercentage, PercentOff: 10, Default: true},
+	}
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production", Campaigns: campaigns})
+	defer service.Close()
+
+	inMemRepo := service.(*PromoCodeService).repo.(*inMemoryPromoCodeRepository)
+	inMemRepo.mu.Lock()
+	inMemRepo.promoCodeCounts["LIMITED10"] = 2
+	inMemRepo.promoCodeCounts["WELCOME01"] = 2
+	inMemRepo.mu.Unlock()
+	service.(*PromoCodeService).ready.Store(true)
+
+	t.Run("Concurrent reservations never exceed the limit", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var succeeded atomic.Int32
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id, err := service.ReserveRedemption("LIMITED10", "")
+				if err == nil {
+					succeeded.Add(1)
+					if err := service.CommitRedemption(id, fmt.Sprintf("order-%d", i)); err != nil {
+						t.Errorf("Unexpected commit error: %v", err)
+					}
+				} else if !errors.Is(err, domain.ErrPromoCodeExhausted) {
+					t.Errorf("Expected exhausted error, got %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if succeeded.Load() != 10 {
+			t.Errorf("Expected exactly 10 redemptions, got %d", succeeded.Load())
+		}
+		usage, err := service.GetPromoCodeUsage("LIMITED10", "")
+		if err != nil || usage.Used != 10 || usage.Remaining == nil || *usage.Remaining != 0 {
+			t.Errorf("Expected 10 used and 0 remaining, got %+v (err %v)", usage, err)
+		}
+		if result := service.ValidatePromoCode("LIMITED10", ""); result.Valid || result.Reason != domain.PromoReasonExhausted {
+			t.Errorf("Expected an exhausted code to fail validation with EXHAUSTED, got %+v", result)
+		}
+	})
+
+	t.Run("Released reservations return the use", func(t *testing.T) {
+		id, err := service.ReserveRedemption("ONLYONCE1", "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := service.ReserveRedemption("ONLYONCE1", ""); !errors.Is(err, domain.ErrPromoCodeExhausted) {
+			t.Errorf("Expected a held single-use code to be exhausted, got %v", err)
+		}
+		if err := service.ReleaseRedemption(id); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := service.ReserveRedemption("ONLYONCE1", ""); err != nil {
+			t.Errorf("Expected the released use to be available again, got %v", err)
+		}
+	})
+
+	t.Run("Per customer limit", func(t *testing.T) {
+		if _, err := service.ReserveRedemption("WELCOME01", ""); !errors.Is(err, domain.ErrCustomerIDRequired) {
+			t.Errorf("Expected customer ID to be required, got %v", err)
+		}
+		if _, err := service.ReserveRedemption("WELCOME01", "alice"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := service.ReserveRedemption("WELCOME01", "alice"); !errors.Is(err, domain.ErrPromoCodeCustomerLimit) {
+			t.Errorf("Expected customer limit error, got %v", err)
+		}
+		if result := service.ValidatePromoCode("WELCOME01", "alice"); result.Reason != domain.PromoReasonCustomerLimitReached {
+			t.Errorf("Expected CUSTOMER_LIMIT_REACHED, got %+v", result)
+		}
+		if _, err := service.ReserveRedemption("WELCOME01", "bob"); err != nil {
+			t.Errorf("Expected another customer to redeem the code, got %v", err)
+		}
+
+		usage, _ := service.GetPromoCodeUsage("WELCOME01", "alice")
+		if usage.Used != 2 || usage.Remaining != nil || usage.CustomerRemaining == nil || *usage.CustomerRemaining != 0 {
+			t.Errorf("Unexpected usage report: %+v", usage)
+		}
+	})
+
+	t.Run("Revoking an order's redemptions returns the uses", func(t *testing.T) {
+		id, err := service.ReserveRedemption("WELCOME01", "carol")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := service.CommitRedemption(id, "order-carol"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if revoked, err := service.RevokeRedemptions("order-carol"); err != nil || revoked != 1 {
+			t.Fatalf("Expected one revoked redemption, got %d (err %v)", revoked, err)
+		}
+		if revoked, _ := service.RevokeRedemptions("order-carol"); revoked != 0 {
+			t.Errorf("Expected revoking twice to be a no-op, got %d", revoked)
+		}
+		if _, err := service.ReserveRedemption("WELCOME01", "carol"); err != nil {
+			t.Errorf("Expected carol to be able to redeem again, got %v", err)
+		}
+	})
+
+	t.Run("Unlimited code", func(t *testing.T) {
+		usage, err := service.GetPromoCodeUsage("ANYCODE12", "")
+		if err != nil || usage.CampaignID != "unlimited" || usage.Remaining != nil {
+			t.Errorf("Expected an unlimited usage report, got %+v (err %v)", usage, err)
+		}
+	})
+}
+
+func TestPromoCodeService_ValidatePromoCode_Schedule(t *testing.T) {
+	startsAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	endsAt := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	campaigns := []domain.Campaign{{
+		ID: "happy-hours", Type: domain.CampaignTypeFixedAmount, AmountOff: 500, CodePrefix: "HAPPYHR",
+		Schedule: &domain.CampaignSchedule{
+			StartsAt: &startsAt,
+			EndsAt:   &endsAt,
+			TimeZone: "Asia/Singapore",
+			Windows: []domain.WeeklyWindow{
+				{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "17:00", End: "19:00"},
+				{Days: []string{"fri"}, Start: "22:00", End: "02:00"}, // Runs into Saturday
+			},
+		},
+	}}
+	if err := ValidateCampaign(campaigns[0]); err != nil {
+		t.Fatalf("Campaign should be valid: %v", err)
+	}
+
+	var now time.Time
+	service := NewService(Config{
+		MaxDecompressedFileSizeMB: 1,
+		Environment:               "production",
+		Campaigns:                 campaigns,
+		Now:                       func() time.Time { return now },
+	})
+	defer service.Close()
+
+	inMemRepo := service.(*PromoCodeService).repo.(*inMemoryPromoCodeRepository)
+	inMemRepo.mu.Lock()
+	inMemRepo.promoCodeCounts["HAPPYHRS"] = 2
+	inMemRepo.promoCodeCounts["OTHERCODE"] = 2
+	inMemRepo.mu.Unlock()
+	service.(*PromoCodeService).ready.Store(true)
+
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, singapore)
+	}
+
+	tests := []struct {
+		name           string
+		now            time.Time
+		expectedReason string
+		expectedMsg    string
+	}{
+		{"Weekday happy hour", at(2026, 10, 16, 17, 30), domain.PromoReasonValid, "Promo code is valid."},
+		{"Window end is exclusive", at(2026, 10, 16, 19, 0), domain.PromoReasonOutsideWindow, "only active"},
+		{"Weekend afternoon", at(2026, 10, 17, 18, 0), domain.PromoReasonOutsideWindow, "only active"},
+		{"Overnight window after midnight", at(2026, 10, 17, 1, 30), domain.PromoReasonValid, "Promo code is valid."},
+		{"Before the campaign starts", at(2025, 12, 31, 17, 30), domain.PromoReasonNotStarted, "starts at"},
+		{"After the campaign ends", at(2027, 1, 1, 17, 30), domain.PromoReasonExpired, "ended at"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = tt.now
+			result := service.ValidatePromoCode("HAPPYHRS", "")
+			if result.Reason != tt.expectedReason || !strings.Contains(result.Message, tt.expectedMsg) {
+				t.Errorf("Expected %s with message containing %q, got %s %q", tt.expectedReason, tt.expectedMsg, result.Reason, result.Message)
 			}
-			if msg != tt.expectedMsg {
-				t.Errorf("For code '%s', expected message '%s', got '%s'", tt.code, tt.expectedMsg, msg)
+			if result.Valid != (tt.expectedReason == domain.PromoReasonValid) || result.Campaign == nil || result.Campaign.ID != "happy-hours" {
+				t.Errorf("Unexpected result: %+v", result)
 			}
 		})
 	}
+
+	// Codes outside the scheduled campaign are unaffected.
+	now = at(2026, 10, 17, 18, 0)
+	if result := service.ValidatePromoCode("OTHERCODE", ""); !result.Valid {
+		t.Errorf("Expected an unscheduled code to be valid, got %q", result.Message)
+	}
+
+	broken := domain.Campaign{ID: "broken", Type: domain.CampaignTypePercentage, PercentOff: 10, Default: true,
+		Schedule: &domain.CampaignSchedule{Windows: []domain.WeeklyWindow{{Days: []string{"someday"}, Start: "09:00", End: "10:00"}}}}
+	if err := ValidateCampaign(broken); !errors.Is(err, domain.ErrInvalidCampaign) {
+		t.Errorf("Expected invalid campaign error, got %v", err)
+	}
+}
+
+func TestPromoCodeService_ActiveAutomaticCampaigns(t *testing.T) {
+	endsAt := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	campaigns := []domain.Campaign{
+		{ID: "default", Type: domain.CampaignTypePercentage, PercentOff: 10, Default: true},
+		{ID: "autumn", Type: domain.CampaignTypePercentage, PercentOff: 5, Automatic: true, Stacking: domain.CampaignStackingStackable,
+			Schedule: &domain.CampaignSchedule{EndsAt: &endsAt}},
+		{ID: "free-drink", Type: domain.CampaignTypeFreeItem, FreeProductID: "drink", Automatic: true},
+	}
+	for _, c := range campaigns {
+		if err := ValidateCampaign(c); err != nil {
+			t.Fatalf("Campaign %s should be valid: %v", c.ID, err)
+		}
+	}
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production", Campaigns: campaigns, Now: func() time.Time { return now }})
+	defer service.Close()
+
+	if active := service.ActiveAutomaticCampaigns(); len(active) != 2 || active[0].ID != "autumn" || active[1].ID != "free-drink" {
+		t.Errorf("Expected autumn and free-drink to be active, got %v", active)
+	}
+	if campaign, _ := service.ResolveCampaign("ANYCODE1"); campaign.ID != "default" {
+		t.Errorf("Automatic campaigns must not match codes, got %s", campaign.ID)
+	}
+
+	now = endsAt
+	if active := service.ActiveAutomaticCampaigns(); len(active) != 1 || active[0].ID != "free-drink" {
+		t.Errorf("Expected only free-drink after autumn ended, got %v", active)
+	}
+
+	invalid := []domain.Campaign{
+		{ID: "auto-with-codes", Type: domain.CampaignTypePercentage, PercentOff: 5, Automatic: true, CodePrefix: "AUTO"},
+		{ID: "auto-limited", Type: domain.CampaignTypePercentage, PercentOff: 5, Automatic: true, MaxUses: 10},
+		{ID: "bad-stacking", Type: domain.CampaignTypePercentage, PercentOff: 5, Default: true, Stacking: "sometimes"},
+	}
+	for _, c := range invalid {
+		if err := ValidateCampaign(c); !errors.Is(err, domain.ErrInvalidCampaign) {
+			t.Errorf("Campaign %s: expected invalid campaign error, got %v", c.ID, err)
+		}
+	}
+}
+
+func TestPromoCodeService_ValidatePromoCodeForCart(t *testing.T) {
+	campaigns := []domain.Campaign{{
+		ID: "burger-lovers", Type: domain.CampaignTypePercentage, PercentOff: 20, CodePrefix: "BURGER",
+		Conditions: &domain.CampaignConditions{ProductFilter: domain.ProductFilter{Categories: []string{"Burgers"}}, MinQuantity: 2},
+		Target:     &domain.ProductFilter{Categories: []string{"Burgers"}},
+	}}
+	if err := ValidateCampaign(campaigns[0]); err != nil {
+		t.Fatalf("Campaign should be valid: %v", err)
+	}
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production", Campaigns: campaigns})
+	defer service.Close()
+	service.(*PromoCodeService).repo.(*inMemoryPromoCodeRepository).promoCodeCounts["BURGERLOVE"] = 2
+	service.(*PromoCodeService).ready.Store(true)
+
+	burger := domain.Product{ID: "prod1", Price: 1299, Category: "Burgers"}
+	cart := func(quantity int) []pricing.Line {
+		return []pricing.Line{{Item: domain.OrderLineItem{ProductID: burger.ID, Quantity: quantity}, Product: burger}}
+	}
+
+	result := service.ValidatePromoCodeForCart("BURGERLOVE", "", cart(1))
+	if !result.Valid || result.Reason != domain.PromoReasonNotApplicable || result.Applicable == nil || *result.Applicable {
+		t.Errorf("Expected a valid code that is NOT_APPLICABLE to one burger, got %+v", result)
+	}
+	result = service.ValidatePromoCodeForCart("BURGERLOVE", "", cart(2))
+	if !result.Valid || result.Reason != domain.PromoReasonValid || result.Applicable == nil || !*result.Applicable {
+		t.Errorf("Expected the code to apply to two burgers, got %+v", result)
+	}
+	if result := service.ValidatePromoCodeForCart("SHORT", "", cart(2)); result.Valid || result.Applicable != nil {
+		t.Errorf("Expected an invalid code not to be checked against the cart, got %+v", result)
+	}
+
+	broken := domain.Campaign{ID: "broken", Type: domain.CampaignTypePercentage, PercentOff: 10, Default: true, Target: &domain.ProductFilter{}}
+	if err := ValidateCampaign(broken); !errors.Is(err, domain.ErrInvalidCampaign) {
+		t.Errorf("Expected an empty target to be rejected, got %v", err)
+	}
+}
+
+// countingRepository records how often the batched lookup is used.
+type countingRepository struct {
+	PromoCodeRepository
+	getCountsCalls int
+	getCountCalls  int
+}
+
+func (r *countingRepository) GetCount(code string) (int, bool) {
+	r.getCountCalls++
+	return r.PromoCodeRepository.GetCount(code)
+}
+
+func (r *countingRepository) GetCounts(ctx context.Context, codes []string) (map[string]int, error) {
+	r.getCountsCalls++
+	return r.PromoCodeRepository.GetCounts(ctx, codes)
+}
+
+func TestPromoCodeService_ValidatePromoCodes(t *testing.T) {
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production", Campaigns: DefaultCampaigns})
+	defer service.Close()
+
+	inMemRepo := NewInMemoryPromoCodeRepository()
+	inMemRepo.promoCodeCounts["VALIDCODE"] = 2
+	inMemRepo.promoCodeCounts["SINGLEFILE"] = 1
+	repo := &countingRepository{PromoCodeRepository: inMemRepo}
+	service.(*PromoCodeService).repo = repo
+	service.(*PromoCodeService).ready.Store(true)
+
+	reqs := []domain.ValidatePromoteCodeRequest{
+		{PromoteCode: "SINGLEFILE"},
+		{PromoteCode: "SHORT"},
+		{PromoteCode: "VALIDCODE"},
+		{PromoteCode: "UNKNOWN12"},
+		{PromoteCode: "WAYTOOLONGCODE"},
+	}
+	results, err := service.ValidatePromoCodes(context.Background(), reqs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{
+		domain.PromoReasonNotEnoughSources,
+		domain.PromoReasonTooShort,
+		domain.PromoReasonValid,
+		domain.PromoReasonNotEnoughSources,
+		domain.PromoReasonTooLong,
+	}
+	for i, result := range results {
+		if result.Reason != expected[i] {
+			t.Errorf("Code %s: expected reason %s, got %s", reqs[i].PromoteCode, expected[i], result.Reason)
+		}
+	}
+	if results[2].Campaign == nil || results[2].Campaign.ID != "default-10-percent" {
+		t.Errorf("Expected the valid code to carry its campaign, got %+v", results[2])
+	}
+	if repo.getCountsCalls != 1 || repo.getCountCalls != 0 {
+		t.Errorf("Expected one batched lookup and no single lookups, got %d and %d", repo.getCountsCalls, repo.getCountCalls)
+	}
+}
+
+type countingRedemptionStore struct {
+	RedemptionStore
+	usageCalls     int
+	usageManyCalls int
+}
+
+func (r *countingRedemptionStore) Usage(code, customerID string) (RedemptionUsage, error) {
+	r.usageCalls++
+	return r.RedemptionStore.Usage(code, customerID)
+}
+
+func (r *countingRedemptionStore) UsageMany(codes []string, customerID string) (map[string]RedemptionUsage, error) {
+	r.usageManyCalls++
+	return r.RedemptionStore.UsageMany(codes, customerID)
+}
+
+func TestPromoCodeService_ValidatePromoCodes_Usage(t *testing.T) {
+	campaigns := []domain.Campaign{
+		{ID: "limited", Type: domain.CampaignTypePercentage, PercentOff: 10, MaxUses: 1, Codes: []string{"LIMITED10", "LIMITED20"}},
+		{ID: "per-customer", Type: domain.CampaignTypePercentage, PercentOff: 10, MaxUsesPerCustomer: 1, Codes: []string{"WELCOME01"}},
+		{ID: "unlimited", Type: domain.CampaignTypePercentage, PercentOff: 10, Default: true},
+	}
+	redemptions := &countingRedemptionStore{RedemptionStore: NewInMemoryRedemptionStore()}
+	service := NewService(Config{MaxDecompressedFileSizeMB: 1, Environment: "production", Campaigns: campaigns, Redemptions: redemptions})
+	defer service.Close()
+
+	inMemRepo := service.(*PromoCodeService).repo.(*inMemoryPromoCodeRepository)
+	for _, code := range []string{"LIMITED10", "LIMITED20", "WELCOME01", "VALIDCODE"} {
+		inMemRepo.promoCodeCounts[code] = 2
+	}
+	service.(*PromoCodeService).ready.Store(true)
+	for _, code := range []string{"LIMITED10", "WELCOME01"} {
+		id, err := service.ReserveRedemption(code, "alice")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := service.CommitRedemption(id, "order-alice"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	redemptions.usageCalls = 0
+
+	reqs := []domain.ValidatePromoteCodeRequest{
+		{PromoteCode: "LIMITED10", CustomerID: "alice"},
+		{PromoteCode: "LIMITED20", CustomerID: "alice"},
+		{PromoteCode: "WELCOME01", CustomerID: "alice"},
+		{PromoteCode: "WELCOME01", CustomerID: "bob"},
+		{PromoteCode: "VALIDCODE", CustomerID: "bob"},
+	}
+	results, err := service.ValidatePromoCodes(context.Background(), reqs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		domain.PromoReasonExhausted,
+		domain.PromoReasonValid,
+		domain.PromoReasonCustomerLimitReached,
+		domain.PromoReasonValid,
+		domain.PromoReasonValid,
+	}
+	for i, result := range results {
+		if result.Reason != expected[i] {
+			t.Errorf("Code %s for %s: expected reason %s, got %s", reqs[i].PromoteCode, reqs[i].CustomerID, expected[i], result.Reason)
+		}
+	}
+	if redemptions.usageManyCalls != 2 || redemptions.usageCalls != 0 {
+		t.Errorf("Expected one usage lookup per customer and none per code, got %d and %d", redemptions.usageManyCalls, redemptions.usageCalls)
+	}
 }
@@ -0,0 +1,122 @@
+package promos
+
+import (
+	"kart-challenge/internal/domain"
+	"sort"
+	"sync"
+)
+
+// VersionStore keeps a bounded history of loaded promo code datasets.
+type VersionStore interface {
+	// Record stores a new version with its code counts and returns it with an assigned ID.
+	Record(version domain.PromoDatasetVersion, counts map[string]int) domain.PromoDatasetVersion
+	// List returns the retained versions, newest first.
+	List() []domain.PromoDatasetVersion
+	// Counts returns the code counts captured with a version.
+	Counts(id int) (map[string]int, bool)
+}
+
+type storedVersion struct {
+	meta   domain.PromoDatasetVersion
+	counts map[string]int
+}
+
+// inMemoryVersionStore retains the last maxVersions datasets. Each version keeps its own copy of the
+// code counts, so memory grows linearly with the number of versions kept.
+type inMemoryVersionStore struct {
+	versions    []storedVersion // Oldest first
+	nextID      int
+	maxVersions int
+	mu          sync.RWMutex
+}
+
+// NewInMemoryVersionStore creates a version store that keeps at most maxVersions datasets.
+func NewInMemoryVersionStore(maxVersions int) VersionStore {
+	if maxVersions <= 0 {
+		maxVersions = 1
+	}
+	return &inMemoryVersionStore{nextID: 1, maxVersions: maxVersions}
+}
+
+func (r *inMemoryVersionStore) Record(version domain.PromoDatasetVersion, counts map[string]int) domain.PromoDatasetVersion {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	version.ID = r.nextID
+	r.nextID++
+	r.versions = append(r.versions, storedVersion{meta: version, counts: counts})
+	if excess := len(r.versions) - r.maxVersions; excess > 0 {
+		r.versions = append([]storedVersion(nil), r.versions[excess:]...)
+	}
+	return version
+}
+
+func (r *inMemoryVersionStore) List() []domain.PromoDatasetVersion {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]domain.PromoDatasetVersion, 0, len(r.versions))
+	for i := len(r.versions) - 1; i >= 0; i-- {
+		list = append(list, r.versions[i].meta)
+	}
+	return list
+}
+
+func (r *inMemoryVersionStore) Counts(id int) (map[string]int, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, v := range r.versions {
+		if v.meta.ID == id {
+			return v.counts, true
+		}
+	}
+	return nil, false
+}
+
+// diffCounts compares two code count snapshots. Each list is sorted and cut to limit entries
+// (limit <= 0 means no limit).
+func diffCounts(from, to map[string]int, limit int) domain.PromoDatasetDiff {
+	diff := domain.PromoDatasetDiff{
+		Added:           []string{},
+		Removed:         []string{},
+		ValidityChanged: []domain.PromoValidityChange{},
+	}
+
+	for code, toCount := range to {
+		fromCount, existed := from[code]
+		if !existed {
+			diff.Added = append(diff.Added, code)
+			continue
+		}
+		if wasValid, isValid := isValidCount(fromCount), isValidCount(toCount); wasValid != isValid {
+			diff.ValidityChanged = append(diff.ValidityChanged, domain.PromoValidityChange{Code: code, WasValid: wasValid, IsValid: isValid})
+		}
+	}
+	for code := range from {
+		if _, exists := to[code]; !exists {
+			diff.Removed = append(diff.Removed, code)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.ValidityChanged, func(i, j int) bool { return diff.ValidityChanged[i].Code < diff.ValidityChanged[j].Code })
+
+	diff.TotalAdded = len(diff.Added)
+	diff.TotalRemoved = len(diff.Removed)
+	diff.TotalValidityChanged = len(diff.ValidityChanged)
+
+	if limit > 0 {
+		if len(diff.Added) > limit {
+			diff.Added, diff.Truncated = diff.Added[:limit], true
+		}
+		if len(diff.Removed) > limit {
+			diff.Removed, diff.Truncated = diff.Removed[:limit], true
+		}
+		if len(diff.ValidityChanged) > limit {
+			diff.ValidityChanged, diff.Truncated = diff.ValidityChanged[:limit], true
+		}
+	}
+	return diff
+}
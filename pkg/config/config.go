@@ -1,9 +1,13 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Appconfig struct {
@@ -12,6 +16,52 @@ type Appconfig struct {
 	CouponFileURLs     []string
 	LocalCouponDirPath string // Path to local .gz coupon files (e.g., "./local_coupons")
 	MaxFileSizeMB      int
+	MaxDatasetVersions int    // Number of past promo code loads kept for diffing
+	CampaignsFilePath  string // JSON file of promo campaigns; empty uses the built-in 10% default
+	TaxRatePercent     float64
+
+	// Caps on combining stackable promotions on one order; 0 disables a cap.
+	MaxStackedPromotions      int
+	MaxStackedDiscountPercent float64
+
+	// PostgreSQL connection string; when set, idempotency keys are shared through the database.
+	DatabaseURL string
+	// How long responses to requests with an Idempotency-Key are kept for replay.
+	IdempotencyKeyTTL time.Duration
+	// How long a promo code use reserved for an order in PostgreSQL is held before it counts as abandoned.
+	PromoReservationTimeout time.Duration
+
+	// What happens to an order whose coupon cannot be applied: "reject" (422), "warn" or "ignore".
+	InvalidCouponPolicy string
+
+	// Cart limits on order requests; 0 disables a limit.
+	CartMergeDuplicateLines bool
+	CartMaxQuantityPerLine  int
+	CartMaxDistinctItems    int
+
+	// Order events kept in memory for event stream clients resuming with Last-Event-ID.
+	OrderEventsReplaySize int
+
+	// How promo validation behaves before the dataset has loaded: "reject" (503) or "optimistic".
+	PromoNotLoadedPolicy     string
+	PromoNotLoadedRetryAfter time.Duration
+
+	// Brute-force protection on promo validation (limits are per minute).
+	PromoRateLimitPerIP       int
+	PromoRateLimitPerAPIKey   int
+	PromoLockoutAfterFailures int
+	PromoLockoutDuration      time.Duration
+	PromoTrustedAPIKeys       map[string]bool
+
+	// X-Admin-Key values accepted by the admin and debug endpoints, with the name of their holder,
+	// which is recorded as the actor of order changes; none disables them.
+	AdminAPIKeys map[string]string
+
+	// Coupon source integrity. Checksums and signature locations are keyed by file name (e.g. "couponbase1.gz").
+	CouponFileSHA256       map[string]string
+	CouponFileSignatures   map[string]string
+	CouponSigningPublicKey ed25519.PublicKey
+	QuarantineDirPath      string
 }
 
 func LoadConfig() *Appconfig {
@@ -48,11 +98,210 @@ func LoadConfig() *Appconfig {
 		log.Printf("WARN: MAX_FILE_SIZE_MB not set or invalid, using default: %dMB", maxFileSizeMB)
 	}
 
+	maxDatasetVersions, err := strconv.Atoi(os.Getenv("PROMO_DATASET_VERSIONS"))
+	if err != nil || maxDatasetVersions <= 0 {
+		maxDatasetVersions = 5
+	}
+
+	notLoadedPolicy := os.Getenv("PROMO_NOT_LOADED_POLICY")
+	switch notLoadedPolicy {
+	case "reject", "optimistic":
+	case "":
+		notLoadedPolicy = "reject"
+	default:
+		log.Printf("WARN: PROMO_NOT_LOADED_POLICY '%s' is not recognised, using 'reject'", notLoadedPolicy)
+		notLoadedPolicy = "reject"
+	}
+	retryAfterSeconds, err := strconv.Atoi(os.Getenv("PROMO_NOT_LOADED_RETRY_AFTER_SECONDS"))
+	if err != nil || retryAfterSeconds <= 0 {
+		retryAfterSeconds = 30
+	}
+
+	rateLimitPerIP, err := strconv.Atoi(os.Getenv("PROMO_RATE_LIMIT_PER_IP"))
+	if err != nil || rateLimitPerIP <= 0 {
+		rateLimitPerIP = 60
+	}
+	rateLimitPerAPIKey, err := strconv.Atoi(os.Getenv("PROMO_RATE_LIMIT_PER_API_KEY"))
+	if err != nil || rateLimitPerAPIKey <= 0 {
+		rateLimitPerAPIKey = 600
+	}
+	lockoutAfterFailures, err := strconv.Atoi(os.Getenv("PROMO_LOCKOUT_AFTER_FAILURES"))
+	if err != nil || lockoutAfterFailures <= 0 {
+		lockoutAfterFailures = 20
+	}
+	lockoutMinutes, err := strconv.Atoi(os.Getenv("PROMO_LOCKOUT_MINUTES"))
+	if err != nil || lockoutMinutes <= 0 {
+		lockoutMinutes = 15
+	}
+	trustedAPIKeys := make(map[string]bool)
+	for _, key := range strings.Split(os.Getenv("PROMO_TRUSTED_API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			trustedAPIKeys[key] = true
+		}
+	}
+
+	// ADMIN_API_KEYS="alice=<key>,kitchen=<key>"; a key without a name is held by "admin".
+	adminAPIKeys := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("ADMIN_API_KEYS"), ",") {
+		name, key, named := strings.Cut(strings.TrimSpace(entry), "=")
+		if !named {
+			name, key = "admin", name
+		}
+		if name, key = strings.TrimSpace(name), strings.TrimSpace(key); name != "" && key != "" {
+			adminAPIKeys[key] = name
+		}
+	}
+
+	// Optional integrity checks, e.g. COUPON_FILE_SHA256="couponbase1.gz=<hex>,couponbase2.gz=<hex>"
+	couponFileSHA256 := parseKeyValueList(os.Getenv("COUPON_FILE_SHA256"))
+	couponFileSignatures := parseKeyValueList(os.Getenv("COUPON_FILE_SIGNATURES"))
+
+	var publicKey ed25519.PublicKey
+	if encodedKey := os.Getenv("COUPON_SIGNING_PUBLIC_KEY"); encodedKey != "" {
+		decoded, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			log.Fatalf("COUPON_SIGNING_PUBLIC_KEY must be a base64 encoded %d byte Ed25519 public key", ed25519.PublicKeySize)
+		}
+		publicKey = ed25519.PublicKey(decoded)
+	}
+	if len(couponFileSignatures) > 0 && publicKey == nil {
+		log.Fatalf("COUPON_FILE_SIGNATURES is set but COUPON_SIGNING_PUBLIC_KEY is missing")
+	}
+
+	quarantineDirPath := os.Getenv("COUPON_QUARANTINE_DIR")
+	if quarantineDirPath == "" {
+		quarantineDirPath = "./quarantine"
+	}
+
+	campaignsFilePath := os.Getenv("PROMO_CAMPAIGNS_FILE")
+
+	invalidCouponPolicy := os.Getenv("INVALID_COUPON_POLICY")
+	switch invalidCouponPolicy {
+	case "reject", "warn", "ignore":
+	case "":
+		invalidCouponPolicy = "warn"
+	default:
+		log.Printf("WARN: INVALID_COUPON_POLICY '%s' is not recognised, using 'warn'", invalidCouponPolicy)
+		invalidCouponPolicy = "warn"
+	}
+
+	taxRatePercent := 0.0
+	if raw := os.Getenv("TAX_RATE_PERCENT"); raw != "" {
+		taxRatePercent, err = strconv.ParseFloat(raw, 64)
+		if err != nil || taxRatePercent < 0 {
+			log.Fatalf("TAX_RATE_PERCENT must be a non-negative number, got '%s'", raw)
+		}
+	}
+
+	maxStackedPromotions := 3
+	if raw := os.Getenv("MAX_STACKED_PROMOTIONS"); raw != "" {
+		maxStackedPromotions, err = strconv.Atoi(raw)
+		if err != nil || maxStackedPromotions < 0 {
+			log.Fatalf("MAX_STACKED_PROMOTIONS must be a non-negative integer, got '%s'", raw)
+		}
+	}
+	maxStackedDiscountPercent := 50.0
+	if raw := os.Getenv("MAX_STACKED_DISCOUNT_PERCENT"); raw != "" {
+		maxStackedDiscountPercent, err = strconv.ParseFloat(raw, 64)
+		if err != nil || maxStackedDiscountPercent < 0 || maxStackedDiscountPercent > 100 {
+			log.Fatalf("MAX_STACKED_DISCOUNT_PERCENT must be a number between 0 and 100, got '%s'", raw)
+		}
+	}
+
+	cartMergeDuplicateLines := true
+	if raw := os.Getenv("CART_MERGE_DUPLICATE_LINES"); raw != "" {
+		cartMergeDuplicateLines, err = strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatalf("CART_MERGE_DUPLICATE_LINES must be true or false, got '%s'", raw)
+		}
+	}
+	cartMaxQuantityPerLine := 99
+	if raw := os.Getenv("CART_MAX_QUANTITY_PER_LINE"); raw != "" {
+		cartMaxQuantityPerLine, err = strconv.Atoi(raw)
+		if err != nil || cartMaxQuantityPerLine < 0 {
+			log.Fatalf("CART_MAX_QUANTITY_PER_LINE must be a non-negative integer, got '%s'", raw)
+		}
+	}
+	cartMaxDistinctItems := 50
+	if raw := os.Getenv("CART_MAX_DISTINCT_ITEMS"); raw != "" {
+		cartMaxDistinctItems, err = strconv.Atoi(raw)
+		if err != nil || cartMaxDistinctItems < 0 {
+			log.Fatalf("CART_MAX_DISTINCT_ITEMS must be a non-negative integer, got '%s'", raw)
+		}
+	}
+
+	orderEventsReplaySize := 1000
+	if raw := os.Getenv("ORDER_EVENTS_REPLAY_SIZE"); raw != "" {
+		orderEventsReplaySize, err = strconv.Atoi(raw)
+		if err != nil || orderEventsReplaySize <= 0 {
+			log.Fatalf("ORDER_EVENTS_REPLAY_SIZE must be a positive integer, got '%s'", raw)
+		}
+	}
+
+	idempotencyKeyTTLHours := 24
+	if raw := os.Getenv("IDEMPOTENCY_KEY_TTL_HOURS"); raw != "" {
+		idempotencyKeyTTLHours, err = strconv.Atoi(raw)
+		if err != nil || idempotencyKeyTTLHours <= 0 {
+			log.Fatalf("IDEMPOTENCY_KEY_TTL_HOURS must be a positive integer, got '%s'", raw)
+		}
+	}
+
+	reservationTimeoutSeconds := 300
+	if raw := os.Getenv("PROMO_RESERVATION_TIMEOUT_SECONDS"); raw != "" {
+		reservationTimeoutSeconds, err = strconv.Atoi(raw)
+		if err != nil || reservationTimeoutSeconds <= 0 {
+			log.Fatalf("PROMO_RESERVATION_TIMEOUT_SECONDS must be a positive integer, got '%s'", raw)
+		}
+	}
+
 	return &Appconfig{
-		Port:               port,
-		Environment:        env,
-		CouponFileURLs:     couponURLs,
-		LocalCouponDirPath: localCouponDirPath,
-		MaxFileSizeMB:      maxFileSizeMB,
+		Port:                      port,
+		Environment:               env,
+		CouponFileURLs:            couponURLs,
+		LocalCouponDirPath:        localCouponDirPath,
+		MaxFileSizeMB:             maxFileSizeMB,
+		MaxDatasetVersions:        maxDatasetVersions,
+		CampaignsFilePath:         campaignsFilePath,
+		TaxRatePercent:            taxRatePercent,
+		MaxStackedPromotions:      maxStackedPromotions,
+		MaxStackedDiscountPercent: maxStackedDiscountPercent,
+		DatabaseURL:               os.Getenv("DATABASE_URL"),
+		IdempotencyKeyTTL:         time.Duration(idempotencyKeyTTLHours) * time.Hour,
+		PromoReservationTimeout:   time.Duration(reservationTimeoutSeconds) * time.Second,
+		InvalidCouponPolicy:       invalidCouponPolicy,
+		CartMergeDuplicateLines:   cartMergeDuplicateLines,
+		CartMaxQuantityPerLine:    cartMaxQuantityPerLine,
+		CartMaxDistinctItems:      cartMaxDistinctItems,
+		OrderEventsReplaySize:     orderEventsReplaySize,
+		PromoNotLoadedPolicy:      notLoadedPolicy,
+		PromoNotLoadedRetryAfter:  time.Duration(retryAfterSeconds) * time.Second,
+		PromoRateLimitPerIP:       rateLimitPerIP,
+		PromoRateLimitPerAPIKey:   rateLimitPerAPIKey,
+		PromoLockoutAfterFailures: lockoutAfterFailures,
+		PromoLockoutDuration:      time.Duration(lockoutMinutes) * time.Minute,
+		PromoTrustedAPIKeys:       trustedAPIKeys,
+		AdminAPIKeys:              adminAPIKeys,
+		CouponFileSHA256:          couponFileSHA256,
+		CouponFileSignatures:      couponFileSignatures,
+		CouponSigningPublicKey:    publicKey,
+		QuarantineDirPath:         quarantineDirPath,
+	}
+}
+
+// parseKeyValueList parses "key1=value1,key2=value2" into a map, skipping malformed entries.
+func parseKeyValueList(raw string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" || strings.TrimSpace(value) == "" {
+			log.Printf("WARN: Ignoring malformed entry %q, expected key=value", pair)
+			continue
+		}
+		result[strings.TrimSpace(key)] = strings.TrimSpace(value)
 	}
+	return result
 }
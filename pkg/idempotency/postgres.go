@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// postgresStore keeps records in PostgreSQL, so every server instance sees the same keys. Claims are
+// taken with a single upsert and expiry uses the database clock, so instances cannot disagree on it.
+type postgresStore struct {
+	db        *sql.DB
+	begins    atomic.Int64
+	sweepEach int64
+}
+
+// NewPostgresStore creates a store on an open database and ensures its table exists.
+func NewPostgresStore(db *sql.DB) (Store, error) {
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT PRIMARY KEY,
+		fingerprint TEXT NOT NULL,
+		completed BOOLEAN NOT NULL DEFAULT FALSE,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		body BYTEA,
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+	ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS claim_token TEXT NOT NULL DEFAULT '';`
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return nil, fmt.Errorf("failed to create idempotency_keys table: %w", err)
+	}
+	return &postgresStore{db: db, sweepEach: 1000}, nil
+}
+
+func (s *postgresStore) Begin(key, fingerprint string, lockTTL time.Duration) (Record, string, error) {
+	if s.begins.Add(1)%s.sweepEach == 0 {
+		if _, err := s.db.Exec("DELETE FROM idempotency_keys WHERE expires_at < now()"); err != nil {
+			log.Printf("WARN: Failed to sweep expired idempotency keys: %v", err)
+		}
+	}
+
+	// A key that expired between the two statements is simply claimed on the next attempt.
+	token := uuid.New().String()
+	for attempt := 0; attempt < 2; attempt++ {
+		var claimedKey string
+		err := s.db.QueryRow(`
+		INSERT INTO idempotency_keys (key, fingerprint, claim_token, expires_at)
+		VALUES ($1, $2, $3, now() + make_interval(secs => $4))
+		ON CONFLICT (key) DO UPDATE
+			SET fingerprint = EXCLUDED.fingerprint, claim_token = EXCLUDED.claim_token, completed = FALSE,
+				status_code = 0, content_type = '', body = NULL, expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at < now()
+		RETURNING key`, key, fingerprint, token, lockTTL.Seconds()).Scan(&claimedKey)
+		if err == nil {
+			return Record{Fingerprint: fingerprint}, token, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Record{}, "", fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+
+		var record Record
+		err = s.db.QueryRow(`
+		SELECT fingerprint, completed, status_code, content_type, COALESCE(body, ''::bytea)
+		FROM idempotency_keys WHERE key = $1 AND expires_at >= now()`, key).
+			Scan(&record.Fingerprint, &record.Completed, &record.StatusCode, &record.ContentType, &record.Body)
+		if err == nil {
+			return record, "", nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Record{}, "", fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+	}
+	return Record{}, "", fmt.Errorf("failed to claim idempotency key: it keeps changing")
+}
+
+func (s *postgresStore) Complete(key, token string, record Record, ttl time.Duration) error {
+	result, err := s.db.Exec(`
+	UPDATE idempotency_keys
+	SET completed = TRUE, status_code = $3, content_type = $4, body = $5, expires_at = now() + make_interval(secs => $6)
+	WHERE key = $1 AND claim_token = $2 AND NOT completed`, key, token, record.StatusCode, record.ContentType, record.Body, ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+func (s *postgresStore) Release(key, token string) error {
+	result, err := s.db.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND claim_token = $2 AND NOT completed", key, token)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package idempotency
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrClaimLost is returned by Complete and Release when the claim token no longer holds the key,
+// e.g. because the claim expired and another request claimed the key since.
+var ErrClaimLost = errors.New("idempotency key claim is no longer held")
+
+// Record is what a store keeps for one idempotency key: the request it was first used with and,
+// once that request has finished, its response.
+type Record struct {
+	Fingerprint string // Hash of the request body the key was first used with
+	Completed   bool   // False while the first request is still in flight
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps idempotency records by key. Begin must claim a key atomically, so of several concurrent
+// requests with the same key exactly one gets to run. Each claim has its own token, so a request whose
+// claim expired cannot store or drop the record of the request that claimed the key after it.
+type Store interface {
+	// Begin claims key for a request with the given fingerprint and holds the claim for lockTTL,
+	// returning the claim token. If the key is already claimed or completed, token is empty and the
+	// existing record is returned.
+	Begin(key, fingerprint string, lockTTL time.Duration) (record Record, token string, err error)
+	// Complete stores the response of the request holding the claim and keeps it for ttl.
+	Complete(key, token string, record Record, ttl time.Duration) error
+	// Release drops a claim without storing a response, so the request can be retried.
+	Release(key, token string) error
+}
+
+type memoryEntry struct {
+	record    Record
+	token     string // Claim token of the request the record belongs to
+	expiresAt time.Time
+}
+
+// memoryStore keeps records in process memory. Expired records are swept periodically.
+type memoryStore struct {
+	entries   map[string]memoryEntry
+	begins    int
+	sweepEach int
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryStore creates a store local to this process.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]memoryEntry), sweepEach: 1000, now: time.Now}
+}
+
+func (s *memoryStore) Begin(key, fingerprint string, lockTTL time.Duration) (Record, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.begins++
+	if s.begins%s.sweepEach == 0 {
+		for k, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	if entry, ok := s.entries[key]; ok && !now.After(entry.expiresAt) {
+		return entry.record, "", nil
+	}
+	record := Record{Fingerprint: fingerprint}
+	token := uuid.New().String()
+	s.entries[key] = memoryEntry{record: record, token: token, expiresAt: now.Add(lockTTL)}
+	return record, token, nil
+}
+
+// claimed reports whether token holds the in-flight claim on key; callers hold the lock. A claim that
+// expired still holds until another request claims the key.
+func (s *memoryStore) claimed(key, token string) bool {
+	entry, ok := s.entries[key]
+	return ok && entry.token == token && !entry.record.Completed
+}
+
+func (s *memoryStore) Complete(key, token string, record Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.claimed(key, token) {
+		return ErrClaimLost
+	}
+	record.Completed = true
+	s.entries[key] = memoryEntry{record: record, token: token, expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) Release(key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.claimed(key, token) {
+		return ErrClaimLost
+	}
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testStore runs the checks every Store must pass. expire makes claims taken with lockTTL run out.
+func testStore(t *testing.T, store Store, lockTTL time.Duration, expire func()) {
+	t.Run("Only the first request claims a key", func(t *testing.T) {
+		_, token, err := store.Begin("key-1", "body-a", lockTTL)
+		if err != nil || token == "" {
+			t.Fatalf("Expected the key to be claimed, got token %q (err %v)", token, err)
+		}
+		record, again, err := store.Begin("key-1", "body-a", lockTTL)
+		if err != nil || again != "" {
+			t.Fatalf("Expected the claimed key not to be claimed again, got token %q (err %v)", again, err)
+		}
+		if record.Completed || record.Fingerprint != "body-a" {
+			t.Errorf("Expected the in-flight record, got %+v", record)
+		}
+
+		if err := store.Complete("key-1", "someone-else", Record{Fingerprint: "body-a"}, time.Hour); !errors.Is(err, ErrClaimLost) {
+			t.Errorf("Expected completing with another token to fail, got %v", err)
+		}
+		if err := store.Release("key-1", "someone-else"); !errors.Is(err, ErrClaimLost) {
+			t.Errorf("Expected releasing with another token to fail, got %v", err)
+		}
+
+		response := Record{Fingerprint: "body-a", StatusCode: 201, ContentType: "application/json", Body: []byte(`{"id":1}`)}
+		if err := store.Complete("key-1", token, response, time.Hour); err != nil {
+			t.Fatalf("Unexpected complete error: %v", err)
+		}
+		record, again, err = store.Begin("key-1", "body-a", lockTTL)
+		if err != nil || again != "" {
+			t.Fatalf("Expected the completed key not to be claimed again, got token %q (err %v)", again, err)
+		}
+		if !record.Completed || record.StatusCode != 201 || string(record.Body) != `{"id":1}` {
+			t.Errorf("Expected the stored response, got %+v", record)
+		}
+		if err := store.Release("key-1", token); !errors.Is(err, ErrClaimLost) {
+			t.Errorf("Expected a completed key not to be releasable, got %v", err)
+		}
+	})
+
+	t.Run("Released keys can be claimed again", func(t *testing.T) {
+		_, token, err := store.Begin("key-2", "body-a", lockTTL)
+		if err != nil || token == "" {
+			t.Fatalf("Expected the key to be claimed, got token %q (err %v)", token, err)
+		}
+		if err := store.Release("key-2", token); err != nil {
+			t.Fatalf("Unexpected release error: %v", err)
+		}
+		if _, token, err := store.Begin("key-2", "body-b", lockTTL); err != nil || token == "" {
+			t.Errorf("Expected the released key to be claimed again, got token %q (err %v)", token, err)
+		}
+	})
+
+	t.Run("An expired claim cannot touch the new claim", func(t *testing.T) {
+		_, stale, err := store.Begin("key-3", "body-a", lockTTL)
+		if err != nil || stale == "" {
+			t.Fatalf("Expected the key to be claimed, got token %q (err %v)", stale, err)
+		}
+		expire()
+		_, token, err := store.Begin("key-3", "body-a", lockTTL)
+		if err != nil || token == "" || token == stale {
+			t.Fatalf("Expected the expired key to be claimed with a new token, got %q (err %v)", token, err)
+		}
+
+		if err := store.Complete("key-3", stale, Record{Fingerprint: "body-a", StatusCode: 500}, time.Hour); !errors.Is(err, ErrClaimLost) {
+			t.Errorf("Expected the stale claim not to complete, got %v", err)
+		}
+		if err := store.Release("key-3", stale); !errors.Is(err, ErrClaimLost) {
+			t.Errorf("Expected the stale claim not to release, got %v", err)
+		}
+		if err := store.Complete("key-3", token, Record{Fingerprint: "body-a", StatusCode: 201}, time.Hour); err != nil {
+			t.Errorf("Expected the new claim to complete, got %v", err)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, time.Minute, func() { now = now.Add(2 * time.Minute) })
+}
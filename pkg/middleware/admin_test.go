@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	newApp := func(keys map[string]string) *fiber.App {
+		app := fiber.New()
+		app.Get("/admin", NewAdminAuthMiddleware(keys), func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusOK).SendString(AdminName(c))
+		})
+		return app
+	}
+	send := func(t *testing.T, app *fiber.App, key string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodGet, "/admin", nil)
+		if key != "" {
+			req.Header.Set(AdminKeyHeader, key)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	app := newApp(map[string]string{"admin-secret": "alice", "ops-secret": "ops"})
+	tests := []struct {
+		key      string
+		expected int
+		name     string
+	}{
+		{"", fiber.StatusUnauthorized, ""},
+		{"admin-guess", fiber.StatusForbidden, ""},
+		{"admin-secret", fiber.StatusOK, "alice"},
+		{"ops-secret", fiber.StatusOK, "ops"},
+	}
+	for _, tt := range tests {
+		status, body := send(t, app, tt.key)
+		if status != tt.expected {
+			t.Errorf("Key %q: expected %d, got %d", tt.key, tt.expected, status)
+		}
+		if status == fiber.StatusOK && body != tt.name {
+			t.Errorf("Key %q: expected admin name %q, got %q", tt.key, tt.name, body)
+		}
+	}
+
+	if status, _ := send(t, newApp(nil), "anything"); status != fiber.StatusForbidden {
+		t.Errorf("Expected admin endpoints to be disabled without keys, got %d", status)
+	}
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"fmt"
+	"kart-challenge/pkg/ratelimit"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// APIKeyHeader identifies API clients; requests carrying it are limited per key as well as per IP.
+	APIKeyHeader = "X-API-Key"
+
+	failedAttemptsLocal = "bruteforce.failed_attempts"
+)
+
+// BruteForceConfig configures NewBruteForceMiddleware. Zero limits disable the matching check.
+type BruteForceConfig struct {
+	Store ratelimit.Store
+
+	Window               time.Duration // Rate limit window
+	MaxRequestsPerIP     int           // Requests per Window from one IP address
+	MaxRequestsPerAPIKey int           // Requests per Window with one API key
+
+	FailureWindow        time.Duration // Failed attempts older than this are forgotten
+	DelayAfterFailures   int           // Failed attempts before each further attempt must wait
+	BaseDelay            time.Duration // First wait; it doubles with every further failure
+	MaxDelay             time.Duration
+	LockoutAfterFailures int // Failed attempts that lock the client out
+	LockoutDuration      time.Duration
+
+	TrustedAPIKeys map[string]bool // Keys of internal jobs (e.g. CRM imports) that bypass all checks
+
+	Now func() time.Time // Defaults to time.Now
+}
+
+// ReportFailedAttempts tells the brute-force middleware that the current request contained n failed
+// guesses (e.g. invalid promo codes). Handlers call it; the middleware applies the penalties.
+func ReportFailedAttempts(c *fiber.Ctx, n int) {
+	if n <= 0 {
+		return
+	}
+	current, _ := c.Locals(failedAttemptsLocal).(int)
+	c.Locals(failedAttemptsLocal, current+n)
+}
+
+type clientKey struct {
+	key   string
+	label string // Safe to log
+	limit int
+}
+
+// NewBruteForceMiddleware rate limits requests per client IP and per API key, makes clients wait
+// increasingly long after repeated failed attempts and locks them out for a while once the failures
+// pile up. Lockouts and exceeded limits are logged as SECURITY events. If the store fails, requests
+// are let through so a store outage does not take the endpoint down.
+func NewBruteForceMiddleware(cfg BruteForceConfig) fiber.Handler {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	ttl := max(cfg.Window, cfg.FailureWindow, cfg.LockoutDuration, cfg.MaxDelay)
+
+	return func(c *fiber.Ctx) error {
+		apiKey := c.Get(APIKeyHeader)
+		if apiKey != "" && cfg.TrustedAPIKeys[apiKey] {
+			return c.Next()
+		}
+
+		now := cfg.Now()
+		keys := []clientKey{{key: "ip:" + c.IP(), label: "ip " + c.IP(), limit: cfg.MaxRequestsPerIP}}
+		if apiKey != "" {
+			keys = append(keys, clientKey{key: "key:" + apiKey, label: "api key " + maskKey(apiKey), limit: cfg.MaxRequestsPerAPIKey})
+		}
+
+		for _, k := range keys {
+			counter, err := cfg.Store.Update(k.key, ttl, func(ctr *ratelimit.Counter) {
+				if now.Sub(ctr.WindowStart) >= cfg.Window {
+					ctr.WindowStart = now
+					ctr.Requests = 0
+				}
+				ctr.Requests++
+			})
+			if err != nil {
+				log.Printf("ERROR: Brute-force limiter store failed, allowing request: %v", err)
+				continue
+			}
+
+			switch {
+			case now.Before(counter.LockedUntil):
+				return tooManyRequests(c, counter.LockedUntil.Sub(now), "Too many failed attempts; temporarily locked out.")
+			case now.Before(counter.NextAllowed):
+				return tooManyRequests(c, counter.NextAllowed.Sub(now), "Too many failed attempts; slow down.")
+			case k.limit > 0 && counter.Requests > k.limit:
+				if counter.Requests == k.limit+1 {
+					log.Printf("SECURITY: Rate limit of %d requests per %s exceeded by %s on %s %s", k.limit, cfg.Window, k.label, c.Method(), c.Path())
+				}
+				return tooManyRequests(c, counter.WindowStart.Add(cfg.Window).Sub(now), "Rate limit exceeded.")
+			}
+		}
+
+		err := c.Next()
+
+		if failures, _ := c.Locals(failedAttemptsLocal).(int); failures > 0 {
+			for _, k := range keys {
+				recordFailures(cfg, k, failures, now, ttl)
+			}
+		}
+		return err
+	}
+}
+
+// recordFailures adds failed attempts to a client's counter and applies the delay and lockout rules.
+func recordFailures(cfg BruteForceConfig, k clientKey, failures int, now time.Time, ttl time.Duration) {
+	var lockedOut, delayed bool
+	counter, err := cfg.Store.Update(k.key, ttl, func(ctr *ratelimit.Counter) {
+		if cfg.FailureWindow > 0 && now.Sub(ctr.FirstFailure) >= cfg.FailureWindow {
+			ctr.FirstFailure = now
+			ctr.Failures = 0
+		}
+		before := ctr.Failures
+		ctr.Failures += failures
+
+		if cfg.LockoutAfterFailures > 0 && ctr.Failures >= cfg.LockoutAfterFailures {
+			ctr.LockedUntil = now.Add(cfg.LockoutDuration)
+			ctr.Failures = 0
+			ctr.FirstFailure = now
+			lockedOut = true
+			return
+		}
+		if cfg.DelayAfterFailures > 0 && ctr.Failures >= cfg.DelayAfterFailures {
+			ctr.NextAllowed = now.Add(escalatingDelay(cfg, ctr.Failures-cfg.DelayAfterFailures))
+			delayed = before < cfg.DelayAfterFailures
+		}
+	})
+	if err != nil {
+		log.Printf("ERROR: Brute-force limiter store failed to record %d failed attempts: %v", failures, err)
+		return
+	}
+
+	if lockedOut {
+		log.Printf("SECURITY: %s locked out until %s after %d failed attempts", k.label, counter.LockedUntil.Format(time.RFC3339), cfg.LockoutAfterFailures)
+	} else if delayed {
+		log.Printf("SECURITY: %s reached %d failed attempts within %s, delaying further attempts", k.label, counter.Failures, cfg.FailureWindow)
+	}
+}
+
+// escalatingDelay returns BaseDelay doubled once per failure beyond the threshold, capped at MaxDelay.
+func escalatingDelay(cfg BruteForceConfig, extraFailures int) time.Duration {
+	delay := time.Duration(float64(cfg.BaseDelay) * math.Pow(2, float64(min(extraFailures, 30))))
+	if cfg.MaxDelay > 0 && delay > cfg.MaxDelay {
+		return cfg.MaxDelay
+	}
+	return delay
+}
+
+func tooManyRequests(c *fiber.Ctx, wait time.Duration, message string) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(seconds, 1)))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"message": message,
+		"code":    fiber.StatusTooManyRequests,
+	})
+}
+
+// maskKey keeps API keys out of the logs while leaving them recognisable.
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return fmt.Sprintf("%s****", key[:4])
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kart-challenge/pkg/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// localStorage is an in-process stand-in for a shared fiber.Storage such as Redis.
+type localStorage struct {
+	data map[string][]byte
+	mu   sync.Mutex
+}
+
+func (s *localStorage) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[key], nil
+}
+
+func (s *localStorage) Set(key string, val []byte, exp time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = val
+	return nil
+}
+
+func (s *localStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *localStorage) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make(map[string][]byte)
+	return nil
+}
+
+func (s *localStorage) Close() error { return nil }
+
+// newGuardedApp serves /validate behind the middleware; ?guess=bad reports a failed attempt.
+func newGuardedApp(store ratelimit.Store, now *time.Time) *fiber.App {
+	app := fiber.New()
+	guard := NewBruteForceMiddleware(BruteForceConfig{
+		Store:                store,
+		Window:               time.Minute,
+		MaxRequestsPerIP:     10,
+		MaxRequestsPerAPIKey: 3,
+		FailureWindow:        15 * time.Minute,
+		DelayAfterFailures:   3,
+		BaseDelay:            time.Second,
+		MaxDelay:             8 * time.Second,
+		LockoutAfterFailures: 6,
+		LockoutDuration:      15 * time.Minute,
+		TrustedAPIKeys:       map[string]bool{"trusted-job": true},
+		Now:                  func() time.Time { return *now },
+	})
+	app.Post("/validate", guard, func(c *fiber.Ctx) error {
+		if c.Query("guess") == "bad" {
+			ReportFailedAttempts(c, 1)
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func send(t *testing.T, app *fiber.App, query, apiKey string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodPost, "/validate"+query, nil)
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter)
+}
+
+func TestBruteForceMiddleware(t *testing.T) {
+	stores := map[string]func() ratelimit.Store{
+		"memory": ratelimit.NewMemoryStore,
+		"shared": func() ratelimit.Store {
+			return ratelimit.NewStorageStore(&localStorage{data: make(map[string][]byte)}, "promo:")
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("Rate limit per IP and per API key", func(t *testing.T) {
+				now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+				app := newGuardedApp(newStore(), &now)
+
+				for i := 0; i < 3; i++ {
+					if status, _ := send(t, app, "", "client-key"); status != fiber.StatusOK {
+						t.Fatalf("Request %d: expected 200, got %d", i+1, status)
+					}
+				}
+				if status, retryAfter := send(t, app, "", "client-key"); status != fiber.StatusTooManyRequests || retryAfter != "60" {
+					t.Errorf("Expected 429 with Retry-After 60 once the API key limit is used up, got %d %q", status, retryAfter)
+				}
+
+				// The same IP without the key still has IP budget left (4 of 10 used).
+				for i := 0; i < 6; i++ {
+					if status, _ := send(t, app, "", ""); status != fiber.StatusOK {
+						t.Fatalf("Request %d without key: expected 200, got %d", i+1, status)
+					}
+				}
+				if status, _ := send(t, app, "", ""); status != fiber.StatusTooManyRequests {
+					t.Errorf("Expected 429 once the IP limit is used up, got %d", status)
+				}
+
+				now = now.Add(time.Minute)
+				if status, _ := send(t, app, "", ""); status != fiber.StatusOK {
+					t.Errorf("Expected the limit to reset with the next window, got %d", status)
+				}
+			})
+
+			t.Run("Escalating delays then lockout", func(t *testing.T) {
+				now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+				app := newGuardedApp(newStore(), &now)
+
+				for i := 0; i < 3; i++ {
+					send(t, app, "?guess=bad", "")
+				}
+				// Third failure: wait BaseDelay before the next attempt.
+				if status, retryAfter := send(t, app, "?guess=bad", ""); status != fiber.StatusTooManyRequests || retryAfter != "1" {
+					t.Errorf("Expected a 1s delay after 3 failures, got %d %q", status, retryAfter)
+				}
+
+				now = now.Add(time.Second)
+				send(t, app, "?guess=bad", "") // Fourth failure doubles the delay
+				if _, retryAfter := send(t, app, "", ""); retryAfter != "2" {
+					t.Errorf("Expected a 2s delay after 4 failures, got %q", retryAfter)
+				}
+
+				now = now.Add(2 * time.Second)
+				send(t, app, "?guess=bad", "")
+				now = now.Add(4 * time.Second)
+				send(t, app, "?guess=bad", "") // Sixth failure locks the client out
+				now = now.Add(time.Minute)
+				if status, retryAfter := send(t, app, "", ""); status != fiber.StatusTooManyRequests || retryAfter != "840" {
+					t.Errorf("Expected a lockout with 14 minutes left, got %d %q", status, retryAfter)
+				}
+
+				now = now.Add(14 * time.Minute)
+				if status, _ := send(t, app, "", ""); status != fiber.StatusOK {
+					t.Errorf("Expected the lockout to expire, got %d", status)
+				}
+			})
+
+			t.Run("Trusted API keys bypass the checks", func(t *testing.T) {
+				now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+				app := newGuardedApp(newStore(), &now)
+
+				for i := 0; i < 20; i++ {
+					if status, _ := send(t, app, "?guess=bad", "trusted-job"); status != fiber.StatusOK {
+						t.Fatalf("Request %d with a trusted key: expected 200, got %d", i+1, status)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"kart-challenge/pkg/idempotency"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// IdempotencyKeyHeader lets clients retry a request without it taking effect twice.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks responses replayed from an earlier request with the same key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyConfig configures NewIdempotencyMiddleware.
+type IdempotencyConfig struct {
+	Store idempotency.Store
+
+	TTL          time.Duration // How long a response is kept for replay
+	LockTimeout  time.Duration // How long a request may hold its key; duplicates wait at most this long
+	PollInterval time.Duration // How often a waiting duplicate checks whether the first request finished
+
+	// Client identifies who sent a request, so one client's key never replays another's response.
+	// Defaults to IdempotencyClient.
+	Client func(c *fiber.Ctx) string
+}
+
+// IdempotencyClient identifies the sender of a request by its X-API-Key, else by the customer_id in
+// its JSON body, else by its IP address. API keys are hashed so they are never stored.
+func IdempotencyClient(c *fiber.Ctx) string {
+	if apiKey := c.Get(APIKeyHeader); apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	var body struct {
+		CustomerID string `json:"customer_id"`
+	}
+	if json.Unmarshal(c.Body(), &body) == nil && body.CustomerID != "" {
+		return "customer:" + body.CustomerID
+	}
+	return "ip:" + c.IP()
+}
+
+// NewIdempotencyMiddleware makes requests carrying an Idempotency-Key header safe to retry. The first
+// request with a key runs and its response is stored; retries with the same key and body get that
+// response replayed, while reusing the key with a different body is rejected with 422. A retry that
+// arrives while the first request is still running waits for it to finish. Server errors are not
+// stored, so the request can be retried. Keys are scoped to the client, the method and the path.
+func NewIdempotencyMiddleware(cfg IdempotencyConfig) fiber.Handler {
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 30 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = IdempotencyClient
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 50 * time.Millisecond
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Idempotency-Key must be at most " + strconv.Itoa(maxIdempotencyKeyLength) + " characters.",
+				"code":    fiber.StatusBadRequest,
+			})
+		}
+
+		storeKey := cfg.Client(c) + " " + c.Method() + " " + c.Path() + " " + key
+		sum := sha256.Sum256(c.Body())
+		fingerprint := hex.EncodeToString(sum[:])
+
+		deadline := time.Now().Add(cfg.LockTimeout)
+		for {
+			record, token, err := cfg.Store.Begin(storeKey, fingerprint, cfg.LockTimeout)
+			if err != nil {
+				log.Printf("ERROR: Idempotency store failed, refusing %s %s: %v", c.Method(), c.Path(), err)
+				c.Set(fiber.HeaderRetryAfter, "1")
+				return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+					"message": "The request cannot be deduplicated right now; retry later.",
+					"code":    fiber.StatusServiceUnavailable,
+				})
+			}
+
+			switch {
+			case token != "":
+				return runIdempotent(c, cfg, storeKey, token, fingerprint)
+			case record.Fingerprint != fingerprint:
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+					"message": "Idempotency-Key was already used with a different request body.",
+					"code":    fiber.StatusUnprocessableEntity,
+				})
+			case record.Completed:
+				c.Set(IdempotentReplayedHeader, "true")
+				if record.ContentType != "" {
+					c.Set(fiber.HeaderContentType, record.ContentType)
+				}
+				return c.Status(record.StatusCode).Send(record.Body)
+			}
+
+			if !time.Now().Before(deadline) {
+				c.Set(fiber.HeaderRetryAfter, "1")
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"message": "A request with this Idempotency-Key is still in progress.",
+					"code":    fiber.StatusConflict,
+				})
+			}
+			time.Sleep(cfg.PollInterval)
+		}
+	}
+}
+
+// runIdempotent runs the request holding the key and stores its response, or gives the key up if the
+// request failed on the server side. If the claim expired and another request took the key meanwhile,
+// that request's record is left alone.
+func runIdempotent(c *fiber.Ctx, cfg IdempotencyConfig, storeKey, token, fingerprint string) error {
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil || status >= fiber.StatusInternalServerError {
+		if releaseErr := cfg.Store.Release(storeKey, token); releaseErr != nil && !errors.Is(releaseErr, idempotency.ErrClaimLost) {
+			log.Printf("ERROR: Failed to release idempotency key after a failed request: %v", releaseErr)
+		}
+		return err
+	}
+
+	record := idempotency.Record{
+		Fingerprint: fingerprint,
+		StatusCode:  status,
+		ContentType: string(c.Response().Header.ContentType()),
+		Body:        append([]byte(nil), c.Response().Body()...),
+	}
+	if storeErr := cfg.Store.Complete(storeKey, token, record, cfg.TTL); storeErr != nil {
+		if errors.Is(storeErr, idempotency.ErrClaimLost) {
+			log.Printf("WARN: %s %s outlived its idempotency key claim; its response was not stored", c.Method(), c.Path())
+			return nil
+		}
+		log.Printf("ERROR: Failed to store idempotent response, retries will run again: %v", storeErr)
+		if releaseErr := cfg.Store.Release(storeKey, token); releaseErr != nil && !errors.Is(releaseErr, idempotency.ErrClaimLost) {
+			log.Printf("ERROR: Failed to release idempotency key: %v", releaseErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kart-challenge/pkg/idempotency"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newIdempotentApp serves /orders behind the middleware. Each order it creates gets the next number;
+// a body of "fail" answers 500.
+func newIdempotentApp(ttl time.Duration, created *atomic.Int32) *fiber.App {
+	app := fiber.New()
+	guard := NewIdempotencyMiddleware(IdempotencyConfig{
+		Store:        idempotency.NewMemoryStore(),
+		TTL:          ttl,
+		LockTimeout:  time.Second,
+		PollInterval: 5 * time.Millisecond,
+	})
+	app.Post("/orders", guard, func(c *fiber.Ctx) error {
+		if string(c.Body()) == "fail" {
+			created.Add(1)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "boom"})
+		}
+		time.Sleep(20 * time.Millisecond) // Long enough for concurrent retries to overlap
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"order": created.Add(1)})
+	})
+	return app
+}
+
+func sendIdempotent(t *testing.T, app *fiber.App, key, body string) (int, string, bool) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Errorf("Request failed: %v", err)
+		return 0, "", false
+	}
+	payload, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(payload), resp.Header.Get(IdempotentReplayedHeader) == "true"
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	t.Run("Retries replay the first response", func(t *testing.T) {
+		var created atomic.Int32
+		app := newIdempotentApp(time.Hour, &created)
+
+		status, first, replayed := sendIdempotent(t, app, "key-1", `{"items":[]}`)
+		if status != fiber.StatusCreated || replayed {
+			t.Fatalf("Expected the first request to run, got %d (replayed %t)", status, replayed)
+		}
+		status, retry, replayed := sendIdempotent(t, app, "key-1", `{"items":[]}`)
+		if status != fiber.StatusCreated || retry != first || !replayed {
+			t.Errorf("Expected the retry to replay %s, got %d %s (replayed %t)", first, status, retry, replayed)
+		}
+		if _, other, _ := sendIdempotent(t, app, "key-2", `{"items":[]}`); other == first {
+			t.Errorf("Expected another key to create another order, got %s", other)
+		}
+		sendIdempotent(t, app, "", `{"items":[]}`)
+		sendIdempotent(t, app, "", `{"items":[]}`)
+		if created.Load() != 4 {
+			t.Errorf("Expected 4 orders (requests without a key are not deduplicated), got %d", created.Load())
+		}
+	})
+
+	t.Run("Same key with a different body is rejected", func(t *testing.T) {
+		var created atomic.Int32
+		app := newIdempotentApp(time.Hour, &created)
+
+		sendIdempotent(t, app, "key-1", `{"items":[1]}`)
+		if status, _, _ := sendIdempotent(t, app, "key-1", `{"items":[2]}`); status != fiber.StatusUnprocessableEntity {
+			t.Errorf("Expected 422, got %d", status)
+		}
+		if created.Load() != 1 {
+			t.Errorf("Expected 1 order, got %d", created.Load())
+		}
+	})
+
+	t.Run("Concurrent duplicates run once", func(t *testing.T) {
+		var created atomic.Int32
+		app := newIdempotentApp(time.Hour, &created)
+
+		var wg sync.WaitGroup
+		bodies := make([]string, 10)
+		for i := range bodies {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				status, body, _ := sendIdempotent(t, app, "key-1", `{"items":[]}`)
+				if status != fiber.StatusCreated {
+					t.Errorf("Expected 201, got %d", status)
+				}
+				bodies[i] = body
+			}()
+		}
+		wg.Wait()
+		if created.Load() != 1 {
+			t.Errorf("Expected 1 order, got %d", created.Load())
+		}
+		for _, body := range bodies {
+			if body != bodies[0] {
+				t.Errorf("Expected every duplicate to get %s, got %s", bodies[0], body)
+			}
+		}
+	})
+
+	t.Run("Server errors are not stored", func(t *testing.T) {
+		var created atomic.Int32
+		app := newIdempotentApp(time.Hour, &created)
+
+		sendIdempotent(t, app, "key-1", "fail")
+		if status, _, replayed := sendIdempotent(t, app, "key-1", "fail"); status != fiber.StatusInternalServerError || replayed {
+			t.Errorf("Expected the retry to run again, got %d (replayed %t)", status, replayed)
+		}
+		if created.Load() != 2 {
+			t.Errorf("Expected both attempts to run, got %d", created.Load())
+		}
+	})
+
+	t.Run("Keys are scoped to the client", func(t *testing.T) {
+		var created atomic.Int32
+		app := newIdempotentApp(time.Hour, &created)
+
+		send := func(apiKey, body string) string {
+			req := httptest.NewRequest(fiber.MethodPost, "/orders", strings.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			req.Header.Set(IdempotencyKeyHeader, "key-1")
+			if apiKey != "" {
+				req.Header.Set(APIKeyHeader, apiKey)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if resp.Header.Get(IdempotentReplayedHeader) == "true" {
+				t.Errorf("Expected a request from another client not to be replayed")
+			}
+			payload, _ := io.ReadAll(resp.Body)
+			return string(payload)
+		}
+		first := send("client-a", `{"items":[]}`)
+		if other := send("client-b", `{"items":[]}`); other == first {
+			t.Errorf("Expected another API key to create another order, got %s", other)
+		}
+		send("", `{"customer_id":"alice","items":[]}`)
+		send("", `{"customer_id":"bob","items":[]}`)
+		if created.Load() != 4 {
+			t.Errorf("Expected 4 orders, got %d", created.Load())
+		}
+	})
+
+	t.Run("Keys expire after the TTL", func(t *testing.T) {
+		var created atomic.Int32
+		app := newIdempotentApp(30*time.Millisecond, &created)
+
+		sendIdempotent(t, app, "key-1", `{"items":[1]}`)
+		time.Sleep(50 * time.Millisecond)
+		if status, _, replayed := sendIdempotent(t, app, "key-1", `{"items":[2]}`); status != fiber.StatusCreated || replayed {
+			t.Errorf("Expected an expired key to be usable again, got %d (replayed %t)", status, replayed)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Counter is the recent activity of one client key (an IP address or an API key).
+type Counter struct {
+	WindowStart  time.Time `json:"window_start"`
+	Requests     int       `json:"requests"`      // Requests since WindowStart
+	FirstFailure time.Time `json:"first_failure"` // Start of the current run of failed attempts
+	Failures     int       `json:"failures"`      // Failed attempts since FirstFailure
+	NextAllowed  time.Time `json:"next_allowed"`  // Escalating delay after repeated failures
+	LockedUntil  time.Time `json:"locked_until"`
+}
+
+// Store keeps counters by key. Update must apply fn atomically with respect to other updates of the
+// same key, so concurrent requests cannot slip past a limit.
+type Store interface {
+	// Update applies fn to the counter for key (a zero Counter if none exists), keeps the result for
+	// ttl and returns it.
+	Update(key string, ttl time.Duration, fn func(*Counter)) (Counter, error)
+}
+
+type memoryEntry struct {
+	counter   Counter
+	expiresAt time.Time
+}
+
+// memoryStore keeps counters in process memory. Expired counters are swept periodically.
+type memoryStore struct {
+	entries   map[string]memoryEntry
+	updates   int
+	sweepEach int
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryStore creates a store local to this process.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]memoryEntry), sweepEach: 1000, now: time.Now}
+}
+
+func (s *memoryStore) Update(key string, ttl time.Duration, fn func(*Counter)) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.updates++
+	if s.updates%s.sweepEach == 0 {
+		for k, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = memoryEntry{}
+	}
+	fn(&entry.counter)
+	entry.expiresAt = now.Add(ttl)
+	s.entries[key] = entry
+	return entry.counter, nil
+}
+
+// storageStore keeps counters in a fiber.Storage, so several server instances can share them
+// (e.g. with a Redis storage). fiber.Storage has no compare-and-swap, so updates are serialized
+// within this process only; instances racing on the same key may undercount by a few requests.
+type storageStore struct {
+	storage fiber.Storage
+	prefix  string
+	mu      sync.Mutex
+}
+
+// NewStorageStore creates a store backed by any fiber.Storage implementation.
+func NewStorageStore(storage fiber.Storage, prefix string) Store {
+	return &storageStore{storage: storage, prefix: prefix}
+}
+
+func (s *storageStore) Update(key string, ttl time.Duration, fn func(*Counter)) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var counter Counter
+	raw, err := s.storage.Get(s.prefix + key)
+	if err != nil {
+		return Counter{}, fmt.Errorf("failed to read rate limit counter: %w", err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &counter); err != nil {
+			return Counter{}, fmt.Errorf("failed to decode rate limit counter: %w", err)
+		}
+	}
+
+	fn(&counter)
+
+	encoded, err := json.Marshal(counter)
+	if err != nil {
+		return Counter{}, fmt.Errorf("failed to encode rate limit counter: %w", err)
+	}
+	if err := s.storage.Set(s.prefix+key, encoded, ttl); err != nil {
+		return Counter{}, fmt.Errorf("failed to write rate limit counter: %w", err)
+	}
+	return counter, nil
+}
@@ -0,0 +1,56 @@
+package sse
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Event is a single server-sent event. Data is JSON encoded before it is written.
+type Event struct {
+	ID    string
+	Event string
+	Data  any
+}
+
+// SetHeaders prepares a response for an event stream.
+func SetHeaders(c *fiber.Ctx) {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // Stop reverse proxies from buffering the stream
+}
+
+// Write encodes an event and flushes it to the client.
+// A non-nil error usually means the client has gone away and the stream should stop.
+func Write(w *bufio.Writer, ev Event) error {
+	payload, err := json.Marshal(ev.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event data: %w", err)
+	}
+
+	var sb strings.Builder
+	if ev.ID != "" {
+		fmt.Fprintf(&sb, "id: %s\n", ev.ID)
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&sb, "event: %s\n", ev.Event)
+	}
+	fmt.Fprintf(&sb, "data: %s\n\n", payload)
+
+	if _, err := w.WriteString(sb.String()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// WriteComment sends a comment line, used as a keep-alive so idle connections are not dropped.
+func WriteComment(w *bufio.Writer, comment string) error {
+	if _, err := fmt.Fprintf(w, ": %s\n\n", comment); err != nil {
+		return err
+	}
+	return w.Flush()
+}